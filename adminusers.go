@@ -0,0 +1,633 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github/JohnDirewolf/chirpy/internal/auth"
+	"github/JohnDirewolf/chirpy/internal/database"
+	"github/JohnDirewolf/chirpy/internal/mailer"
+
+	"github.com/google/uuid"
+)
+
+const maxSuspensionReasonLength = 500
+
+// Everything an admin sees about an account, the public profile and what only they should see.
+type adminUserResponse struct {
+	Id            uuid.UUID  `json:"id"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	Email         string     `json:"email"`
+	EmailVerified bool       `json:"email_verified"`
+	Handle        string     `json:"handle"`
+	DisplayName   string     `json:"display_name"`
+	Role          string     `json:"role"`
+	IsChirpyRed   bool       `json:"is_chirpy_red"`
+	SuspendedAt   *time.Time `json:"suspended_at"`
+	//Why the account was suspended, only while it is.
+	SuspensionReason string `json:"suspension_reason,omitempty"`
+}
+
+type adminUsersPageResponse struct {
+	Users      []adminUserResponse `json:"users"`
+	Count      int64               `json:"count"`
+	NextCursor string              `json:"next_cursor,omitempty"`
+	PrevCursor string              `json:"prev_cursor,omitempty"`
+}
+
+func utilityAdminUserResponse(userData database.User) adminUserResponse {
+	return adminUserResponse{
+		Id:               userData.ID,
+		CreatedAt:        userData.CreatedAt,
+		UpdatedAt:        userData.UpdatedAt,
+		Email:            userData.Email,
+		EmailVerified:    userData.EmailVerifiedAt.Valid,
+		Handle:           userData.Handle,
+		DisplayName:      userData.DisplayName,
+		Role:             userData.Role,
+		IsChirpyRed:      userData.IsChirpyRed,
+		SuspendedAt:      utilityNullTime(userData.SuspendedAt),
+		SuspensionReason: userData.SuspensionReason.String,
+	}
+}
+
+// Suspended users are told so at login and refresh. Their access tokens already stopped working when
+// they were suspended, as every session is checked against the account.
+func utilityWriteSuspended(response http.ResponseWriter) {
+	response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+	response.WriteHeader(http.StatusForbidden)
+	response.Write([]byte("Forbidden: This account is suspended."))
+}
+
+// Looks up the user in the path for the admin routes that act on one, writing the error response if it cannot.
+func (cfg *apiConfig) utilityAdminTargetUser(response http.ResponseWriter, request *http.Request) (database.User, bool) {
+	userID, err := uuid.Parse(request.PathValue("userID"))
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: user id is malformed."))
+		return database.User{}, false
+	}
+	userData, err := cfg.dbQueries.GetUserByID(context.Background(), userID)
+	if err == sql.ErrNoRows {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusNotFound)
+		response.Write([]byte("Not Found: User not found."))
+		return database.User{}, false
+	}
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not fetch user."))
+		return database.User{}, false
+	}
+	return userData, true
+}
+
+// Reads the reason an admin gives for suspending or unsuspending, required unless optional is set.
+func utilityParseSuspensionReason(request *http.Request, optional bool) (string, error) {
+	type requestParameters struct {
+		Reason string `json:"reason"`
+	}
+
+	requestParams := requestParameters{}
+	err := json.NewDecoder(request.Body).Decode(&requestParams)
+	//An optional reason can be left out altogether, body and all.
+	if err != nil && !(optional && err == io.EOF) {
+		return "", errors.New("did not understand request")
+	}
+	reason := strings.TrimSpace(requestParams.Reason)
+	if reason == "" && !optional {
+		return "", errors.New("a reason is required")
+	}
+	if utf8.RuneCountInString(reason) > maxSuspensionReasonLength {
+		return "", fmt.Errorf("reason must be at most %d characters", maxSuspensionReasonLength)
+	}
+	return reason, nil
+}
+
+func (cfg *apiConfig) handlerAdminGetUsers(response http.ResponseWriter, request *http.Request) {
+	var userList []database.User
+	query := request.URL.Query()
+
+	//Optional filters, q matches part of an email or handle.
+	searchText := strings.TrimSpace(query.Get("q"))
+	search := sql.NullString{String: searchText, Valid: searchText != ""}
+	role := sql.NullString{String: query.Get("role"), Valid: query.Get("role") != ""}
+	if role.Valid && !slices.Contains(knownRoles, role.String) {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: Role must be one of " + strings.Join(knownRoles, ", ") + "."))
+		return
+	}
+	suspended := sql.NullBool{}
+	if query.Get("suspended") != "" {
+		suspendedOnly, err := strconv.ParseBool(query.Get("suspended"))
+		if err != nil {
+			response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			response.WriteHeader(http.StatusBadRequest)
+			response.Write([]byte("Bad Request: suspended must be true or false."))
+			return
+		}
+		suspended = sql.NullBool{Bool: suspendedOnly, Valid: true}
+	}
+
+	//Work out the page size and where the page starts.
+	page, err := utilityParsePageRequest(query)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: " + err.Error() + "."))
+		return
+	}
+
+	//Newest accounts first, so paging back walks up the index.
+	count, err := cfg.dbQueries.CountUsers(context.Background(), database.CountUsersParams{
+		Search:    search,
+		Role:      role,
+		Suspended: suspended,
+	})
+	if err == nil && page.pagingBack {
+		userList, err = cfg.dbQueries.GetUsersPageAsc(context.Background(), database.GetUsersPageAscParams{
+			Search:          search,
+			Role:            role,
+			Suspended:       suspended,
+			CursorCreatedAt: page.cursorCreatedAt,
+			CursorID:        page.cursorID,
+			PageLimit:       page.limit + 1,
+		})
+	} else if err == nil {
+		userList, err = cfg.dbQueries.GetUsersPageDesc(context.Background(), database.GetUsersPageDescParams{
+			Search:          search,
+			Role:            role,
+			Suspended:       suspended,
+			CursorCreatedAt: page.cursorCreatedAt,
+			CursorID:        page.cursorID,
+			PageLimit:       page.limit + 1,
+		})
+	}
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not retrieve users."))
+		return
+	}
+
+	userList, nextCursor, prevCursor := utilityPageCursors(userList, page, func(userData database.User) (time.Time, uuid.UUID) {
+		return userData.CreatedAt, userData.ID
+	})
+
+	userListResponse := make([]adminUserResponse, 0, len(userList))
+	for _, userData := range userList {
+		userListResponse = append(userListResponse, utilityAdminUserResponse(userData))
+	}
+
+	dataMarshalled, err := json.Marshal(adminUsersPageResponse{
+		Users:      userListResponse,
+		Count:      count,
+		NextCursor: nextCursor,
+		PrevCursor: prevCursor,
+	})
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Failed create response."))
+		return
+	}
+
+	//Success
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusOK)
+	response.Write(dataMarshalled)
+}
+
+func (cfg *apiConfig) handlerAdminGetUser(response http.ResponseWriter, request *http.Request) {
+	userData, ok := cfg.utilityAdminTargetUser(response, request)
+	if !ok {
+		return
+	}
+
+	dataMarshalled, err := json.Marshal(utilityAdminUserResponse(userData))
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Failed create response."))
+		return
+	}
+
+	//Success
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusOK)
+	response.Write(dataMarshalled)
+}
+
+func (cfg *apiConfig) handlerAdminGetUserChirps(response http.ResponseWriter, request *http.Request) {
+	var chirpList []database.Chirp
+
+	userData, ok := cfg.utilityAdminTargetUser(response, request)
+	if !ok {
+		return
+	}
+
+	//Work out the page size and where the page starts.
+	page, err := utilityParsePageRequest(request.URL.Query())
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: " + err.Error() + "."))
+		return
+	}
+
+	//Newest first, so paging back walks up the index.
+	authorID := uuid.NullUUID{UUID: userData.ID, Valid: true}
+	if page.pagingBack {
+		chirpList, err = cfg.dbQueries.GetChirpsPageAsc(context.Background(), database.GetChirpsPageAscParams{
+			UserID:          authorID,
+			CursorCreatedAt: page.cursorCreatedAt,
+			CursorID:        page.cursorID,
+			PageLimit:       page.limit + 1,
+		})
+	} else {
+		chirpList, err = cfg.dbQueries.GetChirpsPageDesc(context.Background(), database.GetChirpsPageDescParams{
+			UserID:          authorID,
+			CursorCreatedAt: page.cursorCreatedAt,
+			CursorID:        page.cursorID,
+			PageLimit:       page.limit + 1,
+		})
+	}
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not retrieve Chirps."))
+		return
+	}
+
+	chirpList, nextCursor, prevCursor := utilityPageCursors(chirpList, page, utilityChirpKey)
+
+	//Unlike the public list, a user with no chirps is an empty page rather than Not Found.
+	chirpListResponse, err := cfg.utilityChirpListResponse(request, chirpList)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not retrieve Chirps."))
+		return
+	}
+
+	dataMarshalled, err := json.Marshal(chirpsPageResponse{
+		Chirps:     chirpListResponse,
+		NextCursor: nextCursor,
+		PrevCursor: prevCursor,
+	})
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not process Chirps to JSON."))
+		return
+	}
+
+	//Success
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusOK)
+	response.Write(dataMarshalled)
+}
+
+func (cfg *apiConfig) handlerAdminGetUserSessions(response http.ResponseWriter, request *http.Request) {
+	userData, ok := cfg.utilityAdminTargetUser(response, request)
+	if !ok {
+		return
+	}
+
+	sessionList, err := cfg.dbQueries.GetSessions(context.Background(), userData.ID)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not retrieve sessions."))
+		return
+	}
+
+	sessionListResponse := make([]sessionResponse, 0, len(sessionList))
+	for _, session := range sessionList {
+		sessionListResponse = append(sessionListResponse, sessionResponse{
+			Id:         session.FamilyID,
+			CreatedAt:  session.StartedAt,
+			LastUsedAt: session.LastUsedAt,
+			ExpiresAt:  session.ExpiresAt,
+			UserAgent:  session.UserAgent,
+			IpAddress:  session.IpAddress,
+			ClientId:   utilityNullUUID(session.ClientID),
+			ClientName: session.ClientName.String,
+		})
+	}
+
+	dataMarshalled, err := json.Marshal(sessionListResponse)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Failed create response."))
+		return
+	}
+
+	//Success
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusOK)
+	response.Write(dataMarshalled)
+}
+
+func (cfg *apiConfig) handlerAdminSuspendUser(response http.ResponseWriter, request *http.Request) {
+	caller := utilityRequestCaller(request)
+
+	userData, ok := cfg.utilityAdminTargetUser(response, request)
+	if !ok {
+		return
+	}
+	if userData.ID == caller.UserID {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusForbidden)
+		response.Write([]byte("Forbidden: Admins cannot suspend themselves."))
+		return
+	}
+
+	reason, err := utilityParseSuspensionReason(request, false)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: " + err.Error() + "."))
+		return
+	}
+
+	//Suspending also logs the user out everywhere, apps included.
+	tx, err := cfg.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Failed to suspend user."))
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	_, err = qtx.SuspendUser(context.Background(), database.SuspendUserParams{
+		ID:               userData.ID,
+		SuspensionReason: sql.NullString{String: reason, Valid: true},
+	})
+	if err == nil {
+		err = qtx.RevokeAllSessions(context.Background(), userData.ID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Failed to suspend user."))
+		return
+	}
+	cfg.utilityAudit(request, auditAdminUserSuspended, caller.UserID, userData.ID, map[string]any{"reason": reason})
+
+	//Success
+	response.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerAdminUnsuspendUser(response http.ResponseWriter, request *http.Request) {
+	caller := utilityRequestCaller(request)
+
+	userData, ok := cfg.utilityAdminTargetUser(response, request)
+	if !ok {
+		return
+	}
+
+	reason, err := utilityParseSuspensionReason(request, true)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: " + err.Error() + "."))
+		return
+	}
+
+	_, err = cfg.dbQueries.UnsuspendUser(context.Background(), userData.ID)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Failed to unsuspend user."))
+		return
+	}
+	cfg.utilityAudit(request, auditAdminUserUnsuspended, caller.UserID, userData.ID, map[string]any{"reason": reason})
+
+	//Success
+	response.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerAdminLogoutUser(response http.ResponseWriter, request *http.Request) {
+	userData, ok := cfg.utilityAdminTargetUser(response, request)
+	if !ok {
+		return
+	}
+
+	//Every session ends, apps and personal access tokens included.
+	tx, err := cfg.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Failed to revoke sessions."))
+		return
+	}
+	defer tx.Rollback()
+
+	err = utilityRevokeAllAccess(cfg.dbQueries.WithTx(tx), userData.ID, uuid.Nil)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Failed to revoke sessions."))
+		return
+	}
+	cfg.utilityAudit(request, auditAdminUserLoggedOut, utilityRequestCaller(request).UserID, userData.ID, nil)
+
+	//Success
+	response.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerAdminResetPassword(response http.ResponseWriter, request *http.Request) {
+	userData, ok := cfg.utilityAdminTargetUser(response, request)
+	if !ok {
+		return
+	}
+
+	//The password is replaced with one nobody knows, so the user has to choose a new one through the emailed link.
+	unusablePassword, err := auth.MakeRefreshToken()
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Unable to reset password."))
+		return
+	}
+	hashedPassword, err := auth.HashPassword(unusablePassword, cfg.passwordParams)
+	if err == auth.ErrHashingBusy {
+		utilityWriteHashingBusy(response)
+		return
+	}
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Unable to reset password."))
+		return
+	}
+	resetToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Unable to reset password."))
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Unable to reset password."))
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	//Older reset links stop working too, only the one we send now will.
+	err = qtx.UpdateUserPassword(context.Background(), database.UpdateUserPasswordParams{
+		HashedPassword: hashedPassword,
+		ID:             userData.ID,
+	})
+	if err == nil {
+		err = qtx.UsePasswordResetTokens(context.Background(), userData.ID)
+	}
+	if err == nil {
+		err = utilityRevokeAllAccess(qtx, userData.ID, uuid.Nil)
+	}
+	if err == nil {
+		err = qtx.CreatePasswordResetToken(context.Background(), database.CreatePasswordResetTokenParams{
+			TokenHash: auth.HashToken(resetToken),
+			ExpiresAt: time.Now().Add(passwordResetDuration).UTC(),
+			UserID:    userData.ID,
+		})
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Unable to reset password."))
+		return
+	}
+
+	cfg.utilityAudit(request, auditAdminPasswordReset, utilityRequestCaller(request).UserID, userData.ID, nil)
+
+	cfg.utilitySendMail(mailer.Message{
+		To:      userData.Email,
+		Subject: "Your Chirpy password has been reset",
+		Body: "An administrator has reset the password for your Chirpy account and logged it out everywhere.\n\n" +
+			"To choose a new password, follow this link within the hour:\n" +
+			cfg.utilityAppLink("/reset-password", resetToken) + "\n\n" +
+			"If the link has expired, ask for a new one from the forgotten password page.\n",
+	})
+
+	//Success
+	response.WriteHeader(http.StatusAccepted)
+}
+
+func (cfg *apiConfig) handlerAdminDeleteUser(response http.ResponseWriter, request *http.Request) {
+	caller := utilityRequestCaller(request)
+
+	userData, ok := cfg.utilityAdminTargetUser(response, request)
+	if !ok {
+		return
+	}
+	if userData.ID == caller.UserID {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusForbidden)
+		response.Write([]byte("Forbidden: Admins cannot delete themselves."))
+		return
+	}
+
+	//Everything the user owns goes with them. Their chirps that other users' replies or quotes hang off stay
+	//as tombstones with no author, so the threads hold together. Then their likes, replies, rechirps and quotes
+	//come off the counts of the chirps that stay.
+	tx, err := cfg.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Failed to delete user."))
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	storageKeys, err := qtx.DeleteUserMedia(context.Background(), userData.ID)
+	if err == nil {
+		var tombstoneIDs []uuid.UUID
+		tombstoneIDs, err = qtx.TombstoneUserChirpsInThreads(context.Background(), userData.ID)
+		for i := 0; err == nil && i < len(tombstoneIDs); i++ {
+			err = qtx.DeleteRechirpsOf(context.Background(), uuid.NullUUID{UUID: tombstoneIDs[i], Valid: true})
+			if err == nil {
+				err = qtx.DeleteChirpEntities(context.Background(), tombstoneIDs[i])
+			}
+			if err == nil {
+				err = qtx.DeleteChirpRevisions(context.Background(), tombstoneIDs[i])
+			}
+		}
+	}
+	if err == nil {
+		err = qtx.DecrementLikeCountsForUser(context.Background(), userData.ID)
+	}
+	if err == nil {
+		err = qtx.DecrementReplyCountsForUser(context.Background(), userData.ID)
+	}
+	if err == nil {
+		err = qtx.DecrementRechirpCountsForUser(context.Background(), userData.ID)
+	}
+	if err == nil {
+		err = qtx.DecrementQuoteCountsForUser(context.Background(), userData.ID)
+	}
+	//Failed logins keep the email typed in, which would otherwise outlive the account.
+	if err == nil {
+		err = qtx.DeleteFailedLoginsForUser(context.Background(), database.DeleteFailedLoginsForUserParams{
+			UserID: uuid.NullUUID{UUID: userData.ID, Valid: true},
+			Email:  userData.Email,
+		})
+	}
+	if err == nil {
+		_, err = qtx.DeleteUser(context.Background(), userData.ID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Failed to delete user."))
+		return
+	}
+	cfg.utilityAudit(request, auditAdminUserDeleted, caller.UserID, userData.ID, nil)
+
+	//The files are only removed once the rows are gone for good. A file we fail to remove is just wasted space.
+	for _, storageKey := range storageKeys {
+		err = cfg.mediaStore.Delete(storageKey)
+		if err != nil {
+			fmt.Printf("Error deleting media file %v: %v\n", storageKey, err)
+		}
+	}
+
+	//Success
+	response.WriteHeader(http.StatusNoContent)
+}
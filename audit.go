@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github/JohnDirewolf/chirpy/internal/auth"
+	"github/JohnDirewolf/chirpy/internal/database"
+
+	"github.com/google/uuid"
+)
+
+// Security-relevant events kept in audit_events. Rows are only ever added, the table refuses updates and deletes,
+// and they outlive the users they mention so a deleted account's history is still there.
+const (
+	auditLogin                      = "login"
+	auditLoginFailed                = "login_failed"
+	auditPasswordChanged            = "password_changed"
+	auditPasswordReset              = "password_reset"
+	auditEmailChangeRequested       = "email_change_requested"
+	auditEmailChanged               = "email_changed"
+	auditSessionRevoked             = "session_revoked"
+	auditAllSessionsRevoked         = "all_sessions_revoked"
+	auditRefreshTokenReused         = "refresh_token_reused"
+	auditPersonalAccessTokenRevoked = "personal_access_token_revoked"
+	auditOAuthTokenRevoked          = "oauth_token_revoked"
+	auditChirpyRedUpgraded          = "chirpy_red_upgraded"
+	auditChirpDeleted               = "chirp_deleted"
+	auditAdminRoleChanged           = "admin.role_changed"
+	auditAdminUserSuspended         = "admin.user_suspended"
+	auditAdminUserUnsuspended       = "admin.user_unsuspended"
+	auditAdminUserLoggedOut         = "admin.user_logged_out"
+	auditAdminPasswordReset         = "admin.password_reset"
+	auditAdminUserDeleted           = "admin.user_deleted"
+	auditAdminReset                 = "admin.reset"
+)
+
+// How an event is shown to admins.
+type auditEventResponse struct {
+	Id        uuid.UUID       `json:"id"`
+	CreatedAt time.Time       `json:"created_at"`
+	Event     string          `json:"event"`
+	ActorId   *uuid.UUID      `json:"actor_id"`
+	TargetId  *uuid.UUID      `json:"target_id"`
+	IpAddress string          `json:"ip_address"`
+	UserAgent string          `json:"user_agent"`
+	Payload   json.RawMessage `json:"payload"`
+}
+
+type auditEventsPageResponse struct {
+	Events     []auditEventResponse `json:"events"`
+	NextCursor string               `json:"next_cursor,omitempty"`
+	PrevCursor string               `json:"prev_cursor,omitempty"`
+}
+
+// How an event is shown to the user it is about. Whoever else acted on the account is only named as staff,
+// and where they did it from is not shown.
+type securityLogEventResponse struct {
+	Id        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Event     string    `json:"event"`
+	//you or staff, left out when nobody was logged in, like a failed login.
+	Actor     string          `json:"actor,omitempty"`
+	IpAddress string          `json:"ip_address,omitempty"`
+	UserAgent string          `json:"user_agent,omitempty"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+}
+
+type securityLogPageResponse struct {
+	Events     []securityLogEventResponse `json:"events"`
+	NextCursor string                     `json:"next_cursor,omitempty"`
+	PrevCursor string                     `json:"prev_cursor,omitempty"`
+}
+
+// Records an event, with who did it and who to, uuid.Nil for nobody. The action has already happened,
+// so trouble recording it is only logged.
+func (cfg *apiConfig) utilityAudit(request *http.Request, event string, actorID uuid.UUID, targetID uuid.UUID, payload map[string]any) {
+	if payload == nil {
+		payload = map[string]any{}
+	}
+	payloadMarshalled, err := json.Marshal(payload)
+	if err != nil {
+		fmt.Printf("Error recording audit event %v: %v\n", event, err)
+		return
+	}
+	err = cfg.dbQueries.CreateAuditEvent(context.Background(), database.CreateAuditEventParams{
+		Event:     event,
+		ActorID:   uuid.NullUUID{UUID: actorID, Valid: actorID != uuid.Nil},
+		TargetID:  uuid.NullUUID{UUID: targetID, Valid: targetID != uuid.Nil},
+		IpAddress: utilityClientIP(request),
+		UserAgent: request.UserAgent(),
+		Payload:   payloadMarshalled,
+	})
+	if err != nil {
+		fmt.Printf("Error recording audit event %v: %v\n", event, err)
+	}
+}
+
+// Emails are kept in the log only as a hash, rows can never be removed, so the addresses of deleted users
+// would otherwise stay forever. The hash is keyed with AUDIT_KEY, so a copy of the table cannot be checked against
+// a list of addresses, an admin with an address and the key can still find its events by hashing it the same way.
+func (cfg *apiConfig) utilityAuditEmail(email string) string {
+	return auth.KeyedHash(cfg.auditKey, strings.ToLower(strings.TrimSpace(email)))
+}
+
+func utilityAuditEventKey(eventData database.AuditEvent) (time.Time, uuid.UUID) {
+	return eventData.CreatedAt, eventData.ID
+}
+
+// Reads an optional RFC 3339 time from the query.
+func utilityParseQueryTime(rawTime string) (sql.NullTime, error) {
+	if rawTime == "" {
+		return sql.NullTime{}, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, rawTime)
+	if err != nil {
+		return sql.NullTime{}, err
+	}
+	return sql.NullTime{Time: parsed.UTC(), Valid: true}, nil
+}
+
+// Reads an optional user id from the query.
+func utilityParseQueryUUID(rawID string) (uuid.NullUUID, error) {
+	if rawID == "" {
+		return uuid.NullUUID{}, nil
+	}
+	id, err := uuid.Parse(rawID)
+	if err != nil {
+		return uuid.NullUUID{}, err
+	}
+	return uuid.NullUUID{UUID: id, Valid: true}, nil
+}
+
+// Pages through events newest first, with the filters left empty matching everything.
+func (cfg *apiConfig) utilityAuditEventsPage(page pageRequest, filters database.GetAuditEventsPageDescParams) ([]database.AuditEvent, string, string, error) {
+	var eventList []database.AuditEvent
+	var err error
+	filters.CursorCreatedAt = page.cursorCreatedAt
+	filters.CursorID = page.cursorID
+	filters.PageLimit = page.limit + 1
+	if page.pagingBack {
+		eventList, err = cfg.dbQueries.GetAuditEventsPageAsc(context.Background(), database.GetAuditEventsPageAscParams(filters))
+	} else {
+		eventList, err = cfg.dbQueries.GetAuditEventsPageDesc(context.Background(), filters)
+	}
+	if err != nil {
+		return nil, "", "", err
+	}
+	eventList, nextCursor, prevCursor := utilityPageCursors(eventList, page, utilityAuditEventKey)
+	return eventList, nextCursor, prevCursor, nil
+}
+
+func (cfg *apiConfig) handlerAdminGetAuditEvents(response http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+
+	//Every filter is optional.
+	filters := database.GetAuditEventsPageDescParams{
+		Event: sql.NullString{String: query.Get("event"), Valid: query.Get("event") != ""},
+	}
+	var err error
+	filters.ActorID, err = utilityParseQueryUUID(query.Get("actor_id"))
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: actor_id is malformed."))
+		return
+	}
+	filters.TargetID, err = utilityParseQueryUUID(query.Get("target_id"))
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: target_id is malformed."))
+		return
+	}
+	filters.Since, err = utilityParseQueryTime(query.Get("since"))
+	if err == nil {
+		filters.Until, err = utilityParseQueryTime(query.Get("until"))
+	}
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: since and until must be RFC 3339 times."))
+		return
+	}
+
+	//Work out the page size and where the page starts.
+	page, err := utilityParsePageRequest(query)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: " + err.Error() + "."))
+		return
+	}
+
+	eventList, nextCursor, prevCursor, err := cfg.utilityAuditEventsPage(page, filters)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not retrieve audit events."))
+		return
+	}
+
+	eventListResponse := make([]auditEventResponse, 0, len(eventList))
+	for _, eventData := range eventList {
+		eventListResponse = append(eventListResponse, auditEventResponse{
+			Id:        eventData.ID,
+			CreatedAt: eventData.CreatedAt,
+			Event:     eventData.Event,
+			ActorId:   utilityNullUUID(eventData.ActorID),
+			TargetId:  utilityNullUUID(eventData.TargetID),
+			IpAddress: eventData.IpAddress,
+			UserAgent: eventData.UserAgent,
+			Payload:   eventData.Payload,
+		})
+	}
+
+	dataMarshalled, err := json.Marshal(auditEventsPageResponse{
+		Events:     eventListResponse,
+		NextCursor: nextCursor,
+		PrevCursor: prevCursor,
+	})
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Failed create response."))
+		return
+	}
+
+	//Success
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusOK)
+	response.Write(dataMarshalled)
+}
+
+func (cfg *apiConfig) handlerGetSecurityLog(response http.ResponseWriter, request *http.Request) {
+	//Validate credentials sent.
+	//Get the user token from the request.
+	userToken, err := auth.GetBearerToken(request.Header)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusUnauthorized)
+		response.Write([]byte("Unathorized: Please login first."))
+		return
+	}
+
+	userID, err := cfg.utilityValidateJWT(userToken)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusUnauthorized)
+		response.Write([]byte("Unathorized: credentials invalid. Please login again."))
+		return
+	}
+
+	//Work out the page size and where the page starts.
+	page, err := utilityParsePageRequest(request.URL.Query())
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: " + err.Error() + "."))
+		return
+	}
+
+	//Everything that happened to the account, whoever did it.
+	eventList, nextCursor, prevCursor, err := cfg.utilityAuditEventsPage(page, database.GetAuditEventsPageDescParams{
+		TargetID: uuid.NullUUID{UUID: userID, Valid: true},
+	})
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not retrieve security log."))
+		return
+	}
+
+	eventListResponse := make([]securityLogEventResponse, 0, len(eventList))
+	for _, eventData := range eventList {
+		eventResponse := securityLogEventResponse{
+			Id:        eventData.ID,
+			CreatedAt: eventData.CreatedAt,
+			Event:     eventData.Event,
+		}
+		if eventData.ActorID.Valid && eventData.ActorID.UUID != userID {
+			eventResponse.Actor = "staff"
+		} else {
+			if eventData.ActorID.Valid {
+				eventResponse.Actor = "you"
+			}
+			eventResponse.IpAddress = eventData.IpAddress
+			eventResponse.UserAgent = eventData.UserAgent
+			eventResponse.Payload = eventData.Payload
+		}
+		eventListResponse = append(eventListResponse, eventResponse)
+	}
+
+	dataMarshalled, err := json.Marshal(securityLogPageResponse{
+		Events:     eventListResponse,
+		NextCursor: nextCursor,
+		PrevCursor: prevCursor,
+	})
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Failed create response."))
+		return
+	}
+
+	//Success
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusOK)
+	response.Write(dataMarshalled)
+}
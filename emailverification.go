@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/mail"
+	"time"
+
+	"github/JohnDirewolf/chirpy/internal/auth"
+	"github/JohnDirewolf/chirpy/internal/database"
+	"github/JohnDirewolf/chirpy/internal/mailer"
+
+	"github.com/google/uuid"
+)
+
+// How long a verification link works for.
+const emailVerificationDuration = time.Hour * 24
+
+// Only a bare address will do, no display names or anything else around it.
+func utilityValidEmail(email string) bool {
+	address, err := mail.ParseAddress(email)
+	return err == nil && address.Name == "" && address.Address == email
+}
+
+// Makes a token that proves the user can read mail sent to the address, only its hash is stored.
+func utilityCreateEmailVerification(qtx *database.Queries, userID uuid.UUID, email string) (string, error) {
+	verificationToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		return "", err
+	}
+	err = qtx.CreateEmailVerificationToken(context.Background(), database.CreateEmailVerificationTokenParams{
+		TokenHash: auth.HashToken(verificationToken),
+		ExpiresAt: time.Now().Add(emailVerificationDuration).UTC(),
+		UserID:    userID,
+		Email:     email,
+	})
+	if err != nil {
+		return "", err
+	}
+	return verificationToken, nil
+}
+
+func (cfg *apiConfig) utilitySendEmailVerification(email string, verificationToken string) {
+	cfg.utilitySendMail(mailer.Message{
+		To:      email,
+		Subject: "Confirm your email for Chirpy",
+		Body: "To confirm this is your email address, follow this link within a day:\n" +
+			cfg.utilityAppLink("/verify-email", verificationToken) + "\n\n" +
+			"If you did not sign up for Chirpy or change your email, you can ignore this email.\n",
+	})
+}
+
+// When REQUIRE_VERIFIED_EMAIL is on, only users who have confirmed their email can post.
+func (cfg *apiConfig) utilityCanPost(userID uuid.UUID) (bool, error) {
+	if !cfg.requireVerifiedEmail {
+		return true, nil
+	}
+	userData, err := cfg.dbQueries.GetUserByID(context.Background(), userID)
+	if err != nil {
+		return false, err
+	}
+	return userData.EmailVerifiedAt.Valid, nil
+}
+
+func (cfg *apiConfig) handlerVerifyEmail(response http.ResponseWriter, request *http.Request) {
+	type requestParameters struct {
+		Token string `json:"token"`
+	}
+
+	decoder := json.NewDecoder(request.Body)
+	requestParams := requestParameters{}
+	err := decoder.Decode(&requestParams)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: Did not understand request."))
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Unable to verify email."))
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	verificationData, err := qtx.GetEmailVerificationTokenForUpdate(context.Background(), auth.HashToken(requestParams.Token))
+	if err != nil && err != sql.ErrNoRows {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Unable to verify email."))
+		return
+	}
+	if err == sql.ErrNoRows || verificationData.UsedAt.Valid || !verificationData.ExpiresAt.After(time.Now().UTC()) {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: This verification link is invalid or has expired."))
+		return
+	}
+
+	userData, err := qtx.GetUserByID(context.Background(), verificationData.UserID)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Unable to verify email."))
+		return
+	}
+
+	//The link either confirms the address the user already has, or the one they asked to change to.
+	//A link for an address they have since moved on from does nothing.
+	var rowsUpdated int64
+	if verificationData.Email == userData.Email {
+		rowsUpdated, err = qtx.MarkEmailVerified(context.Background(), database.MarkEmailVerifiedParams{
+			ID:    userData.ID,
+			Email: verificationData.Email,
+		})
+	} else {
+		rowsUpdated, err = qtx.ConfirmPendingEmail(context.Background(), database.ConfirmPendingEmailParams{
+			ID:           userData.ID,
+			PendingEmail: sql.NullString{String: verificationData.Email, Valid: true},
+		})
+	}
+	if utilityIsUniqueViolation(err) {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusConflict)
+		response.Write([]byte("Conflict: That email is already in use."))
+		return
+	}
+	if err == nil && rowsUpdated == 0 {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: This verification link is invalid or has expired."))
+		return
+	}
+	if err == nil {
+		err = qtx.UseEmailVerificationToken(context.Background(), verificationData.TokenHash)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Unable to verify email."))
+		return
+	}
+	if verificationData.Email != userData.Email {
+		cfg.utilityAudit(request, auditEmailChanged, uuid.Nil, userData.ID, map[string]any{"email_hash": cfg.utilityAuditEmail(userData.Email), "new_email_hash": cfg.utilityAuditEmail(verificationData.Email)})
+	}
+
+	//Success
+	response.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerResendEmailVerification(response http.ResponseWriter, request *http.Request) {
+	//Validate credentials sent.
+	//Get the user token from the request.
+	userToken, err := auth.GetBearerToken(request.Header)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusUnauthorized)
+		response.Write([]byte("Unathorized: Please login first."))
+		return
+	}
+
+	userID, err := cfg.utilityValidateJWT(userToken)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusUnauthorized)
+		response.Write([]byte("Unathorized: credentials invalid. Please login again."))
+		return
+	}
+
+	userData, err := cfg.dbQueries.GetUserByID(context.Background(), userID)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusUnauthorized)
+		response.Write([]byte("Unathorized: credentials invalid. Please login again."))
+		return
+	}
+
+	//A change waiting to be confirmed comes first, otherwise the current address if it is not confirmed yet.
+	email := userData.PendingEmail.String
+	if !userData.PendingEmail.Valid {
+		if userData.EmailVerifiedAt.Valid {
+			response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			response.WriteHeader(http.StatusConflict)
+			response.Write([]byte("Conflict: Your email is already verified."))
+			return
+		}
+		email = userData.Email
+	}
+
+	retryAfter, err := cfg.utilityReserveEmailSend(request, email)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Unable to send verification email."))
+		return
+	}
+	if retryAfter > 0 {
+		response.Header().Set("Retry-After", utilityRetryAfterSeconds(retryAfter))
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusTooManyRequests)
+		response.Write([]byte("Too Many Requests: Too many verification emails, please try again in " + utilityRetryAfterSeconds(retryAfter) + " seconds."))
+		return
+	}
+
+	verificationToken, err := utilityCreateEmailVerification(cfg.dbQueries, userID, email)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Unable to send verification email."))
+		return
+	}
+	cfg.utilitySendEmailVerification(email, verificationToken)
+
+	//Success
+	response.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"github/JohnDirewolf/chirpy/internal/database"
+	"github/JohnDirewolf/chirpy/internal/entities"
+
+	"github.com/google/uuid"
+)
+
+// Entities tell clients which parts of a body to render as links. Offsets are given in bytes and in runes, ends are exclusive.
+type chirpEntityResponse struct {
+	Type      string     `json:"type"`
+	Text      string     `json:"text"`
+	StartByte int32      `json:"start_byte"`
+	EndByte   int32      `json:"end_byte"`
+	StartRune int32      `json:"start_rune"`
+	EndRune   int32      `json:"end_rune"`
+	Tag       string     `json:"tag,omitempty"`
+	UserId    *uuid.UUID `json:"user_id,omitempty"`
+}
+
+// Parses the body of a chirp and saves what it finds. Mentions of handles nobody has are left as plain text.
+func utilitySaveChirpEntities(qtx *database.Queries, chirp database.Chirp) error {
+	found := entities.Parse(chirp.Body)
+	if len(found) == 0 {
+		return nil
+	}
+
+	//Look up everyone mentioned in one go.
+	handles := make([]string, 0)
+	for _, entity := range found {
+		if entity.Kind == entities.KindMention {
+			handles = append(handles, entities.Handle(entity.Text))
+		}
+	}
+	userIDs := make(map[string]uuid.UUID)
+	if len(handles) > 0 {
+		users, err := qtx.GetUsersByHandles(context.Background(), handles)
+		if err != nil {
+			return err
+		}
+		for _, user := range users {
+			userIDs[entities.Handle(user.Handle)] = user.ID
+		}
+	}
+
+	for _, entity := range found {
+		entityParams := database.CreateChirpEntityParams{
+			ChirpID:   chirp.ID,
+			Kind:      entity.Kind,
+			StartByte: int32(entity.StartByte),
+			EndByte:   int32(entity.EndByte),
+			StartRune: int32(entity.StartRune),
+			EndRune:   int32(entity.EndRune),
+			Text:      entity.Text,
+		}
+		switch entity.Kind {
+		case entities.KindHashtag:
+			entityParams.Tag = sql.NullString{String: entities.Tag(entity.Text), Valid: true}
+		case entities.KindMention:
+			userID, found := userIDs[entities.Handle(entity.Text)]
+			if !found {
+				continue
+			}
+			entityParams.UserID = uuid.NullUUID{UUID: userID, Valid: true}
+		}
+		err := qtx.CreateChirpEntity(context.Background(), entityParams)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Loads the entities for a list of chirps, keyed by chirp.
+func (cfg *apiConfig) utilityChirpEntities(chirpIDs []uuid.UUID) (map[uuid.UUID][]chirpEntityResponse, error) {
+	entityList, err := cfg.dbQueries.GetChirpEntities(context.Background(), chirpIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	entitiesByChirp := make(map[uuid.UUID][]chirpEntityResponse)
+	for _, entity := range entityList {
+		entityResponse := chirpEntityResponse{
+			Type:      entity.Kind,
+			Text:      entity.Text,
+			StartByte: entity.StartByte,
+			EndByte:   entity.EndByte,
+			StartRune: entity.StartRune,
+			EndRune:   entity.EndRune,
+			Tag:       entity.Tag.String,
+		}
+		if entity.UserID.Valid {
+			entityResponse.UserId = &entity.UserID.UUID
+		}
+		entitiesByChirp[entity.ChirpID] = append(entitiesByChirp[entity.ChirpID], entityResponse)
+	}
+	return entitiesByChirp, nil
+}
+
+func (cfg *apiConfig) handlerGetHashtagChirps(response http.ResponseWriter, request *http.Request) {
+	var chirpList []database.Chirp
+
+	//Tags are matched without the # and ignoring case, so #Go and #go are the same tag.
+	tag := entities.Tag(request.PathValue("tag"))
+	if tag == "" {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: Invalid hashtag."))
+		return
+	}
+
+	//Work out the page size and where the page starts.
+	page, err := utilityParsePageRequest(request.URL.Query())
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: " + err.Error() + "."))
+		return
+	}
+
+	//Like the timeline, a hashtag is always newest first.
+	if page.pagingBack {
+		chirpList, err = cfg.dbQueries.GetHashtagChirpsPageAsc(context.Background(), database.GetHashtagChirpsPageAscParams{
+			Tag:             tag,
+			CursorCreatedAt: page.cursorCreatedAt,
+			CursorID:        page.cursorID,
+			PageLimit:       page.limit + 1,
+		})
+	} else {
+		chirpList, err = cfg.dbQueries.GetHashtagChirpsPageDesc(context.Background(), database.GetHashtagChirpsPageDescParams{
+			Tag:             tag,
+			CursorCreatedAt: page.cursorCreatedAt,
+			CursorID:        page.cursorID,
+			PageLimit:       page.limit + 1,
+		})
+	}
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not retrieve Chirps."))
+		return
+	}
+
+	chirpList, nextCursor, prevCursor := utilityPageCursors(chirpList, page, utilityChirpKey)
+
+	chripListResponse, err := cfg.utilityChirpListResponse(request, chirpList)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not retrieve Chirps."))
+		return
+	}
+
+	chripListMarshalled, err := json.Marshal(chirpsPageResponse{
+		Chirps:     chripListResponse,
+		NextCursor: nextCursor,
+		PrevCursor: prevCursor,
+	})
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not process Chirps to JSON."))
+		return
+	}
+
+	//Success
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusOK)
+	response.Write(chripListMarshalled)
+}
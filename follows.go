@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github/JohnDirewolf/chirpy/internal/auth"
+	"github/JohnDirewolf/chirpy/internal/database"
+
+	"github.com/google/uuid"
+)
+
+// One user in a followers or following list, and when the follow happened.
+type followResponse struct {
+	UserId     uuid.UUID `json:"user_id"`
+	FollowedAt time.Time `json:"followed_at"`
+}
+
+type followsPageResponse struct {
+	Users      []followResponse `json:"users"`
+	Count      int64            `json:"count"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+	PrevCursor string           `json:"prev_cursor,omitempty"`
+}
+
+func (cfg *apiConfig) handlerFollowUser(response http.ResponseWriter, request *http.Request) {
+	//Validate credentials sent.
+	//Get the user token from the request.
+	userToken, err := auth.GetBearerToken(request.Header)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusUnauthorized)
+		response.Write([]byte("Unathorized: Please login first."))
+		return
+	}
+
+	userID, err := cfg.utilityAuthenticate(userToken, scopeProfileWrite)
+	if err != nil {
+		utilityWriteAuthError(response, err, scopeProfileWrite)
+		return
+	}
+
+	//Extract the user to follow from the URL of the request
+	followeeID, err := uuid.Parse(request.PathValue("userID"))
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: user id is malformed."))
+		return
+	}
+
+	if followeeID == userID {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: You cannot follow yourself."))
+		return
+	}
+
+	_, err = cfg.dbQueries.GetUserByID(context.Background(), followeeID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			response.WriteHeader(http.StatusNotFound)
+			response.Write([]byte("Not Found: User not found."))
+			return
+		}
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not fetch user."))
+		return
+	}
+
+	//Following someone you already follow does nothing.
+	err = cfg.dbQueries.FollowUser(context.Background(), database.FollowUserParams{
+		FollowerID: userID,
+		FolloweeID: followeeID,
+	})
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not follow user."))
+		return
+	}
+
+	//Success
+	response.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerUnfollowUser(response http.ResponseWriter, request *http.Request) {
+	//Validate credentials sent.
+	//Get the user token from the request.
+	userToken, err := auth.GetBearerToken(request.Header)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusUnauthorized)
+		response.Write([]byte("Unathorized: Please login first."))
+		return
+	}
+
+	userID, err := cfg.utilityAuthenticate(userToken, scopeProfileWrite)
+	if err != nil {
+		utilityWriteAuthError(response, err, scopeProfileWrite)
+		return
+	}
+
+	followeeID, err := uuid.Parse(request.PathValue("userID"))
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: user id is malformed."))
+		return
+	}
+
+	//Unfollowing someone you do not follow does nothing.
+	err = cfg.dbQueries.UnfollowUser(context.Background(), database.UnfollowUserParams{
+		FollowerID: userID,
+		FolloweeID: followeeID,
+	})
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not unfollow user."))
+		return
+	}
+
+	//Success
+	response.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerGetFollowers(response http.ResponseWriter, request *http.Request) {
+	cfg.utilityFollowList(response, request, true)
+}
+
+func (cfg *apiConfig) handlerGetFollowing(response http.ResponseWriter, request *http.Request) {
+	cfg.utilityFollowList(response, request, false)
+}
+
+// Followers and following lists only differ in which side of the follow we look at, so they share this.
+func (cfg *apiConfig) utilityFollowList(response http.ResponseWriter, request *http.Request, followers bool) {
+	var followList []database.Follow
+	var count int64
+
+	userID, err := uuid.Parse(request.PathValue("userID"))
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: user id is malformed."))
+		return
+	}
+
+	//Work out the page size and where the page starts.
+	page, err := utilityParsePageRequest(request.URL.Query())
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: " + err.Error() + "."))
+		return
+	}
+
+	_, err = cfg.dbQueries.GetUserByID(context.Background(), userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			response.WriteHeader(http.StatusNotFound)
+			response.Write([]byte("Not Found: User not found."))
+			return
+		}
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not fetch user."))
+		return
+	}
+
+	//Lists are newest follow first, so paging back walks up the index.
+	if followers {
+		count, err = cfg.dbQueries.CountFollowers(context.Background(), userID)
+		if err == nil && page.pagingBack {
+			followList, err = cfg.dbQueries.GetFollowersPageAsc(context.Background(), database.GetFollowersPageAscParams{
+				FolloweeID:      userID,
+				CursorCreatedAt: page.cursorCreatedAt,
+				CursorID:        page.cursorID,
+				PageLimit:       page.limit + 1,
+			})
+		} else if err == nil {
+			followList, err = cfg.dbQueries.GetFollowersPageDesc(context.Background(), database.GetFollowersPageDescParams{
+				FolloweeID:      userID,
+				CursorCreatedAt: page.cursorCreatedAt,
+				CursorID:        page.cursorID,
+				PageLimit:       page.limit + 1,
+			})
+		}
+	} else {
+		count, err = cfg.dbQueries.CountFollowing(context.Background(), userID)
+		if err == nil && page.pagingBack {
+			followList, err = cfg.dbQueries.GetFollowingPageAsc(context.Background(), database.GetFollowingPageAscParams{
+				FollowerID:      userID,
+				CursorCreatedAt: page.cursorCreatedAt,
+				CursorID:        page.cursorID,
+				PageLimit:       page.limit + 1,
+			})
+		} else if err == nil {
+			followList, err = cfg.dbQueries.GetFollowingPageDesc(context.Background(), database.GetFollowingPageDescParams{
+				FollowerID:      userID,
+				CursorCreatedAt: page.cursorCreatedAt,
+				CursorID:        page.cursorID,
+				PageLimit:       page.limit + 1,
+			})
+		}
+	}
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not retrieve follows."))
+		return
+	}
+
+	//The user on the other side of the follow is the one we list.
+	otherUser := func(follow database.Follow) uuid.UUID {
+		if followers {
+			return follow.FollowerID
+		}
+		return follow.FolloweeID
+	}
+	followList, nextCursor, prevCursor := utilityPageCursors(followList, page, func(follow database.Follow) (time.Time, uuid.UUID) {
+		return follow.CreatedAt, otherUser(follow)
+	})
+
+	followListResponse := make([]followResponse, 0, len(followList))
+	for i := 0; i < len(followList); i++ {
+		followListResponse = append(followListResponse, followResponse{
+			UserId:     otherUser(followList[i]),
+			FollowedAt: followList[i].CreatedAt,
+		})
+	}
+
+	followListMarshalled, err := json.Marshal(followsPageResponse{
+		Users:      followListResponse,
+		Count:      count,
+		NextCursor: nextCursor,
+		PrevCursor: prevCursor,
+	})
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not process follows to JSON."))
+		return
+	}
+
+	//Success
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusOK)
+	response.Write(followListMarshalled)
+}
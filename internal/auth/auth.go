@@ -1,7 +1,11 @@
 package auth
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -11,63 +15,96 @@ import (
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
 )
 
-func HashPassword(password string) (string, error) {
-	//func GenerateFromPassword(password []byte, cost int) ([]byte, error)
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return "", err
-	}
-	return string(hashedPassword), nil
+// Access tokens carry the session they were issued for, so a user can be told which session is theirs and
+// actions like changing a password can end every other session.
+// Tokens issued to an app through OAuth also say which app it is and the space separated scopes it was allowed.
+// A login's token carries the user's role, app tokens never do so an app cannot act as an admin.
+type Claims struct {
+	jwt.RegisteredClaims
+	SessionID string `json:"sid,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	Role      string `json:"role,omitempty"`
+}
+
+func MakeJWT(userID uuid.UUID, sessionID uuid.UUID, role string, keys *KeySet) (string, error) {
+	return makeAccessToken(userID, Claims{
+		SessionID: sessionID.String(),
+		Role:      role,
+	}, keys)
 }
 
-func CheckPasswordHash(password, hash string) error {
-	//func CompareHashAndPassword(hashedPassword, password []byte) error
-	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+// MakeClientJWT is MakeJWT for an app acting for the user, the session is the app's grant.
+func MakeClientJWT(userID uuid.UUID, sessionID uuid.UUID, clientID uuid.UUID, scopes []string, keys *KeySet) (string, error) {
+	return makeAccessToken(userID, Claims{
+		SessionID: sessionID.String(),
+		ClientID:  clientID.String(),
+		Scope:     strings.Join(scopes, " "),
+	}, keys)
 }
 
-func MakeJWT(userID uuid.UUID, tokenSecret string) (string, error) {
+// Every access token is made here, whatever it carries, so they all expire and are signed the same way.
+func makeAccessToken(userID uuid.UUID, claims Claims, keys *KeySet) (string, error) {
 	//func NewWithClaims(method SigningMethod, claims Claims, opts ...TokenOption) *Token
 	//Access Tokens expire in 1 hour automatically now.
 	expiresIn := time.Hour
 
-	claims := jwt.RegisteredClaims{
+	claims.RegisteredClaims = jwt.RegisteredClaims{
 		Issuer:    "chirpy",
 		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
 		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
 		Subject:   userID.String(),
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(tokenSecret))
+	tokenString, err := keys.sign(claims)
 
 	//fmt.Printf("MakeJWT tokenString is: %v\n", tokenString)
 	return tokenString, err
 }
 
-func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	//fmt.Printf("ValidateJWT tokenString: %v\n", tokenString)
-	//fmt.Printf("ValidateJWT tokenSecret: %v\n", tokenSecret)
+func ValidateJWT(tokenString string, keys *KeySet) (uuid.UUID, error) {
+	userID, _, err := ValidateJWTSession(tokenString, keys)
+	return userID, err
+}
+
+// ParseJWT checks an access token is ours and has not expired, and returns everything in it.
+func ParseJWT(tokenString string, keys *KeySet) (*Claims, error) {
+	claims := &Claims{}
+
+	//The kid in the header says which key to check with, so tokens signed before a rotation keep working.
+	_, err := jwt.ParseWithClaims(tokenString, claims, keys.keyFunc)
+	if err != nil {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
 
-	claims := &jwt.RegisteredClaims{}
+// ValidateJWTSession is ValidateJWT that also returns the session the token was issued for.
+// Tokens made before sessions were tracked have none and give uuid.Nil.
+func ValidateJWTSession(tokenString string, keys *KeySet) (uuid.UUID, uuid.UUID, error) {
+	//fmt.Printf("ValidateJWT tokenString: %v\n", tokenString)
 
-	token, err := jwt.ParseWithClaims(tokenString, claims,
-		func(token *jwt.Token) (interface{}, error) {
-			return []byte(tokenSecret), nil
-		})
+	claims, err := ParseJWT(tokenString, keys)
 	if err != nil {
-		return uuid.Nil, errors.New("invalid token")
+		return uuid.Nil, uuid.Nil, err
 	}
-	userIDString, err := token.Claims.GetSubject()
+	userIDString, err := claims.GetSubject()
 	if err != nil {
-		return uuid.Nil, errors.New("cannot get subject")
+		return uuid.Nil, uuid.Nil, errors.New("cannot get subject")
 	}
 	userIDUUID, err := uuid.Parse(userIDString)
 	if err != nil {
-		return uuid.Nil, errors.New("cannot parse userID")
+		return uuid.Nil, uuid.Nil, errors.New("cannot parse userID")
 	}
-	return userIDUUID, nil
+	sessionID := uuid.Nil
+	if claims.SessionID != "" {
+		sessionID, err = uuid.Parse(claims.SessionID)
+		if err != nil {
+			return uuid.Nil, uuid.Nil, errors.New("cannot parse sessionID")
+		}
+	}
+	return userIDUUID, sessionID, nil
 }
 
 func GetAPIKey(headers http.Header) (string, error) {
@@ -111,31 +148,107 @@ func MakeRefreshToken() (string, error) {
 	return hex.EncodeToString(randoBytes), nil
 }
 
+// Personal access tokens start with this, so they can be told apart from access tokens and spotted if one leaks.
+const PersonalAccessTokenPrefix = "chirpy_pat_"
+
+func MakePersonalAccessToken() (string, error) {
+	token, err := MakeRefreshToken()
+	if err != nil {
+		return "", err
+	}
+	return PersonalAccessTokenPrefix + token, nil
+}
+
+// HashToken is how random tokens we hand out are stored, so a copy of the database cannot be used to sign in.
+// They are long and random enough that a fast hash is all they need.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// KeyedHash is an HMAC-SHA256 of value, for values like email addresses that are too easy to guess for a plain hash,
+// without the key a list of addresses cannot be hashed to find which ones match.
+func KeyedHash(key string, value string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// MakeRecoveryCode makes a code the user can write down and type in if they lose their authenticator, like k3x9d-q7m2p.
+func MakeRecoveryCode() (string, error) {
+	randoBytes := make([]byte, 10)
+	_, err := rand.Read(randoBytes)
+	if err != nil {
+		return "", err
+	}
+	//32 letters and digits, leaving out ones easily mistaken for each other, so every byte maps evenly.
+	const alphabet = "abcdefghjkmnpqrstuvwxyz123456789"
+	code := make([]byte, 0, 11)
+	for i, randoByte := range randoBytes {
+		if i == 5 {
+			code = append(code, '-')
+		}
+		code = append(code, alphabet[int(randoByte)%len(alphabet)])
+	}
+	return string(code), nil
+}
+
+// NormalizeRecoveryCode puts a typed in recovery code in the form it was made in, ignoring case, spaces and dashes.
+func NormalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	if len(code) != 10 {
+		return code
+	}
+	return code[:5] + "-" + code[5:]
+}
+
+// ValidPKCEValue reports whether a PKCE code_verifier or S256 code_challenge has the form RFC 7636 requires,
+// 43 to 128 characters of letters, digits and -._~. A challenge is the 43 character base64url of a SHA-256.
+func ValidPKCEValue(value string) bool {
+	if len(value) < 43 || len(value) > 128 {
+		return false
+	}
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if !(c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '.' || c == '_' || c == '~') {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckCodeVerifier reports whether a PKCE code_verifier is the one behind an S256 code_challenge,
+// proving the app swapping a code for tokens is the same one that asked for it.
+func CheckCodeVerifier(verifier string, challenge string) bool {
+	sum := sha256.Sum256([]byte(verifier))
+	return subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(sum[:])), []byte(challenge)) == 1
+}
+
 //TEST FUNCTIONS
 
 func TestJWTGood(userID uuid.UUID) {
-	tokenSecret := "ThisIsATokenSecret"
+	keys := NewHMACKeySet("ThisIsATokenSecret")
 	//dur := 60 * time.Minute - No longer used
 
 	fmt.Println("Starting MakeJWT")
-	tokenString, err := MakeJWT(userID, tokenSecret)
+	tokenString, err := MakeJWT(userID, uuid.New(), "user", keys)
 	fmt.Printf("tokenString: %v, err: %v\n", tokenString, err)
 	fmt.Println("Starting ValidateJWT")
-	validateUserID, err := ValidateJWT(tokenString, tokenSecret)
+	validateUserID, err := ValidateJWT(tokenString, keys)
 	fmt.Printf("UserID from ValidateJWT: %v, err: %v\n", validateUserID, err)
 	fmt.Printf("userID passed: %v, userID returned: %v\n", userID, validateUserID)
 }
 
 func TestJWTBad(userID uuid.UUID) {
-	tokenSecret := "ThisIsATokenSecret"
+	keys := NewHMACKeySet("ThisIsATokenSecret")
 	//dur := 60 * time.Minute - No longer used
 
 	fmt.Println("Starting MakeJWT")
-	tokenString, err := MakeJWT(userID, tokenSecret)
+	tokenString, err := MakeJWT(userID, uuid.New(), "user", keys)
 	fmt.Printf("tokenString: %v, err: %v\n", tokenString, err)
 	fmt.Println("Starting ValidateJWT")
 	tokenString = tokenString + "bad"
-	validateUserID, err := ValidateJWT(tokenString, tokenSecret)
+	validateUserID, err := ValidateJWT(tokenString, keys)
 	fmt.Printf("UserID from ValidateJWT: %v, err: %v\n", validateUserID, err)
 	fmt.Printf("userID passed: %v, userID returned: %v\n", userID, validateUserID)
 }
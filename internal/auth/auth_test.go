@@ -0,0 +1,248 @@
+package auth
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func TestMakeJWTClaims(t *testing.T) {
+	keys := NewHMACKeySet("secret")
+	userID := uuid.New()
+	sessionID := uuid.New()
+	clientID := uuid.New()
+
+	loginToken, err := MakeJWT(userID, sessionID, "admin", keys)
+	if err != nil {
+		t.Fatalf("MakeJWT: %v", err)
+	}
+	appToken, err := MakeClientJWT(userID, sessionID, clientID, []string{"chirps:read", "chirps:write"}, keys)
+	if err != nil {
+		t.Fatalf("MakeClientJWT: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		tokenString  string
+		wantRole     string
+		wantClientID string
+		wantScope    string
+	}{
+		{"login", loginToken, "admin", "", ""},
+		{"app", appToken, "", clientID.String(), "chirps:read chirps:write"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims, err := ParseJWT(test.tokenString, keys)
+			if err != nil {
+				t.Fatalf("ParseJWT: %v", err)
+			}
+			if claims.Subject != userID.String() || claims.SessionID != sessionID.String() || claims.Issuer != "chirpy" {
+				t.Errorf("sub %q sid %q iss %q", claims.Subject, claims.SessionID, claims.Issuer)
+			}
+			if claims.Role != test.wantRole || claims.ClientID != test.wantClientID || claims.Scope != test.wantScope {
+				t.Errorf("role %q client_id %q scope %q", claims.Role, claims.ClientID, claims.Scope)
+			}
+			lifetime := claims.ExpiresAt.Sub(claims.IssuedAt.Time)
+			if lifetime != time.Hour {
+				t.Errorf("token lasts %v, want an hour", lifetime)
+			}
+		})
+	}
+}
+
+func TestValidateJWTSession(t *testing.T) {
+	keys := NewHMACKeySet("secret")
+	userID := uuid.New()
+	sessionID := uuid.New()
+	goodToken, err := MakeJWT(userID, sessionID, "user", keys)
+	if err != nil {
+		t.Fatalf("MakeJWT: %v", err)
+	}
+	sign := func(claims Claims) string {
+		tokenString, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("secret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tokenString
+	}
+	valid := jwt.NewNumericDate(time.Now().Add(time.Hour))
+
+	tests := []struct {
+		name          string
+		tokenString   string
+		keys          *KeySet
+		wantUserID    uuid.UUID
+		wantSessionID uuid.UUID
+		wantErr       bool
+	}{
+		{"good", goodToken, keys, userID, sessionID, false},
+		{"wrong secret", goodToken, NewHMACKeySet("other"), uuid.Nil, uuid.Nil, true},
+		{"tampered", goodToken + "x", keys, uuid.Nil, uuid.Nil, true},
+		{"expired", sign(Claims{RegisteredClaims: jwt.RegisteredClaims{Subject: userID.String(), ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Minute))}}), keys, uuid.Nil, uuid.Nil, true},
+		{"no session from before sessions", sign(Claims{RegisteredClaims: jwt.RegisteredClaims{Subject: userID.String(), ExpiresAt: valid}}), keys, userID, uuid.Nil, false},
+		{"subject not a uuid", sign(Claims{RegisteredClaims: jwt.RegisteredClaims{Subject: "someone", ExpiresAt: valid}}), keys, uuid.Nil, uuid.Nil, true},
+		{"session not a uuid", sign(Claims{RegisteredClaims: jwt.RegisteredClaims{Subject: userID.String(), ExpiresAt: valid}, SessionID: "x"}), keys, uuid.Nil, uuid.Nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gotUserID, gotSessionID, err := ValidateJWTSession(test.tokenString, test.keys)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("err = %v, want error %v", err, test.wantErr)
+			}
+			if gotUserID != test.wantUserID || gotSessionID != test.wantSessionID {
+				t.Errorf("got %v, %v, want %v, %v", gotUserID, gotSessionID, test.wantUserID, test.wantSessionID)
+			}
+		})
+	}
+}
+
+func TestCheckCodeVerifier(t *testing.T) {
+	//The example from RFC 7636 appendix B.
+	const verifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	const challenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	tests := []struct {
+		name      string
+		verifier  string
+		challenge string
+		want      bool
+	}{
+		{"matching", verifier, challenge, true},
+		{"wrong verifier", verifier + "x", challenge, false},
+		{"plain method is not accepted", verifier, verifier, false},
+		{"padded challenge", verifier, challenge + "=", false},
+		{"empty verifier", "", challenge, false},
+		{"empty both", "", "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := CheckCodeVerifier(test.verifier, test.challenge); got != test.want {
+				t.Errorf("CheckCodeVerifier = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestValidPKCEValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  bool
+	}{
+		{"RFC 7636 verifier", "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk", true},
+		{"shortest allowed", strings.Repeat("a", 43), true},
+		{"longest allowed", strings.Repeat("~", 128), true},
+		{"every unreserved character", "ABCXYZabcxyz0123456789-._~ABCXYZabcxyz01234", true},
+		{"too short", strings.Repeat("a", 42), false},
+		{"too long", strings.Repeat("a", 129), false},
+		{"empty", "", false},
+		{"padding", strings.Repeat("a", 43) + "=", false},
+		{"plus and slash", strings.Repeat("a", 42) + "+/", false},
+		{"space", strings.Repeat("a", 42) + " ", false},
+		{"non-ASCII", strings.Repeat("a", 42) + "é", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := ValidPKCEValue(test.value); got != test.want {
+				t.Errorf("ValidPKCEValue(%q) = %v, want %v", test.value, got, test.want)
+			}
+		})
+	}
+}
+
+func TestMakeRecoveryCode(t *testing.T) {
+	format := regexp.MustCompile(`^[abcdefghjkmnpqrstuvwxyz1-9]{5}-[abcdefghjkmnpqrstuvwxyz1-9]{5}$`)
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		code, err := MakeRecoveryCode()
+		if err != nil {
+			t.Fatalf("MakeRecoveryCode: %v", err)
+		}
+		if !format.MatchString(code) {
+			t.Fatalf("recovery code %q is not in the expected form", code)
+		}
+		if NormalizeRecoveryCode(code) != code {
+			t.Errorf("NormalizeRecoveryCode changed %q", code)
+		}
+		seen[code] = true
+	}
+	if len(seen) != 100 {
+		t.Errorf("got %d different codes out of 100", len(seen))
+	}
+}
+
+func TestNormalizeRecoveryCode(t *testing.T) {
+	tests := []struct {
+		code string
+		want string
+	}{
+		{"k3x9d-q7m2p", "k3x9d-q7m2p"},
+		{"K3X9D-Q7M2P", "k3x9d-q7m2p"},
+		{"k3x9dq7m2p", "k3x9d-q7m2p"},
+		{"k3x9d q7m2p", "k3x9d-q7m2p"},
+		{" k3x9d - q7m2p ", "k3x9d-q7m2p"},
+		{"k3-x9-dq-7m-2p", "k3x9d-q7m2p"},
+		//Anything the wrong length is left as it is, it will not match.
+		{"k3x9d", "k3x9d"},
+		{"k3x9d-q7m2p-z", "k3x9dq7m2pz"},
+	}
+	for _, test := range tests {
+		if got := NormalizeRecoveryCode(test.code); got != test.want {
+			t.Errorf("NormalizeRecoveryCode(%q) = %q, want %q", test.code, got, test.want)
+		}
+	}
+}
+
+func TestHashToken(t *testing.T) {
+	hash := HashToken("token")
+	if len(hash) != 64 || strings.Contains(hash, "token") {
+		t.Errorf("HashToken = %q, want 64 hex characters", hash)
+	}
+	if HashToken("token") != hash || HashToken("other") == hash {
+		t.Error("HashToken is not a stable hash of its input")
+	}
+}
+
+func TestKeyedHash(t *testing.T) {
+	hash := KeyedHash("key", "user@example.com")
+	if len(hash) != 64 || hash == HashToken("user@example.com") {
+		t.Errorf("KeyedHash = %q, want 64 hex characters that are not the plain hash", hash)
+	}
+	if KeyedHash("key", "user@example.com") != hash {
+		t.Error("KeyedHash is not a stable hash of its input")
+	}
+	if KeyedHash("other key", "user@example.com") == hash || KeyedHash("key", "other@example.com") == hash {
+		t.Error("KeyedHash does not depend on both the key and the value")
+	}
+}
+
+func TestGetBearerToken(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		want    string
+		wantErr bool
+	}{
+		{"bearer", "Bearer abc.def", "abc.def", false},
+		{"extra space", "Bearer   abc ", "abc", false},
+		{"missing", "", "", true},
+		{"wrong scheme", "ApiKey abc", "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			headers := map[string][]string{}
+			if test.header != "" {
+				headers["Authorization"] = []string{test.header}
+			}
+			got, err := GetBearerToken(headers)
+			if (err != nil) != test.wantErr || got != test.want {
+				t.Errorf("GetBearerToken = %q, %v, want %q, error %v", got, err, test.want, test.wantErr)
+			}
+		})
+	}
+}
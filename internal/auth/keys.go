@@ -0,0 +1,207 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// A key tokens are signed or checked with. Keys only used for checking have no private half.
+// A retired key only checks tokens issued before retiredAt, and only until acceptUntil.
+type signingKey struct {
+	id          string
+	method      jwt.SigningMethod
+	private     crypto.PrivateKey
+	public      crypto.PublicKey
+	retiredAt   time.Time
+	acceptUntil time.Time
+}
+
+// A KeySet holds every key a token might have been signed with, by kid, and the one new tokens are signed with.
+type KeySet struct {
+	keys    map[string]signingKey
+	current string
+}
+
+// NewHMACKeySet signs and checks tokens with a shared secret, the way tokens were made before there were key files.
+// Anything that checks these tokens needs the secret too, so there is nothing to publish.
+func NewHMACKeySet(secret string) *KeySet {
+	return &KeySet{
+		keys: map[string]signingKey{
+			"": {method: jwt.SigningMethodHS256, private: []byte(secret), public: []byte(secret)},
+		},
+	}
+}
+
+// AcceptHMAC keeps checking tokens signed with a shared secret for a while, without ever signing with it. Adding the
+// old SECRET to a key set loaded from files lets tokens made before the switch at retiredAt keep working until they
+// expire. Nothing is accepted with the secret after until, so whoever has seen it cannot make tokens for long.
+func (keySet *KeySet) AcceptHMAC(secret string, retiredAt time.Time, until time.Time) {
+	keySet.keys[""] = signingKey{method: jwt.SigningMethodHS256, public: []byte(secret), retiredAt: retiredAt, acceptUntil: until}
+}
+
+// LoadKeySet reads every .pem file in the directory, each file name without the extension is the key's kid.
+// Files holding a PKCS8 private key can sign, RSA keys use RS256 and Ed25519 keys use EdDSA. Files holding only
+// a public key are kept for checking tokens. New tokens are signed with the private key whose name sorts last,
+// so naming keys by the date they were made means adding a new file rotates to it, and the older files can
+// be removed or cut down to their public key once the tokens they signed have expired.
+func LoadKeySet(dir string) (*KeySet, error) {
+	fileNames, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(fileNames)
+
+	keySet := &KeySet{keys: make(map[string]signingKey)}
+	for _, fileName := range fileNames {
+		data, err := os.ReadFile(fileName)
+		if err != nil {
+			return nil, err
+		}
+		key, err := parseKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fileName, err)
+		}
+		key.id = strings.TrimSuffix(filepath.Base(fileName), ".pem")
+		keySet.keys[key.id] = key
+		if key.private != nil {
+			keySet.current = key.id
+		}
+	}
+
+	if keySet.current == "" {
+		return nil, fmt.Errorf("no private keys found in %s", dir)
+	}
+	return keySet, nil
+}
+
+func parseKey(data []byte) (signingKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return signingKey{}, errors.New("no PEM data found")
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return signingKey{}, err
+		}
+		switch private := parsed.(type) {
+		case *rsa.PrivateKey:
+			return signingKey{method: jwt.SigningMethodRS256, private: private, public: &private.PublicKey}, nil
+		case ed25519.PrivateKey:
+			return signingKey{method: jwt.SigningMethodEdDSA, private: private, public: private.Public()}, nil
+		}
+	case "PUBLIC KEY":
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return signingKey{}, err
+		}
+		switch public := parsed.(type) {
+		case *rsa.PublicKey:
+			return signingKey{method: jwt.SigningMethodRS256, public: public}, nil
+		case ed25519.PublicKey:
+			return signingKey{method: jwt.SigningMethodEdDSA, public: public}, nil
+		}
+	}
+	return signingKey{}, errors.New("only RSA and Ed25519 keys in PKCS8 or PKIX form are supported")
+}
+
+// Signs the token with the current key and names the key in its kid header.
+func (keySet *KeySet) sign(claims jwt.Claims) (string, error) {
+	key := keySet.keys[keySet.current]
+	token := jwt.NewWithClaims(key.method, claims)
+	if key.id != "" {
+		token.Header["kid"] = key.id
+	}
+	return token.SignedString(key.private)
+}
+
+// Finds the key a token names, and makes sure the token uses that key's algorithm so a public key can never
+// be passed off as an HMAC secret.
+func (keySet *KeySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	keyID, _ := token.Header["kid"].(string)
+	key, found := keySet.keys[keyID]
+	if !found {
+		return nil, errors.New("unknown signing key")
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, errors.New("unexpected signing method")
+	}
+	if !key.retiredAt.IsZero() {
+		if !time.Now().Before(key.acceptUntil) {
+			return nil, errors.New("signing key is no longer accepted")
+		}
+		claims, ok := token.Claims.(*Claims)
+		if !ok || claims.IssuedAt == nil || claims.IssuedAt.After(key.retiredAt) {
+			return nil, errors.New("token issued after its signing key was retired")
+		}
+	}
+	return key.public, nil
+}
+
+// A public key in JSON Web Key form.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS lists the public half of every key, so other services can check our tokens without asking us.
+// Shared secrets are never listed.
+func (keySet *KeySet) JWKS() JWKS {
+	keyIDs := make([]string, 0, len(keySet.keys))
+	for keyID := range keySet.keys {
+		keyIDs = append(keyIDs, keyID)
+	}
+	sort.Strings(keyIDs)
+
+	jwks := JWKS{Keys: make([]JWK, 0, len(keyIDs))}
+	for _, keyID := range keyIDs {
+		key := keySet.keys[keyID]
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			jwks.Keys = append(jwks.Keys, JWK{
+				KeyType:   "RSA",
+				KeyID:     keyID,
+				Use:       "sig",
+				Algorithm: key.method.Alg(),
+				N:         base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			jwks.Keys = append(jwks.Keys, JWK{
+				KeyType:   "OKP",
+				KeyID:     keyID,
+				Use:       "sig",
+				Algorithm: key.method.Alg(),
+				Curve:     "Ed25519",
+				X:         base64.RawURLEncoding.EncodeToString(public),
+			})
+		}
+	}
+	return jwks
+}
@@ -0,0 +1,358 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Writes a key to dir as kid.pem, the private key or only its public half.
+func writeTestKey(t *testing.T, dir string, kid string, private any, publicOnly bool) {
+	t.Helper()
+	var block *pem.Block
+	if publicOnly {
+		var public any
+		switch key := private.(type) {
+		case *rsa.PrivateKey:
+			public = &key.PublicKey
+		case ed25519.PrivateKey:
+			public = key.Public()
+		}
+		der, err := x509.MarshalPKIXPublicKey(public)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block = &pem.Block{Type: "PUBLIC KEY", Bytes: der}
+	} else {
+		der, err := x509.MarshalPKCS8PrivateKey(private)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	}
+	err := os.WriteFile(filepath.Join(dir, kid+".pem"), pem.EncodeToMemory(block), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newEd25519Key(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return private
+}
+
+func newRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return private
+}
+
+// The kid and alg a token was signed with, read without checking it.
+func tokenHeader(t *testing.T, tokenString string) (string, string) {
+	t.Helper()
+	token, _, err := jwt.NewParser().ParseUnverified(tokenString, &Claims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kid, _ := token.Header["kid"].(string)
+	return kid, token.Method.Alg()
+}
+
+func TestLoadKeySetSignsWithLatestKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		latest  any
+		wantAlg string
+	}{
+		{"ed25519", newEd25519Key(t), "EdDSA"},
+		{"rsa", newRSAKey(t), "RS256"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTestKey(t, dir, "2024-01-01", newEd25519Key(t), false)
+			writeTestKey(t, dir, "2024-06-01", test.latest, false)
+
+			keys, err := LoadKeySet(dir)
+			if err != nil {
+				t.Fatalf("LoadKeySet: %v", err)
+			}
+			tokenString, err := MakeJWT(uuid.New(), uuid.New(), "user", keys)
+			if err != nil {
+				t.Fatalf("MakeJWT: %v", err)
+			}
+			kid, alg := tokenHeader(t, tokenString)
+			if kid != "2024-06-01" || alg != test.wantAlg {
+				t.Errorf("signed with kid %q alg %q, want 2024-06-01 %s", kid, alg, test.wantAlg)
+			}
+		})
+	}
+}
+
+func TestLoadKeySetKeepsCheckingOldKeys(t *testing.T) {
+	oldKey := newEd25519Key(t)
+	userID := uuid.New()
+
+	//A token signed before the rotation.
+	oldDir := t.TempDir()
+	writeTestKey(t, oldDir, "2024-01-01", oldKey, false)
+	oldKeys, err := LoadKeySet(oldDir)
+	if err != nil {
+		t.Fatalf("LoadKeySet: %v", err)
+	}
+	oldToken, err := MakeJWT(userID, uuid.New(), "user", oldKeys)
+	if err != nil {
+		t.Fatalf("MakeJWT: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		keepOldKey bool
+		publicOnly bool
+		wantValid  bool
+	}{
+		{"old private key kept", true, false, true},
+		{"old key cut down to its public half", true, true, true},
+		{"old key removed", false, false, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTestKey(t, dir, "2024-06-01", newEd25519Key(t), false)
+			if test.keepOldKey {
+				writeTestKey(t, dir, "2024-01-01", oldKey, test.publicOnly)
+			}
+			keys, err := LoadKeySet(dir)
+			if err != nil {
+				t.Fatalf("LoadKeySet: %v", err)
+			}
+			gotUserID, err := ValidateJWT(oldToken, keys)
+			if (err == nil) != test.wantValid {
+				t.Fatalf("ValidateJWT err = %v, want valid %v", err, test.wantValid)
+			}
+			if test.wantValid && gotUserID != userID {
+				t.Errorf("user = %v, want %v", gotUserID, userID)
+			}
+		})
+	}
+}
+
+func TestLoadKeySetErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, dir string)
+	}{
+		{"no keys", func(t *testing.T, dir string) {}},
+		{"only public keys", func(t *testing.T, dir string) {
+			writeTestKey(t, dir, "2024-01-01", newEd25519Key(t), true)
+		}},
+		{"not PEM", func(t *testing.T, dir string) {
+			os.WriteFile(filepath.Join(dir, "2024-01-01.pem"), []byte("not a key"), 0o600)
+		}},
+		{"unsupported PEM type", func(t *testing.T, dir string) {
+			os.WriteFile(filepath.Join(dir, "2024-01-01.pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte{1}}), 0o600)
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			test.setup(t, dir)
+			_, err := LoadKeySet(dir)
+			if err == nil {
+				t.Error("LoadKeySet gave no error")
+			}
+		})
+	}
+}
+
+func TestKeyFuncRejectsMismatchedTokens(t *testing.T) {
+	dir := t.TempDir()
+	private := newEd25519Key(t)
+	writeTestKey(t, dir, "2024-06-01", private, false)
+	keys, err := LoadKeySet(dir)
+	if err != nil {
+		t.Fatalf("LoadKeySet: %v", err)
+	}
+	claims := Claims{RegisteredClaims: jwt.RegisteredClaims{
+		Subject:   uuid.NewString(),
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}}
+	sign := func(method jwt.SigningMethod, kid string, key any) string {
+		token := jwt.NewWithClaims(method, claims)
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		tokenString, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tokenString
+	}
+
+	tests := []struct {
+		name        string
+		tokenString string
+		wantValid   bool
+	}{
+		{"right key and algorithm", sign(jwt.SigningMethodEdDSA, "2024-06-01", private), true},
+		{"unknown kid", sign(jwt.SigningMethodEdDSA, "2023-01-01", private), false},
+		{"no kid", sign(jwt.SigningMethodEdDSA, "", private), false},
+		//The classic confusion, the public key passed off as an HMAC secret.
+		{"public key as HMAC secret", sign(jwt.SigningMethodHS256, "2024-06-01", []byte(private.Public().(ed25519.PublicKey))), false},
+		{"signed by another key", sign(jwt.SigningMethodEdDSA, "2024-06-01", newEd25519Key(t)), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ValidateJWT(test.tokenString, keys)
+			if (err == nil) != test.wantValid {
+				t.Errorf("ValidateJWT err = %v, want valid %v", err, test.wantValid)
+			}
+		})
+	}
+}
+
+func TestAcceptHMAC(t *testing.T) {
+	userID := uuid.New()
+	hmacToken, err := MakeJWT(userID, uuid.New(), "user", NewHMACKeySet("old secret"))
+	if err != nil {
+		t.Fatalf("MakeJWT: %v", err)
+	}
+
+	dir := t.TempDir()
+	writeTestKey(t, dir, "2024-06-01", newEd25519Key(t), false)
+	keys, err := LoadKeySet(dir)
+	if err != nil {
+		t.Fatalf("LoadKeySet: %v", err)
+	}
+	_, err = ValidateJWT(hmacToken, keys)
+	if err == nil {
+		t.Error("HMAC token accepted before AcceptHMAC")
+	}
+
+	keys.AcceptHMAC("old secret", time.Now(), time.Now().Add(time.Hour))
+	gotUserID, err := ValidateJWT(hmacToken, keys)
+	if err != nil || gotUserID != userID {
+		t.Errorf("ValidateJWT = %v, %v, want %v", gotUserID, err, userID)
+	}
+	_, err = ValidateJWT(hmacToken, func() *KeySet {
+		other, _ := LoadKeySet(dir)
+		other.AcceptHMAC("another secret", time.Now(), time.Now().Add(time.Hour))
+		return other
+	}())
+	if err == nil {
+		t.Error("HMAC token accepted with the wrong secret")
+	}
+
+	//The secret is only for checking, new tokens still use the key file and it is never published.
+	newToken, err := MakeJWT(userID, uuid.New(), "user", keys)
+	if err != nil {
+		t.Fatalf("MakeJWT: %v", err)
+	}
+	if kid, alg := tokenHeader(t, newToken); kid != "2024-06-01" || alg != "EdDSA" {
+		t.Errorf("signed with kid %q alg %q after AcceptHMAC", kid, alg)
+	}
+	for _, jwk := range keys.JWKS().Keys {
+		if jwk.KeyID == "" {
+			t.Error("JWKS lists the HMAC secret")
+		}
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	dir := t.TempDir()
+	writeTestKey(t, dir, "a-rsa", newRSAKey(t), true)
+	writeTestKey(t, dir, "b-ed25519", newEd25519Key(t), false)
+	keys, err := LoadKeySet(dir)
+	if err != nil {
+		t.Fatalf("LoadKeySet: %v", err)
+	}
+
+	jwks := keys.JWKS()
+	want := []struct {
+		kid string
+		kty string
+		alg string
+	}{
+		{"a-rsa", "RSA", "RS256"},
+		{"b-ed25519", "OKP", "EdDSA"},
+	}
+	if len(jwks.Keys) != len(want) {
+		t.Fatalf("JWKS has %d keys, want %d", len(jwks.Keys), len(want))
+	}
+	for i, jwk := range jwks.Keys {
+		if jwk.KeyID != want[i].kid || jwk.KeyType != want[i].kty || jwk.Algorithm != want[i].alg || jwk.Use != "sig" {
+			t.Errorf("key %d = %+v, want %+v", i, jwk, want[i])
+		}
+	}
+	if jwks.Keys[0].N == "" || jwks.Keys[0].E != "AQAB" {
+		t.Errorf("RSA key is missing n or e: %+v", jwks.Keys[0])
+	}
+	if jwks.Keys[1].Curve != "Ed25519" || jwks.Keys[1].X == "" {
+		t.Errorf("Ed25519 key is missing crv or x: %+v", jwks.Keys[1])
+	}
+
+	//A shared secret has nothing to publish.
+	if len(NewHMACKeySet("secret").JWKS().Keys) != 0 {
+		t.Error("HMAC key set published a key")
+	}
+}
+
+func TestAcceptHMACCutoff(t *testing.T) {
+	dir := t.TempDir()
+	writeTestKey(t, dir, "2024-06-01", newEd25519Key(t), false)
+	now := time.Now()
+	retiredAt := now.Add(-10 * time.Minute)
+	sign := func(issuedAt *jwt.NumericDate) string {
+		claims := Claims{RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   uuid.NewString(),
+			IssuedAt:  issuedAt,
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		}}
+		tokenString, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("old secret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tokenString
+	}
+
+	tests := []struct {
+		name        string
+		tokenString string
+		until       time.Time
+		wantValid   bool
+	}{
+		{"issued before the switch", sign(jwt.NewNumericDate(retiredAt.Add(-time.Minute))), now.Add(time.Hour), true},
+		{"issued after the switch", sign(jwt.NewNumericDate(retiredAt.Add(time.Minute))), now.Add(time.Hour), false},
+		{"no issued at", sign(nil), now.Add(time.Hour), false},
+		{"after the deadline", sign(jwt.NewNumericDate(retiredAt.Add(-time.Minute))), now.Add(-time.Second), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			keys, err := LoadKeySet(dir)
+			if err != nil {
+				t.Fatalf("LoadKeySet: %v", err)
+			}
+			keys.AcceptHMAC("old secret", retiredAt, test.until)
+			_, err = ValidateJWT(test.tokenString, keys)
+			if (err == nil) != test.wantValid {
+				t.Errorf("ValidateJWT err = %v, want valid %v", err, test.wantValid)
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hashes are stored in the PHC string format, which names the algorithm and carries everything needed
+// to check a password against it:
+//
+//	$argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+//
+// so the parameters can be raised at any time and hashes made before keep working. Hashes made before
+// argon2id was used are bcrypt ($2a$...), they still check but are reported as outdated.
+
+// PasswordParams are the argon2id costs new hashes are made with.
+// Memory is in KiB. Raising any of them makes older hashes outdated, and they are replaced as users login.
+type PasswordParams struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultPasswordParams follow the OWASP recommendation for argon2id.
+var DefaultPasswordParams = PasswordParams{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+var ErrPasswordMismatch = errors.New("password does not match")
+
+// Each argon2id hash takes PasswordParams.Memory to make, so only so many are made at once and the rest wait
+// their turn. Anything still waiting after hashingWait gets ErrHashingBusy, rather than the server running out
+// of memory when logins pile up.
+const maxConcurrentHashes = 4
+const hashingWait = time.Second
+
+var hashingSlots = make(chan struct{}, maxConcurrentHashes)
+
+var ErrHashingBusy = errors.New("too many passwords being hashed at once")
+
+// Waits for a hashing slot, the returned func gives it back.
+func acquireHashingSlot() (func(), error) {
+	timer := time.NewTimer(hashingWait)
+	defer timer.Stop()
+	select {
+	case hashingSlots <- struct{}{}:
+		return func() { <-hashingSlots }, nil
+	case <-timer.C:
+		return nil, ErrHashingBusy
+	}
+}
+
+// Every hash says what made it, the prefix picks how it is checked.
+const argon2idPrefix = "$argon2id$"
+
+func HashPassword(password string, params PasswordParams) (string, error) {
+	salt := make([]byte, params.SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+	release, err := acquireHashingSlot()
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	key := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix,
+		argon2.Version,
+		params.Memory,
+		params.Iterations,
+		params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Pulls the parameters, salt and key back out of an argon2id hash.
+func parseArgon2idHash(hash string) (PasswordParams, []byte, []byte, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return PasswordParams{}, nil, nil, errors.New("not an argon2id hash")
+	}
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return PasswordParams{}, nil, nil, errors.New("unsupported argon2 version")
+	}
+	params := PasswordParams{}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil {
+		return PasswordParams{}, nil, nil, errors.New("malformed argon2id parameters")
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return PasswordParams{}, nil, nil, errors.New("malformed argon2id salt")
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return PasswordParams{}, nil, nil, errors.New("malformed argon2id hash")
+	}
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	return params, salt, key, nil
+}
+
+// CheckPasswordHash checks a password against a stored hash of any format we have used.
+// When it matches, outdated says whether the hash should be replaced with one made by HashPassword with params.
+// Passwords too long to have been allowed are turned away before any hashing, they cannot match.
+func CheckPasswordHash(password, hash string, params PasswordParams) (bool, error) {
+	if utf8.RuneCountInString(password) > MaxPasswordLength {
+		return false, ErrPasswordMismatch
+	}
+	if !strings.HasPrefix(hash, argon2idPrefix) {
+		//bcrypt takes a turn too, so a login for a legacy account is never answered busy when one for a missing account would not be.
+		release, err := acquireHashingSlot()
+		if err != nil {
+			return false, err
+		}
+		defer release()
+		//func CompareHashAndPassword(hashedPassword, password []byte) error
+		err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, ErrPasswordMismatch
+		}
+		if err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
+	hashParams, salt, key, err := parseArgon2idHash(hash)
+	if err != nil {
+		return false, err
+	}
+	release, err := acquireHashingSlot()
+	if err != nil {
+		return false, err
+	}
+	defer release()
+	passwordKey := argon2.IDKey([]byte(password), salt, hashParams.Iterations, hashParams.Memory, hashParams.Parallelism, hashParams.KeyLength)
+	if subtle.ConstantTimeCompare(passwordKey, key) != 1 {
+		return false, ErrPasswordMismatch
+	}
+	return hashParams != params, nil
+}
+
+// CheckDummyPasswordHash does the same work as checking a password with params, for an email with no account.
+// The login then takes as long as it would for a real account, so timing does not give away who has signed up.
+// Like CheckPasswordHash it can be too busy, and the caller should answer the same way it would for a real account.
+// Accounts still on a bcrypt hash are checked faster than this, so until their owners next log in and the hash
+// is replaced, a quicker answer tells they have an account. Accounts that never log in again keep that gap.
+func CheckDummyPasswordHash(password string, params PasswordParams) error {
+	if utf8.RuneCountInString(password) > MaxPasswordLength {
+		return nil
+	}
+	release, err := acquireHashingSlot()
+	if err != nil {
+		return err
+	}
+	defer release()
+	salt := make([]byte, params.SaltLength)
+	argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	return nil
+}
+
+// Passwords longer than this are turned away, hashing one costs time in proportion to its length.
+const MaxPasswordLength = 1024
+
+// PasswordPolicy is what a new password has to pass, a minimum length and not being a known breached password.
+type PasswordPolicy struct {
+	MinLength int
+	//SHA-1 hashes of breached passwords, so a big list does not keep the passwords themselves in memory.
+	breached map[[sha1.Size]byte]struct{}
+}
+
+// LoadPasswordPolicy reads the breached password list from breachedFile, if one is given. The file has one
+// password per line, or one SHA-1 hash per line in the Have I Been Pwned form HASH:COUNT.
+func LoadPasswordPolicy(minLength int, breachedFile string) (*PasswordPolicy, error) {
+	policy := &PasswordPolicy{MinLength: minLength, breached: map[[sha1.Size]byte]struct{}{}}
+	if breachedFile == "" {
+		return policy, nil
+	}
+
+	file, err := os.Open(breachedFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		var sum [sha1.Size]byte
+		hashPart, _, _ := strings.Cut(line, ":")
+		if decoded, err := hex.DecodeString(hashPart); err == nil && len(decoded) == sha1.Size {
+			copy(sum[:], decoded)
+		} else {
+			sum = sha1.Sum([]byte(line))
+		}
+		policy.breached[sum] = struct{}{}
+	}
+	err = scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// Why PasswordPolicy.Check turns a password away, how to put it to the user is up to the caller.
+var (
+	ErrPasswordTooShort = errors.New("password is too short")
+	ErrPasswordTooLong  = errors.New("password is too long")
+	ErrPasswordBreached = errors.New("password has appeared in a data breach")
+)
+
+// Check returns why a password is not allowed, one of ErrPasswordTooShort, ErrPasswordTooLong or ErrPasswordBreached.
+func (policy *PasswordPolicy) Check(password string) error {
+	length := utf8.RuneCountInString(password)
+	if length < policy.MinLength {
+		return ErrPasswordTooShort
+	}
+	if length > MaxPasswordLength {
+		return ErrPasswordTooLong
+	}
+	if _, found := policy.breached[sha1.Sum([]byte(password))]; found {
+		return ErrPasswordBreached
+	}
+	return nil
+}
+
+// BreachedCount is how many passwords are on the breached list.
+func (policy *PasswordPolicy) BreachedCount() int {
+	return len(policy.breached)
+}
@@ -0,0 +1,181 @@
+package auth
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Cheap settings so the tests run quickly, the format is the same whatever the costs.
+var testPasswordParams = PasswordParams{
+	Memory:      1024,
+	Iterations:  1,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+func TestHashPasswordFormat(t *testing.T) {
+	hash, err := HashPassword("correct horse", testPasswordParams)
+	if err != nil {
+		t.Fatalf("HashPassword: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Errorf("hash %q does not record its settings", hash)
+	}
+	params, salt, key, err := parseArgon2idHash(hash)
+	if err != nil {
+		t.Fatalf("parseArgon2idHash: %v", err)
+	}
+	if params != testPasswordParams || len(salt) != 16 || len(key) != 32 {
+		t.Errorf("parsed %+v with %d byte salt and %d byte key", params, len(salt), len(key))
+	}
+
+	other, _ := HashPassword("correct horse", testPasswordParams)
+	if other == hash {
+		t.Error("two hashes of the same password were the same, salt is not random")
+	}
+}
+
+func TestCheckPasswordHash(t *testing.T) {
+	argon2Hash, err := HashPassword("correct horse", testPasswordParams)
+	if err != nil {
+		t.Fatalf("HashPassword: %v", err)
+	}
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("bcrypt: %v", err)
+	}
+	raisedParams := testPasswordParams
+	raisedParams.Iterations = 2
+
+	tests := []struct {
+		name         string
+		password     string
+		hash         string
+		params       PasswordParams
+		wantOutdated bool
+		wantErr      error
+	}{
+		{"argon2id match", "correct horse", argon2Hash, testPasswordParams, false, nil},
+		{"argon2id mismatch", "wrong horse", argon2Hash, testPasswordParams, false, ErrPasswordMismatch},
+		{"argon2id with raised settings", "correct horse", argon2Hash, raisedParams, true, nil},
+		{"argon2id mismatch with raised settings", "wrong horse", argon2Hash, raisedParams, false, ErrPasswordMismatch},
+		{"bcrypt match is outdated", "correct horse", string(bcryptHash), testPasswordParams, true, nil},
+		{"bcrypt mismatch", "wrong horse", string(bcryptHash), testPasswordParams, false, ErrPasswordMismatch},
+		{"too long to have been allowed", strings.Repeat("a", MaxPasswordLength+1), argon2Hash, testPasswordParams, false, ErrPasswordMismatch},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			outdated, err := CheckPasswordHash(test.password, test.hash, test.params)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("err = %v, want %v", err, test.wantErr)
+			}
+			if outdated != test.wantOutdated {
+				t.Errorf("outdated = %v, want %v", outdated, test.wantOutdated)
+			}
+		})
+	}
+}
+
+func TestCheckPasswordHashMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		hash string
+	}{
+		{"empty", ""},
+		{"not a hash", "hunter2"},
+		{"missing parts", "$argon2id$v=19$m=1024,t=1,p=1$c2FsdA"},
+		{"wrong version", "$argon2id$v=16$m=1024,t=1,p=1$c2FsdHNhbHRzYWx0c2FsdA$a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U"},
+		{"bad parameters", "$argon2id$v=19$memory=1024$c2FsdHNhbHRzYWx0c2FsdA$a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U"},
+		{"bad salt", "$argon2id$v=19$m=1024,t=1,p=1$!!!$a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U"},
+		{"bad key", "$argon2id$v=19$m=1024,t=1,p=1$c2FsdHNhbHRzYWx0c2FsdA$!!!"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := CheckPasswordHash("hunter2", test.hash, testPasswordParams)
+			if err == nil {
+				t.Error("CheckPasswordHash gave no error")
+			}
+		})
+	}
+}
+
+func TestCheckDummyPasswordHash(t *testing.T) {
+	err := CheckDummyPasswordHash("anything", testPasswordParams)
+	if err != nil {
+		t.Errorf("CheckDummyPasswordHash: %v", err)
+	}
+}
+
+func TestHashingBusy(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//Take every slot, so the next hash has to wait and give up.
+	for i := 0; i < maxConcurrentHashes; i++ {
+		hashingSlots <- struct{}{}
+	}
+	defer func() {
+		for i := 0; i < maxConcurrentHashes; i++ {
+			<-hashingSlots
+		}
+	}()
+
+	_, err = HashPassword("correct horse", testPasswordParams)
+	if err != ErrHashingBusy {
+		t.Errorf("HashPassword err = %v, want ErrHashingBusy", err)
+	}
+	//A legacy bcrypt account waits its turn the same as a missing one.
+	_, err = CheckPasswordHash("correct horse", string(bcryptHash), testPasswordParams)
+	if err != ErrHashingBusy {
+		t.Errorf("CheckPasswordHash with bcrypt err = %v, want ErrHashingBusy", err)
+	}
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	//One plain password and one in the Have I Been Pwned form.
+	hibpSum := sha1.Sum([]byte("letmein1"))
+	breachedFile := filepath.Join(t.TempDir(), "breached.txt")
+	err := os.WriteFile(breachedFile, []byte("password123\r\n\n"+strings.ToUpper(hex.EncodeToString(hibpSum[:]))+":42\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, err := LoadPasswordPolicy(8, breachedFile)
+	if err != nil {
+		t.Fatalf("LoadPasswordPolicy: %v", err)
+	}
+	if policy.BreachedCount() != 2 {
+		t.Errorf("BreachedCount = %d, want 2", policy.BreachedCount())
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		wantErr  error
+	}{
+		{"long enough", "correct horse", nil},
+		{"too short", "short", ErrPasswordTooShort},
+		{"counts characters not bytes", "ééééééé", ErrPasswordTooShort},
+		{"longest allowed", strings.Repeat("a", MaxPasswordLength), nil},
+		{"too long", strings.Repeat("a", MaxPasswordLength+1), ErrPasswordTooLong},
+		{"breached", "password123", ErrPasswordBreached},
+		{"breached by hash", "letmein1", ErrPasswordBreached},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := policy.Check(test.password)
+			if err != test.wantErr {
+				t.Errorf("Check err = %v, want %v", err, test.wantErr)
+			}
+		})
+	}
+}
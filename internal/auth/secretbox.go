@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+)
+
+// A SecretBox encrypts secrets we have to be able to read back, like TOTP secrets, before they are stored.
+// It uses AES-256-GCM, a fresh nonce is put in front of every sealed value.
+type SecretBox struct {
+	aead cipher.AEAD
+}
+
+// NewSecretBox takes the key as base64, it must decode to 32 bytes.
+// One can be made with: openssl rand -base64 32
+func NewSecretBox(encodedKey string) (*SecretBox, error) {
+	key, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil {
+		return nil, errors.New("encryption key is not valid base64")
+	}
+	if len(key) != 32 {
+		return nil, errors.New("encryption key must be 32 bytes")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &SecretBox{aead: aead}, nil
+}
+
+// Seal encrypts the plaintext, returning it as base64 ready to store.
+func (box *SecretBox) Seal(plaintext string) (string, error) {
+	nonce := make([]byte, box.aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+	sealed := box.aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a value made by Seal, failing if it was made with another key or has been changed.
+func (box *SecretBox) Open(sealed string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < box.aead.NonceSize() {
+		return "", errors.New("sealed value is too short")
+	}
+	nonce, ciphertext := data[:box.aead.NonceSize()], data[box.aead.NonceSize():]
+	plaintext, err := box.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
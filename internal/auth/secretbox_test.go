@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func newTestSecretBox(t *testing.T, fill byte) *SecretBox {
+	t.Helper()
+	box, err := NewSecretBox(base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(rune(fill)), 32))))
+	if err != nil {
+		t.Fatalf("NewSecretBox: %v", err)
+	}
+	return box
+}
+
+func TestNewSecretBoxKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		wantErr bool
+	}{
+		{"32 bytes", base64.StdEncoding.EncodeToString(make([]byte, 32)), false},
+		{"16 bytes", base64.StdEncoding.EncodeToString(make([]byte, 16)), true},
+		{"33 bytes", base64.StdEncoding.EncodeToString(make([]byte, 33)), true},
+		{"not base64", "not base64!", true},
+		{"empty", "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewSecretBox(test.key)
+			if (err != nil) != test.wantErr {
+				t.Errorf("NewSecretBox err = %v, want error %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestSecretBoxSealOpen(t *testing.T) {
+	box := newTestSecretBox(t, 'a')
+	for _, plaintext := range []string{"", "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", strings.Repeat("x", 1000)} {
+		sealed, err := box.Seal(plaintext)
+		if err != nil {
+			t.Fatalf("Seal: %v", err)
+		}
+		if plaintext != "" && strings.Contains(sealed, plaintext) {
+			t.Error("sealed value contains the plaintext")
+		}
+		opened, err := box.Open(sealed)
+		if err != nil || opened != plaintext {
+			t.Errorf("Open = %q, %v, want %q", opened, err, plaintext)
+		}
+	}
+
+	//Every seal gets its own nonce.
+	first, _ := box.Seal("same")
+	second, _ := box.Seal("same")
+	if first == second {
+		t.Error("sealing the same value twice gave the same result")
+	}
+}
+
+func TestSecretBoxOpenRejects(t *testing.T) {
+	box := newTestSecretBox(t, 'a')
+	sealed, err := box.Seal("secret")
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	data, _ := base64.StdEncoding.DecodeString(sealed)
+	tampered := append([]byte{}, data...)
+	tampered[len(tampered)-1] ^= 1
+
+	tests := []struct {
+		name   string
+		box    *SecretBox
+		sealed string
+	}{
+		{"another key", newTestSecretBox(t, 'b'), sealed},
+		{"changed", box, base64.StdEncoding.EncodeToString(tampered)},
+		{"too short", box, base64.StdEncoding.EncodeToString(data[:4])},
+		{"not base64", box, "not base64!"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := test.box.Open(test.sealed)
+			if err == nil {
+				t.Error("Open gave no error")
+			}
+		})
+	}
+}
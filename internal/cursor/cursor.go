@@ -0,0 +1,71 @@
+package cursor
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Cursors are opaque to clients, they are just the created_at and id of the last row seen, base64 encoded.
+const separator = "|"
+
+func Encode(createdAt time.Time, id uuid.UUID) string {
+	raw := createdAt.UTC().Format(time.RFC3339Nano) + separator + id.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func Decode(encoded string) (time.Time, uuid.UUID, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return time.Time{}, uuid.Nil, errors.New("cursor is not valid base64")
+	}
+
+	createdAtString, idString, found := strings.Cut(string(raw), separator)
+	if !found {
+		return time.Time{}, uuid.Nil, errors.New("cursor is malformed")
+	}
+
+	createdAt, err := time.Parse(time.RFC3339Nano, createdAtString)
+	if err != nil {
+		return time.Time{}, uuid.Nil, errors.New("cursor has an invalid timestamp")
+	}
+
+	id, err := uuid.Parse(idString)
+	if err != nil {
+		return time.Time{}, uuid.Nil, errors.New("cursor has an invalid id")
+	}
+	return createdAt.UTC(), id, nil
+}
+
+// Ranked results are ordered by rank first, so their cursors carry the rank of the last row too.
+func EncodeRanked(rank float32, createdAt time.Time, id uuid.UUID) string {
+	raw := strconv.FormatFloat(float64(rank), 'g', -1, 32) + separator + Encode(createdAt, id)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func DecodeRanked(encoded string) (float32, time.Time, uuid.UUID, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return 0, time.Time{}, uuid.Nil, errors.New("cursor is not valid base64")
+	}
+
+	rankString, rest, found := strings.Cut(string(raw), separator)
+	if !found {
+		return 0, time.Time{}, uuid.Nil, errors.New("cursor is malformed")
+	}
+
+	rank, err := strconv.ParseFloat(rankString, 32)
+	if err != nil {
+		return 0, time.Time{}, uuid.Nil, errors.New("cursor has an invalid rank")
+	}
+
+	createdAt, id, err := Decode(rest)
+	if err != nil {
+		return 0, time.Time{}, uuid.Nil, err
+	}
+	return float32(rank), createdAt, id, nil
+}
@@ -0,0 +1,97 @@
+package cursor
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	id := uuid.New()
+	tests := []struct {
+		name      string
+		createdAt time.Time
+	}{
+		{"whole seconds", time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)},
+		{"microseconds", time.Date(2024, 6, 1, 12, 0, 0, 123456000, time.UTC)},
+		{"other time zone", time.Date(2024, 6, 1, 12, 0, 0, 0, time.FixedZone("east", 3*60*60))},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			createdAt, gotID, err := Decode(Encode(test.createdAt, id))
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if !createdAt.Equal(test.createdAt) || createdAt.Location() != time.UTC {
+				t.Errorf("created_at = %v, want %v in UTC", createdAt, test.createdAt)
+			}
+			if gotID != id {
+				t.Errorf("id = %v, want %v", gotID, id)
+			}
+		})
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	encode := func(raw string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(raw))
+	}
+	tests := []struct {
+		name    string
+		encoded string
+	}{
+		{"not base64", "***"},
+		{"no separator", encode("2024-06-01T12:00:00Z")},
+		{"bad timestamp", encode("yesterday|" + uuid.NewString())},
+		{"bad id", encode("2024-06-01T12:00:00Z|not-a-uuid")},
+		{"empty", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, err := Decode(test.encoded)
+			if err == nil {
+				t.Error("Decode gave no error")
+			}
+		})
+	}
+}
+
+func TestEncodeDecodeRanked(t *testing.T) {
+	createdAt := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	id := uuid.New()
+	for _, rank := range []float32{0, 0.0607927, 1, 12.5} {
+		gotRank, gotCreatedAt, gotID, err := DecodeRanked(EncodeRanked(rank, createdAt, id))
+		if err != nil {
+			t.Fatalf("DecodeRanked: %v", err)
+		}
+		if gotRank != rank || !gotCreatedAt.Equal(createdAt) || gotID != id {
+			t.Errorf("DecodeRanked = %v, %v, %v, want %v, %v, %v", gotRank, gotCreatedAt, gotID, rank, createdAt, id)
+		}
+	}
+}
+
+func TestDecodeRankedInvalid(t *testing.T) {
+	encode := func(raw string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(raw))
+	}
+	tests := []struct {
+		name    string
+		encoded string
+	}{
+		{"not base64", "***"},
+		{"no separator", encode("0.5")},
+		{"bad rank", encode("high|" + Encode(time.Now(), uuid.New()))},
+		{"bad inner cursor", encode("0.5|***")},
+		{"plain cursor", Encode(time.Now(), uuid.New())},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, _, err := DecodeRanked(test.encoded)
+			if err == nil {
+				t.Error("DecodeRanked gave no error")
+			}
+		})
+	}
+}
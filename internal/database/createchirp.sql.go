@@ -12,18 +12,29 @@ import (
 )
 
 const createChirp = `-- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
-VALUES (gen_random_uuid (), NOW(), NOW(), $1, $2)
-RETURNING id, created_at, updated_at, body, user_id
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, parent_id, root_id, rechirp_of, quote_of)
+VALUES (gen_random_uuid (), NOW(), NOW(), $1, $2, $3, $4, $5, $6)
+RETURNING id, created_at, updated_at, body, user_id, parent_id, root_id, deleted_at, reply_count, like_count, rechirp_of, quote_of, rechirp_count, quote_count, search_vector
 `
 
 type CreateChirpParams struct {
-	Body   string
-	UserID uuid.UUID
+	Body      string
+	UserID    uuid.UUID
+	ParentID  uuid.NullUUID
+	RootID    uuid.NullUUID
+	RechirpOf uuid.NullUUID
+	QuoteOf   uuid.NullUUID
 }
 
 func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp, error) {
-	row := q.db.QueryRowContext(ctx, createChirp, arg.Body, arg.UserID)
+	row := q.db.QueryRowContext(ctx, createChirp,
+		arg.Body,
+		arg.UserID,
+		arg.ParentID,
+		arg.RootID,
+		arg.RechirpOf,
+		arg.QuoteOf,
+	)
 	var i Chirp
 	err := row.Scan(
 		&i.ID,
@@ -31,6 +42,16 @@ func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.ParentID,
+		&i.RootID,
+		&i.DeletedAt,
+		&i.ReplyCount,
+		&i.LikeCount,
+		&i.RechirpOf,
+		&i.QuoteOf,
+		&i.RechirpCount,
+		&i.QuoteCount,
+		&i.SearchVector,
 	)
 	return i, err
 }
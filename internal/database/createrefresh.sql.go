@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 const createRefreshToken = `-- name: CreateRefreshToken :exec
@@ -19,14 +20,26 @@ INSERT INTO refresh_tokens (
     updated_at,
     expires_at,
     revoked_at,
-    user_id
+    user_id,
+    family_id,
+    user_agent,
+    ip_address,
+    last_used_at,
+    client_id,
+    scopes
 ) VALUES (
     $1,
     $2,
     $3,
     $4,
     NULL,
-    $5
+    $5,
+    $6,
+    $7,
+    $8,
+    NOW(),
+    $9,
+    $10
 )
 `
 
@@ -36,6 +49,11 @@ type CreateRefreshTokenParams struct {
 	UpdatedAt time.Time
 	ExpiresAt time.Time
 	UserID    uuid.UUID
+	FamilyID  uuid.UUID
+	UserAgent string
+	IpAddress string
+	ClientID  uuid.NullUUID
+	Scopes    []string
 }
 
 func (q *Queries) CreateRefreshToken(ctx context.Context, arg CreateRefreshTokenParams) error {
@@ -45,6 +63,11 @@ func (q *Queries) CreateRefreshToken(ctx context.Context, arg CreateRefreshToken
 		arg.UpdatedAt,
 		arg.ExpiresAt,
 		arg.UserID,
+		arg.FamilyID,
+		arg.UserAgent,
+		arg.IpAddress,
+		arg.ClientID,
+		pq.Array(arg.Scopes),
 	)
 	return err
 }
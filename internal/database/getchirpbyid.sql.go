@@ -12,7 +12,7 @@ import (
 )
 
 const getChirpByID = `-- name: GetChirpByID :one
-SELECT id, created_at, updated_at, body, user_id FROM chirps
+SELECT id, created_at, updated_at, body, user_id, parent_id, root_id, deleted_at, reply_count, like_count, rechirp_of, quote_of, rechirp_count, quote_count, search_vector FROM chirps
 WHERE id = $1
 `
 
@@ -25,6 +25,16 @@ func (q *Queries) GetChirpByID(ctx context.Context, id uuid.UUID) (Chirp, error)
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.ParentID,
+		&i.RootID,
+		&i.DeletedAt,
+		&i.ReplyCount,
+		&i.LikeCount,
+		&i.RechirpOf,
+		&i.QuoteOf,
+		&i.RechirpCount,
+		&i.QuoteCount,
+		&i.SearchVector,
 	)
 	return i, err
 }
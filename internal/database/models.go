@@ -6,33 +6,224 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+type AuditEvent struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	Event     string
+	ActorID   uuid.NullUUID
+	TargetID  uuid.NullUUID
+	IpAddress string
+	UserAgent string
+	Payload   json.RawMessage
+}
+
 type Chirp struct {
+	ID           uuid.UUID
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Body         string
+	UserID       uuid.NullUUID
+	ParentID     uuid.NullUUID
+	RootID       uuid.NullUUID
+	DeletedAt    sql.NullTime
+	ReplyCount   int32
+	LikeCount    int32
+	RechirpOf    uuid.NullUUID
+	QuoteOf      uuid.NullUUID
+	RechirpCount int32
+	QuoteCount   int32
+	SearchVector interface{}
+}
+
+type ChirpEntity struct {
+	ChirpID   uuid.UUID
+	Kind      string
+	StartByte int32
+	EndByte   int32
+	StartRune int32
+	EndRune   int32
+	Text      string
+	Tag       sql.NullString
+	UserID    uuid.NullUUID
+}
+
+type ChirpRevision struct {
+	ID         uuid.UUID
+	CreatedAt  time.Time
+	ReplacedAt time.Time
+	Body       string
+	ChirpID    uuid.UUID
+}
+
+type EmailVerificationToken struct {
+	TokenHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UserID    uuid.UUID
+	Email     string
+	UsedAt    sql.NullTime
+}
+
+type FailedLogin struct {
 	ID        uuid.UUID
 	CreatedAt time.Time
-	UpdatedAt time.Time
-	Body      string
+	Email     string
+	UserID    uuid.NullUUID
+	IpAddress string
+	UserAgent string
+	Reason    string
+}
+
+type Follow struct {
+	FollowerID uuid.UUID
+	FolloweeID uuid.UUID
+	CreatedAt  time.Time
+}
+
+type Like struct {
 	UserID    uuid.UUID
+	ChirpID   uuid.UUID
+	CreatedAt time.Time
 }
 
-type RefreshToken struct {
-	Token     string
+type LoginChallenge struct {
+	TokenHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UserID    uuid.UUID
+	Attempts  int32
+	UsedAt    sql.NullTime
+}
+
+type LoginThrottle struct {
+	Kind          string
+	Key           string
+	Failures      int32
+	LastFailureAt time.Time
+	LockedUntil   sql.NullTime
+}
+
+type MediaThumbnail struct {
+	MediaID    uuid.UUID
+	Name       string
+	StorageKey string
+	Width      int32
+	Height     int32
+}
+
+type Medium struct {
+	ID          uuid.UUID
+	CreatedAt   time.Time
+	UserID      uuid.UUID
+	ChirpID     uuid.NullUUID
+	Position    sql.NullInt32
+	StorageKey  string
+	ContentType string
+	SizeBytes   int64
+	Width       int32
+	Height      int32
+	AltText     string
+	Blurhash    string
+}
+
+type OauthAuthorizationCode struct {
+	CodeHash      string
+	CreatedAt     time.Time
+	ExpiresAt     time.Time
+	ClientID      uuid.UUID
+	UserID        uuid.UUID
+	RedirectUri   string
+	Scopes        []string
+	CodeChallenge string
+	UsedAt        sql.NullTime
+	FamilyID      uuid.NullUUID
+}
+
+type OauthClient struct {
+	ID           uuid.UUID
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	OwnerID      uuid.UUID
+	Name         string
+	RedirectUris []string
+	Scopes       []string
+	SecretHash   sql.NullString
+}
+
+type PasswordResetToken struct {
+	TokenHash string
 	CreatedAt time.Time
-	UpdatedAt time.Time
 	ExpiresAt time.Time
-	RevokedAt sql.NullTime
 	UserID    uuid.UUID
+	UsedAt    sql.NullTime
+}
+
+type PersonalAccessToken struct {
+	ID         uuid.UUID
+	CreatedAt  time.Time
+	UserID     uuid.UUID
+	Name       string
+	TokenHash  string
+	Scopes     []string
+	ExpiresAt  sql.NullTime
+	LastUsedAt sql.NullTime
+	RevokedAt  sql.NullTime
+}
+
+type RecoveryCode struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	CodeHash  string
+	UsedAt    sql.NullTime
+}
+
+type RefreshToken struct {
+	Token      string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	ExpiresAt  time.Time
+	RevokedAt  sql.NullTime
+	UserID     uuid.UUID
+	FamilyID   uuid.UUID
+	UsedAt     sql.NullTime
+	ReplacedBy sql.NullString
+	UserAgent  string
+	IpAddress  string
+	LastUsedAt time.Time
+	ClientID   uuid.NullUUID
+	Scopes     []string
+}
+
+type TotpCredential struct {
+	UserID          uuid.UUID
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	SecretEncrypted string
+	ConfirmedAt     sql.NullTime
+	LastStep        int64
 }
 
 type User struct {
-	ID             uuid.UUID
-	CreatedAt      time.Time
-	UpdatedAt      time.Time
-	Email          string
-	HashedPassword string
-	IsChirpyRed    bool
+	ID               uuid.UUID
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+	Email            string
+	HashedPassword   string
+	IsChirpyRed      bool
+	Handle           string
+	DisplayName      string
+	Bio              string
+	AvatarMediaID    uuid.NullUUID
+	EmailVerifiedAt  sql.NullTime
+	PendingEmail     sql.NullString
+	Role             string
+	SuspendedAt      sql.NullTime
+	SuspensionReason sql.NullString
 }
@@ -13,14 +13,15 @@ import (
 )
 
 const createUser = `-- name: CreateUser :one
-INSERT INTO users (id, created_at, updated_at, hashed_password, email)
-VALUES (gen_random_uuid (), NOW(), NOW(), $1, $2)
-RETURNING id, created_at, updated_at, email, is_chirpy_red
+INSERT INTO users (id, created_at, updated_at, hashed_password, email, handle)
+VALUES (gen_random_uuid (), NOW(), NOW(), $1, $2, $3)
+RETURNING id, created_at, updated_at, email, is_chirpy_red, handle, display_name, bio
 `
 
 type CreateUserParams struct {
 	HashedPassword string
 	Email          string
+	Handle         string
 }
 
 type CreateUserRow struct {
@@ -29,10 +30,13 @@ type CreateUserRow struct {
 	UpdatedAt   time.Time
 	Email       string
 	IsChirpyRed bool
+	Handle      string
+	DisplayName string
+	Bio         string
 }
 
 func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (CreateUserRow, error) {
-	row := q.db.QueryRowContext(ctx, createUser, arg.HashedPassword, arg.Email)
+	row := q.db.QueryRowContext(ctx, createUser, arg.HashedPassword, arg.Email, arg.Handle)
 	var i CreateUserRow
 	err := row.Scan(
 		&i.ID,
@@ -40,6 +44,26 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (CreateU
 		&i.UpdatedAt,
 		&i.Email,
 		&i.IsChirpyRed,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
 	)
 	return i, err
 }
+
+const rehashUserPassword = `-- name: RehashUserPassword :exec
+UPDATE users
+SET hashed_password = $1
+WHERE id = $2 AND hashed_password = $3
+`
+
+type RehashUserPasswordParams struct {
+	NewHashedPassword string
+	ID                uuid.UUID
+	OldHashedPassword string
+}
+
+func (q *Queries) RehashUserPassword(ctx context.Context, arg RehashUserPasswordParams) error {
+	_, err := q.db.ExecContext(ctx, rehashUserPassword, arg.NewHashedPassword, arg.ID, arg.OldHashedPassword)
+	return err
+}
@@ -0,0 +1,118 @@
+package entities
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The kinds of entity we pick out of a chirp body.
+const (
+	KindHashtag = "hashtag"
+	KindMention = "mention"
+	KindURL     = "url"
+)
+
+// An entity is a slice of the body, with its offsets given both in bytes and in runes, so clients in any language can find it.
+type Entity struct {
+	Kind      string
+	Text      string
+	StartByte int
+	EndByte   int
+	StartRune int
+	EndRune   int
+}
+
+var (
+	urlPattern     = regexp.MustCompile(`https?://[^\s<>"]+`)
+	hashtagPattern = regexp.MustCompile(`#[\p{L}\p{M}\p{N}_]+`)
+	mentionPattern = regexp.MustCompile(`@[A-Za-z0-9_]+`)
+	handlePattern  = regexp.MustCompile(`^[A-Za-z0-9_]{3,30}$`)
+)
+
+// Punctuation that ends a sentence rather than the link it follows.
+const urlTrailingPunctuation = ".,;:!?'\")]}"
+
+// Parse finds every URL, hashtag and mention in the body, in the order they appear.
+func Parse(body string) []Entity {
+	found := make([]Entity, 0)
+
+	//URLs go first, hashtags and mentions inside a link are part of the link.
+	for _, match := range urlPattern.FindAllStringIndex(body, -1) {
+		start, end := match[0], match[1]
+		for end > start && strings.ContainsRune(urlTrailingPunctuation, rune(body[end-1])) {
+			end--
+		}
+		found = append(found, newEntity(body, KindURL, start, end))
+	}
+
+	for _, match := range hashtagPattern.FindAllStringIndex(body, -1) {
+		start, end := match[0], match[1]
+		//A tag of only digits is more likely an issue number than a topic.
+		if !startsWord(body, start) || overlaps(found, start, end) || strings.IndexFunc(body[start+1:end], unicode.IsLetter) < 0 {
+			continue
+		}
+		found = append(found, newEntity(body, KindHashtag, start, end))
+	}
+
+	for _, match := range mentionPattern.FindAllStringIndex(body, -1) {
+		start, end := match[0], match[1]
+		//An @ straight after the handle makes it part of an email address, not a mention.
+		if !startsWord(body, start) || overlaps(found, start, end) || strings.HasPrefix(body[end:], "@") || !ValidHandle(body[start+1:end]) {
+			continue
+		}
+		found = append(found, newEntity(body, KindMention, start, end))
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		return found[i].StartByte < found[j].StartByte
+	})
+	return found
+}
+
+// Tag is the form a hashtag is stored and looked up by, without the # and in lower case.
+func Tag(hashtag string) string {
+	return strings.ToLower(strings.TrimPrefix(hashtag, "#"))
+}
+
+// Handle is the form a mention is looked up by, without the @ and in lower case, as handles ignore case.
+func Handle(mention string) string {
+	return strings.ToLower(strings.TrimPrefix(mention, "@"))
+}
+
+// ValidHandle reports whether a handle is 3 to 30 letters, digits or underscores, the only handles a mention can reach.
+func ValidHandle(handle string) bool {
+	return handlePattern.MatchString(handle)
+}
+
+func newEntity(body string, kind string, start int, end int) Entity {
+	startRune := utf8.RuneCountInString(body[:start])
+	return Entity{
+		Kind:      kind,
+		Text:      body[start:end],
+		StartByte: start,
+		EndByte:   end,
+		StartRune: startRune,
+		EndRune:   startRune + utf8.RuneCountInString(body[start:end]),
+	}
+}
+
+// A # or @ only counts at the start of a word, so "a#b" and "me@host" are left alone.
+func startsWord(body string, start int) bool {
+	if start == 0 {
+		return true
+	}
+	previous, _ := utf8.DecodeLastRuneInString(body[:start])
+	return !(unicode.IsLetter(previous) || unicode.IsDigit(previous) || previous == '_' || previous == '#' || previous == '@')
+}
+
+func overlaps(found []Entity, start int, end int) bool {
+	for _, entity := range found {
+		if start < entity.EndByte && entity.StartByte < end {
+			return true
+		}
+	}
+	return false
+}
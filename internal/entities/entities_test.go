@@ -0,0 +1,83 @@
+package entities
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []Entity
+	}{
+		{"nothing", "just words", []Entity{}},
+		{"hashtag", "I love #golang", []Entity{
+			{Kind: KindHashtag, Text: "#golang", StartByte: 7, EndByte: 14, StartRune: 7, EndRune: 14},
+		}},
+		{"mention", "hi @bob_99!", []Entity{
+			{Kind: KindMention, Text: "@bob_99", StartByte: 3, EndByte: 10, StartRune: 3, EndRune: 10},
+		}},
+		{"url without trailing punctuation", "see https://example.com/a?b=c.", []Entity{
+			{Kind: KindURL, Text: "https://example.com/a?b=c", StartByte: 4, EndByte: 29, StartRune: 4, EndRune: 29},
+		}},
+		{"url in parentheses", "(http://example.com)", []Entity{
+			{Kind: KindURL, Text: "http://example.com", StartByte: 1, EndByte: 19, StartRune: 1, EndRune: 19},
+		}},
+		{"in order of appearance", "@bob #go https://go.dev", []Entity{
+			{Kind: KindMention, Text: "@bob", StartByte: 0, EndByte: 4, StartRune: 0, EndRune: 4},
+			{Kind: KindHashtag, Text: "#go", StartByte: 5, EndByte: 8, StartRune: 5, EndRune: 8},
+			{Kind: KindURL, Text: "https://go.dev", StartByte: 9, EndByte: 23, StartRune: 9, EndRune: 23},
+		}},
+		//é is two bytes and one rune, so the offsets after it differ.
+		{"byte and rune offsets", "café #thé", []Entity{
+			{Kind: KindHashtag, Text: "#thé", StartByte: 6, EndByte: 11, StartRune: 5, EndRune: 9},
+		}},
+		{"tags and mentions inside a link", "https://example.com/#top/@bob", []Entity{
+			{Kind: KindURL, Text: "https://example.com/#top/@bob", StartByte: 0, EndByte: 29, StartRune: 0, EndRune: 29},
+		}},
+		{"only digits is not a tag", "fixes #123", []Entity{}},
+		{"not at the start of a word", "a#b c@bob", []Entity{}},
+		{"email address is not a mention", "mail @bob@example.com", []Entity{}},
+		{"handle too short", "hi @bo", []Entity{}},
+		{"handle too long", "hi @abcdefghijklmnopqrstuvwxyz12345", []Entity{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := Parse(test.body)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Parse(%q) = %+v, want %+v", test.body, got, test.want)
+			}
+		})
+	}
+}
+
+func TestTagAndHandle(t *testing.T) {
+	if got := Tag("#GoLang"); got != "golang" {
+		t.Errorf("Tag = %q, want golang", got)
+	}
+	if got := Handle("@Bob_99"); got != "bob_99" {
+		t.Errorf("Handle = %q, want bob_99", got)
+	}
+}
+
+func TestValidHandle(t *testing.T) {
+	tests := []struct {
+		handle string
+		want   bool
+	}{
+		{"bob", true},
+		{"Bob_99", true},
+		{"abcdefghijklmnopqrstuvwxyz1234", true},
+		{"bo", false},
+		{"abcdefghijklmnopqrstuvwxyz12345", false},
+		{"bob.smith", false},
+		{"bob-smith", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		if got := ValidHandle(test.handle); got != test.want {
+			t.Errorf("ValidHandle(%q) = %v, want %v", test.handle, got, test.want)
+		}
+	}
+}
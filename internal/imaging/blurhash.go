@@ -0,0 +1,101 @@
+package imaging
+
+import (
+	"image"
+	"math"
+	"strings"
+)
+
+// A blurhash is a short string clients can turn into a blurred placeholder while the real image loads.
+// This follows the reference encoder at https://github.com/woltapp/blurhash.
+const blurhashCharacters = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// How many cosine components across and down, enough detail for a placeholder.
+const blurhashXComponents = 4
+const blurhashYComponents = 3
+
+// The hash only needs the rough colours, so it is worked out from a small copy of the image.
+const blurhashSampleSize = 64
+
+func blurhash(source *image.NRGBA) string {
+	sample := resize(source, blurhashSampleSize)
+	width := sample.Bounds().Dx()
+	height := sample.Bounds().Dy()
+
+	//Each component is how much of one cosine pattern the image holds, per colour channel.
+	factors := make([][3]float64, 0, blurhashXComponents*blurhashYComponents)
+	for j := 0; j < blurhashYComponents; j++ {
+		for i := 0; i < blurhashXComponents; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1.0
+			}
+			var factor [3]float64
+			for y := 0; y < height; y++ {
+				for x := 0; x < width; x++ {
+					basis := normalisation * math.Cos(math.Pi*float64(i)*float64(x)/float64(width)) * math.Cos(math.Pi*float64(j)*float64(y)/float64(height))
+					offset := sample.PixOffset(x, y)
+					factor[0] += basis * srgbToLinear(sample.Pix[offset])
+					factor[1] += basis * srgbToLinear(sample.Pix[offset+1])
+					factor[2] += basis * srgbToLinear(sample.Pix[offset+2])
+				}
+			}
+			scale := 1.0 / float64(width*height)
+			factors = append(factors, [3]float64{factor[0] * scale, factor[1] * scale, factor[2] * scale})
+		}
+	}
+
+	var hash strings.Builder
+	hash.WriteString(encode83((blurhashXComponents-1)+(blurhashYComponents-1)*9, 1))
+
+	//The other components are stored relative to the largest of them.
+	actualMaximum := 0.0
+	for _, factor := range factors[1:] {
+		for _, channel := range factor {
+			actualMaximum = math.Max(actualMaximum, math.Abs(channel))
+		}
+	}
+	quantisedMaximum := int(math.Max(0, math.Min(82, math.Floor(actualMaximum*166-0.5))))
+	maximumValue := float64(quantisedMaximum+1) / 166
+	hash.WriteString(encode83(quantisedMaximum, 1))
+
+	dc := factors[0]
+	hash.WriteString(encode83(linearToSrgb(dc[0])<<16+linearToSrgb(dc[1])<<8+linearToSrgb(dc[2]), 4))
+	for _, factor := range factors[1:] {
+		quantised := [3]int{}
+		for channel := 0; channel < 3; channel++ {
+			quantised[channel] = int(math.Max(0, math.Min(18, math.Floor(signPow(factor[channel]/maximumValue, 0.5)*9+9.5))))
+		}
+		hash.WriteString(encode83(quantised[0]*19*19+quantised[1]*19+quantised[2], 2))
+	}
+	return hash.String()
+}
+
+func encode83(value int, length int) string {
+	encoded := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		encoded[i] = blurhashCharacters[value%83]
+		value /= 83
+	}
+	return string(encoded)
+}
+
+func srgbToLinear(value uint8) float64 {
+	v := float64(value) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSrgb(value float64) int {
+	v := math.Max(0, math.Min(1, value))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(value float64, exponent float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(value), exponent), value)
+}
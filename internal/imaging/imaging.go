@@ -0,0 +1,186 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+)
+
+// Anything bigger would take too much memory to decode, whatever its file size.
+// At the limit the decoded pixels alone take about 80 MB.
+const maxPixels = 20_000_000
+
+// How many images are decoded and resized at once, the rest wait their turn so parallel uploads
+// cannot add up to more memory than the server has.
+const maxConcurrentProcessing = 2
+
+var processingSlots = make(chan struct{}, maxConcurrentProcessing)
+
+// Quality used when writing JPEGs back out.
+const jpegQuality = 88
+
+// The thumbnails made for every image, each fits within a square of the given size.
+type ThumbnailSize struct {
+	Name string
+	Size int
+}
+
+var ThumbnailSizes = []ThumbnailSize{
+	{Name: "small", Size: 150},
+	{Name: "medium", Size: 600},
+	{Name: "large", Size: 1200},
+}
+
+// An encoded image ready to store.
+type Encoded struct {
+	Data        []byte
+	ContentType string
+	Width       int
+	Height      int
+}
+
+type Thumbnail struct {
+	Name string
+	Encoded
+}
+
+// The result of processing one upload.
+type Processed struct {
+	Original   Encoded
+	Thumbnails []Thumbnail
+	Blurhash   string
+}
+
+// Process decodes an uploaded JPEG, PNG or GIF, turns it upright and re-encodes it without its metadata,
+// then makes the thumbnails and blurhash for it.
+// GIFs are kept as uploaded so animations survive, their thumbnails come from the first frame.
+func Process(data []byte, contentType string) (Processed, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return Processed{}, err
+	}
+	if config.Width*config.Height > maxPixels {
+		return Processed{}, errors.New("image has too many pixels")
+	}
+
+	processingSlots <- struct{}{}
+	defer func() { <-processingSlots }()
+
+	var decoded image.Image
+	switch contentType {
+	case "image/jpeg":
+		decoded, err = jpeg.Decode(bytes.NewReader(data))
+	case "image/png":
+		decoded, err = png.Decode(bytes.NewReader(data))
+	case "image/gif":
+		decoded, err = gif.Decode(bytes.NewReader(data))
+	default:
+		return Processed{}, errors.New("unsupported image type")
+	}
+	if err != nil {
+		return Processed{}, err
+	}
+
+	//Work on plain NRGBA pixels whatever the source format was.
+	pixels := image.NewNRGBA(image.Rect(0, 0, decoded.Bounds().Dx(), decoded.Bounds().Dy()))
+	draw.Draw(pixels, pixels.Bounds(), decoded, decoded.Bounds().Min, draw.Src)
+	if contentType == "image/jpeg" {
+		pixels = applyOrientation(pixels, jpegOrientation(data))
+	}
+
+	processed := Processed{}
+	if contentType == "image/gif" {
+		processed.Original = Encoded{Data: data, ContentType: contentType, Width: pixels.Bounds().Dx(), Height: pixels.Bounds().Dy()}
+	} else {
+		//Encoding from the pixels is what strips the EXIF, location and any other metadata.
+		processed.Original, err = encode(pixels, contentType)
+		if err != nil {
+			return Processed{}, err
+		}
+	}
+
+	//Thumbnails of a GIF are PNGs, a single frame is all a thumbnail needs.
+	thumbnailType := contentType
+	if contentType == "image/gif" {
+		thumbnailType = "image/png"
+	}
+	for _, size := range ThumbnailSizes {
+		thumbnail, err := encode(resize(pixels, size.Size), thumbnailType)
+		if err != nil {
+			return Processed{}, err
+		}
+		processed.Thumbnails = append(processed.Thumbnails, Thumbnail{Name: size.Name, Encoded: thumbnail})
+	}
+
+	processed.Blurhash = blurhash(pixels)
+	return processed, nil
+}
+
+func encode(pixels *image.NRGBA, contentType string) (Encoded, error) {
+	var buffer bytes.Buffer
+	var err error
+	if contentType == "image/jpeg" {
+		err = jpeg.Encode(&buffer, pixels, &jpeg.Options{Quality: jpegQuality})
+	} else {
+		err = png.Encode(&buffer, pixels)
+	}
+	if err != nil {
+		return Encoded{}, err
+	}
+	return Encoded{Data: buffer.Bytes(), ContentType: contentType, Width: pixels.Bounds().Dx(), Height: pixels.Bounds().Dy()}, nil
+}
+
+// Shrinks the image to fit within a square of the given size, keeping its shape. Images already small enough are left as they are.
+// Each new pixel is the average of the source pixels it covers, which keeps detail from aliasing when shrinking a lot.
+func resize(source *image.NRGBA, size int) *image.NRGBA {
+	width := source.Bounds().Dx()
+	height := source.Bounds().Dy()
+	if width <= size && height <= size {
+		return source
+	}
+
+	outWidth, outHeight := size, height*size/width
+	if height > width {
+		outWidth, outHeight = width*size/height, size
+	}
+	outWidth = max(outWidth, 1)
+	outHeight = max(outHeight, 1)
+	out := image.NewNRGBA(image.Rect(0, 0, outWidth, outHeight))
+
+	for outY := 0; outY < outHeight; outY++ {
+		startY := outY * height / outHeight
+		endY := max((outY+1)*height/outHeight, startY+1)
+		for outX := 0; outX < outWidth; outX++ {
+			startX := outX * width / outWidth
+			endX := max((outX+1)*width/outWidth, startX+1)
+
+			//Colours are weighted by alpha, so transparent pixels do not darken the edges.
+			var red, green, blue, alpha, count uint64
+			for y := startY; y < endY; y++ {
+				offset := source.PixOffset(startX, y)
+				for x := startX; x < endX; x++ {
+					pixelAlpha := uint64(source.Pix[offset+3])
+					red += uint64(source.Pix[offset]) * pixelAlpha
+					green += uint64(source.Pix[offset+1]) * pixelAlpha
+					blue += uint64(source.Pix[offset+2]) * pixelAlpha
+					alpha += pixelAlpha
+					count++
+					offset += 4
+				}
+			}
+
+			outOffset := out.PixOffset(outX, outY)
+			if alpha > 0 {
+				out.Pix[outOffset] = uint8(red / alpha)
+				out.Pix[outOffset+1] = uint8(green / alpha)
+				out.Pix[outOffset+2] = uint8(blue / alpha)
+			}
+			out.Pix[outOffset+3] = uint8(alpha / count)
+		}
+	}
+	return out
+}
@@ -0,0 +1,254 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// A width by height image, red on the left half and blue on the right, so turns can be told apart.
+func testImage(width int, height int) *image.NRGBA {
+	pixels := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if x < width/2 {
+				pixels.Set(x, y, color.NRGBA{R: 255, A: 255})
+			} else {
+				pixels.Set(x, y, color.NRGBA{B: 255, A: 255})
+			}
+		}
+	}
+	return pixels
+}
+
+func encodeTestImage(t *testing.T, pixels *image.NRGBA, contentType string) []byte {
+	t.Helper()
+	var buffer bytes.Buffer
+	var err error
+	switch contentType {
+	case "image/jpeg":
+		err = jpeg.Encode(&buffer, pixels, nil)
+	case "image/png":
+		err = png.Encode(&buffer, pixels)
+	case "image/gif":
+		err = gif.Encode(&buffer, pixels, nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+// Puts an APP1 Exif segment holding the orientation straight after the JPEG's start of image marker.
+func withOrientation(data []byte, orientation uint16, order binary.ByteOrder) []byte {
+	tiff := make([]byte, 8+2+12)
+	if order == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8)
+	order.PutUint16(tiff[8:], 1)
+	order.PutUint16(tiff[10:], exifOrientationTag)
+	order.PutUint16(tiff[12:], 3)
+	order.PutUint32(tiff[14:], 1)
+	order.PutUint16(tiff[18:], orientation)
+
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	header := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(header[2:], uint16(len(segment)+2))
+	out := append([]byte{}, data[:2]...)
+	out = append(out, header...)
+	out = append(out, segment...)
+	return append(out, data[2:]...)
+}
+
+func TestProcess(t *testing.T) {
+	tests := []struct {
+		contentType        string
+		wantThumbnailType  string
+		wantKeptAsUploaded bool
+	}{
+		{"image/jpeg", "image/jpeg", false},
+		{"image/png", "image/png", false},
+		{"image/gif", "image/png", true},
+	}
+	for _, test := range tests {
+		t.Run(test.contentType, func(t *testing.T) {
+			data := encodeTestImage(t, testImage(1600, 800), test.contentType)
+			processed, err := Process(data, test.contentType)
+			if err != nil {
+				t.Fatalf("Process: %v", err)
+			}
+			original := processed.Original
+			if original.Width != 1600 || original.Height != 800 || original.ContentType != test.contentType {
+				t.Errorf("original is %dx%d %s", original.Width, original.Height, original.ContentType)
+			}
+			if test.wantKeptAsUploaded && !bytes.Equal(original.Data, data) {
+				t.Error("original was not kept as uploaded")
+			}
+
+			if len(processed.Thumbnails) != len(ThumbnailSizes) {
+				t.Fatalf("got %d thumbnails, want %d", len(processed.Thumbnails), len(ThumbnailSizes))
+			}
+			for i, thumbnail := range processed.Thumbnails {
+				size := ThumbnailSizes[i]
+				//Wide images fit the size across and keep their shape.
+				wantWidth, wantHeight := min(size.Size, 1600), min(size.Size, 1600)/2
+				if thumbnail.Name != size.Name || thumbnail.Width != wantWidth || thumbnail.Height != wantHeight {
+					t.Errorf("thumbnail %s is %dx%d, want %s %dx%d", thumbnail.Name, thumbnail.Width, thumbnail.Height, size.Name, wantWidth, wantHeight)
+				}
+				if thumbnail.ContentType != test.wantThumbnailType {
+					t.Errorf("thumbnail %s is %s, want %s", thumbnail.Name, thumbnail.ContentType, test.wantThumbnailType)
+				}
+			}
+			if len(processed.Blurhash) != 28 {
+				t.Errorf("blurhash %q is not 28 characters", processed.Blurhash)
+			}
+		})
+	}
+}
+
+func TestProcessStripsMetadataAndTurnsUpright(t *testing.T) {
+	//Orientation 6 means the camera was turned, the stored 40x20 image displays as 20x40.
+	data := withOrientation(encodeTestImage(t, testImage(40, 20), "image/jpeg"), 6, binary.BigEndian)
+	processed, err := Process(data, "image/jpeg")
+	if err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+	if processed.Original.Width != 20 || processed.Original.Height != 40 {
+		t.Errorf("original is %dx%d, want 20x40", processed.Original.Width, processed.Original.Height)
+	}
+	if bytes.Contains(processed.Original.Data, []byte("Exif")) {
+		t.Error("original still carries its EXIF data")
+	}
+}
+
+func TestProcessRejects(t *testing.T) {
+	//A PNG whose header claims more pixels than are allowed, it is turned away before decoding.
+	huge := encodeTestImage(t, testImage(2, 2), "image/png")
+	binary.BigEndian.PutUint32(huge[16:], maxPixels)
+	binary.BigEndian.PutUint32(huge[20:], 2)
+	binary.BigEndian.PutUint32(huge[29:], crc32.ChecksumIEEE(huge[12:29]))
+
+	tests := []struct {
+		name        string
+		data        []byte
+		contentType string
+	}{
+		{"too many pixels", huge, "image/png"},
+		{"not an image", []byte("hello"), "image/png"},
+		{"unsupported type", encodeTestImage(t, testImage(2, 2), "image/png"), "image/webp"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Process(test.data, test.contentType)
+			if err == nil {
+				t.Error("Process gave no error")
+			}
+		})
+	}
+}
+
+func TestJPEGOrientation(t *testing.T) {
+	plain := encodeTestImage(t, testImage(4, 2), "image/jpeg")
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"no exif", plain, 1},
+		{"big endian", withOrientation(plain, 6, binary.BigEndian), 6},
+		{"little endian", withOrientation(plain, 8, binary.LittleEndian), 8},
+		{"out of range", withOrientation(plain, 9, binary.BigEndian), 1},
+		{"truncated", withOrientation(plain, 6, binary.BigEndian)[:20], 1},
+		{"not a jpeg", []byte("hello world"), 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := jpegOrientation(test.data); got != test.want {
+				t.Errorf("jpegOrientation = %d, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestApplyOrientation(t *testing.T) {
+	//Which corner the top left red pixel ends up in, for each orientation.
+	source := testImage(4, 2)
+	tests := []struct {
+		orientation int
+		wantWidth   int
+		wantRedAt   image.Point
+	}{
+		{1, 4, image.Pt(0, 0)},
+		{2, 4, image.Pt(3, 0)},
+		{3, 4, image.Pt(3, 1)},
+		{4, 4, image.Pt(0, 1)},
+		{5, 2, image.Pt(0, 0)},
+		{6, 2, image.Pt(1, 0)},
+		{7, 2, image.Pt(1, 3)},
+		{8, 2, image.Pt(0, 3)},
+	}
+	for _, test := range tests {
+		out := applyOrientation(source, test.orientation)
+		if out.Bounds().Dx() != test.wantWidth {
+			t.Errorf("orientation %d: width %d, want %d", test.orientation, out.Bounds().Dx(), test.wantWidth)
+		}
+		if got := out.NRGBAAt(test.wantRedAt.X, test.wantRedAt.Y); got.R != 255 {
+			t.Errorf("orientation %d: pixel at %v is %v, want red", test.orientation, test.wantRedAt, got)
+		}
+	}
+}
+
+func TestResize(t *testing.T) {
+	tests := []struct {
+		name       string
+		width      int
+		height     int
+		size       int
+		wantWidth  int
+		wantHeight int
+	}{
+		{"small enough", 100, 50, 150, 100, 50},
+		{"wide", 1000, 500, 150, 150, 75},
+		{"tall", 500, 1000, 150, 75, 150},
+		{"very thin", 10000, 1, 150, 150, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out := resize(testImage(test.width, test.height), test.size)
+			if out.Bounds().Dx() != test.wantWidth || out.Bounds().Dy() != test.wantHeight {
+				t.Errorf("resized to %dx%d, want %dx%d", out.Bounds().Dx(), out.Bounds().Dy(), test.wantWidth, test.wantHeight)
+			}
+		})
+	}
+}
+
+func TestBlurhash(t *testing.T) {
+	solid := image.NewNRGBA(image.Rect(0, 0, 32, 32))
+	for i := 0; i < len(solid.Pix); i += 4 {
+		copy(solid.Pix[i:], []byte{255, 0, 0, 255})
+	}
+	hash := blurhash(solid)
+	//The 4x3 size, the maximum, the average colour and then two characters for each of the other 11 components.
+	if len(hash) != 28 || hash[0] != 'L' {
+		t.Fatalf("blurhash %q is not a 4x3 blurhash", hash)
+	}
+	if hash[2:6] != encode83(255<<16, 4) {
+		t.Errorf("average colour %q, want red", hash[2:6])
+	}
+	if blurhash(solid) != hash {
+		t.Error("blurhash is not stable")
+	}
+	if blurhash(testImage(32, 32)) == hash {
+		t.Error("two different images gave the same blurhash")
+	}
+}
@@ -0,0 +1,115 @@
+package imaging
+
+import (
+	"encoding/binary"
+	"image"
+)
+
+// Phones store photos the way the sensor saw them and record how to turn them in the EXIF orientation tag.
+// Re-encoding drops that tag, so we read it first and turn the pixels ourselves.
+const exifOrientationTag = 0x0112
+
+// Finds the EXIF orientation in a JPEG, 1 (as stored) when there is none or it cannot be read.
+func jpegOrientation(data []byte) int {
+	//Walk the JPEG segments until the start of the image data, looking for the APP1 Exif segment.
+	position := 2
+	for position+4 <= len(data) {
+		if data[position] != 0xFF {
+			return 1
+		}
+		marker := data[position+1]
+		//Start of scan, nothing after this is metadata.
+		if marker == 0xDA {
+			return 1
+		}
+		length := int(binary.BigEndian.Uint16(data[position+2:]))
+		segmentEnd := position + 2 + length
+		if length < 2 || segmentEnd > len(data) {
+			return 1
+		}
+		segment := data[position+4 : segmentEnd]
+		if marker == 0xE1 && len(segment) > 6 && string(segment[:6]) == "Exif\x00\x00" {
+			return tiffOrientation(segment[6:])
+		}
+		position = segmentEnd
+	}
+	return 1
+}
+
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	//The first directory holds the orientation, each entry is 12 bytes after a 2 byte count.
+	directory := int(order.Uint32(tiff[4:]))
+	if directory+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[directory:]))
+	for i := 0; i < entries; i++ {
+		entry := directory + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == exifOrientationTag {
+			orientation := int(order.Uint16(tiff[entry+8:]))
+			if orientation < 1 || orientation > 8 {
+				return 1
+			}
+			return orientation
+		}
+	}
+	return 1
+}
+
+// Turns the image so it displays upright, following the meaning of each EXIF orientation value.
+func applyOrientation(source *image.NRGBA, orientation int) *image.NRGBA {
+	if orientation <= 1 || orientation > 8 {
+		return source
+	}
+	width := source.Bounds().Dx()
+	height := source.Bounds().Dy()
+
+	//Orientations 5 to 8 swap the sides.
+	outWidth, outHeight := width, height
+	if orientation >= 5 {
+		outWidth, outHeight = height, width
+	}
+	out := image.NewNRGBA(image.Rect(0, 0, outWidth, outHeight))
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var outX, outY int
+			switch orientation {
+			case 2:
+				outX, outY = width-1-x, y
+			case 3:
+				outX, outY = width-1-x, height-1-y
+			case 4:
+				outX, outY = x, height-1-y
+			case 5:
+				outX, outY = y, x
+			case 6:
+				outX, outY = height-1-y, x
+			case 7:
+				outX, outY = height-1-y, width-1-x
+			case 8:
+				outX, outY = y, width-1-x
+			}
+			sourceOffset := source.PixOffset(x, y)
+			outOffset := out.PixOffset(outX, outY)
+			copy(out.Pix[outOffset:outOffset+4], source.Pix[sourceOffset:sourceOffset+4])
+		}
+	}
+	return out
+}
@@ -0,0 +1,117 @@
+package mailer
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// A plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// A Mailer sends email. Which one is used is set up once at start, handlers only ever see this.
+type Mailer interface {
+	Send(message Message) error
+}
+
+// Writes the message out in the form it goes over the wire.
+func format(from string, message Message) []byte {
+	var builder strings.Builder
+	builder.WriteString("From: " + from + "\r\n")
+	builder.WriteString("To: " + message.To + "\r\n")
+	builder.WriteString("Subject: " + mime.QEncoding.Encode("UTF-8", message.Subject) + "\r\n")
+	builder.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	builder.WriteString("MIME-Version: 1.0\r\n")
+	builder.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	builder.WriteString("\r\n")
+	builder.WriteString(strings.ReplaceAll(message.Body, "\n", "\r\n"))
+	return []byte(builder.String())
+}
+
+// Headers are built from strings we are given, a line break in one would let it add headers of its own.
+func checkHeaders(message Message) error {
+	if strings.ContainsAny(message.To+message.Subject, "\r\n") {
+		return fmt.Errorf("line break in email header")
+	}
+	return nil
+}
+
+// SMTPMailer hands messages to an SMTP server, logging in when a username is set.
+// Pointing it at a local sink like MailHog or smtp4dev is the easiest way to see the emails while developing.
+type SMTPMailer struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+func (mailer SMTPMailer) Send(message Message) error {
+	err := checkHeaders(message)
+	if err != nil {
+		return err
+	}
+	var smtpAuth smtp.Auth
+	if mailer.Username != "" {
+		host, _, err := net.SplitHostPort(mailer.Addr)
+		if err != nil {
+			return err
+		}
+		smtpAuth = smtp.PlainAuth("", mailer.Username, mailer.Password, host)
+	}
+	return smtp.SendMail(mailer.Addr, smtpAuth, mailer.From, []string{message.To}, format(mailer.From, message))
+}
+
+// FileMailer writes each message to its own .eml file in Dir instead of sending it.
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+func NewFileMailer(dir string, from string) (*FileMailer, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	return &FileMailer{Dir: dir, From: from}, nil
+}
+
+func (mailer *FileMailer) Send(message Message) error {
+	err := checkHeaders(message)
+	if err != nil {
+		return err
+	}
+	fileName := time.Now().UTC().Format("20060102T150405") + "_" + uuid.New().String() + ".eml"
+	return os.WriteFile(filepath.Join(mailer.Dir, fileName), format(mailer.From, message), 0o600)
+}
+
+// LogMailer prints messages instead of sending them, handy when there is no mail server at all.
+// Bodies carry links that log in as the user, so they are only printed when ShowBody is set, for development.
+type LogMailer struct {
+	Out      io.Writer
+	From     string
+	ShowBody bool
+}
+
+func (mailer LogMailer) Send(message Message) error {
+	err := checkHeaders(message)
+	if err != nil {
+		return err
+	}
+	if !mailer.ShowBody {
+		message.Body = "(body not shown, set MAIL_DRIVER to smtp or file to send email)\n"
+	}
+	_, err = fmt.Fprintf(mailer.Out, "----- email -----\n%s\n-----------------\n", format(mailer.From, message))
+	return err
+}
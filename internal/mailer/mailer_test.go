@@ -0,0 +1,128 @@
+package mailer
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	formatted := string(format("chirpy@example.com", Message{
+		To:      "bob@example.com",
+		Subject: "Résumé of your account",
+		Body:    "Line one\nLine two\n",
+	}))
+
+	header, body, found := strings.Cut(formatted, "\r\n\r\n")
+	if !found {
+		t.Fatalf("no blank line between header and body in %q", formatted)
+	}
+	for _, line := range []string{
+		"From: chirpy@example.com",
+		"To: bob@example.com",
+		"Subject: =?UTF-8?q?R=C3=A9sum=C3=A9_of_your_account?=",
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+	} {
+		if !strings.Contains(header+"\r\n", line+"\r\n") {
+			t.Errorf("header is missing %q:\n%s", line, header)
+		}
+	}
+	if body != "Line one\r\nLine two\r\n" {
+		t.Errorf("body = %q, want CRLF line endings", body)
+	}
+}
+
+func TestSendRejectsHeaderInjection(t *testing.T) {
+	dir := t.TempDir()
+	fileMailer, err := NewFileMailer(dir, "chirpy@example.com")
+	if err != nil {
+		t.Fatalf("NewFileMailer: %v", err)
+	}
+	var out bytes.Buffer
+	mailers := map[string]Mailer{
+		"file": fileMailer,
+		"log":  LogMailer{Out: &out, From: "chirpy@example.com"},
+		//Never reaches the network, the headers are checked first.
+		"smtp": SMTPMailer{Addr: "127.0.0.1:1", From: "chirpy@example.com"},
+	}
+
+	tests := []struct {
+		name    string
+		message Message
+	}{
+		{"CRLF in To", Message{To: "bob@example.com\r\nBcc: everyone@example.com", Subject: "Hi"}},
+		{"LF in To", Message{To: "bob@example.com\nBcc: everyone@example.com", Subject: "Hi"}},
+		{"CR in Subject", Message{To: "bob@example.com", Subject: "Hi\rBcc: everyone@example.com"}},
+		{"LF in Subject", Message{To: "bob@example.com", Subject: "Hi\nBcc: everyone@example.com"}},
+	}
+	for name, mailer := range mailers {
+		for _, test := range tests {
+			t.Run(name+" "+test.name, func(t *testing.T) {
+				err := mailer.Send(test.message)
+				if err == nil {
+					t.Error("Send accepted a line break in a header")
+				}
+			})
+		}
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 || out.Len() != 0 {
+		t.Errorf("rejected messages were still written: %d files, %q", len(entries), out.String())
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+	fileMailer, err := NewFileMailer(dir, "chirpy@example.com")
+	if err != nil {
+		t.Fatalf("NewFileMailer: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		err = fileMailer.Send(Message{To: "bob@example.com", Subject: "Hi", Body: "Hello"})
+		if err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d files, want one per message", len(entries))
+	}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".eml") {
+			t.Errorf("file %q is not an .eml file", entry.Name())
+		}
+	}
+}
+
+func TestLogMailer(t *testing.T) {
+	tests := []struct {
+		name     string
+		showBody bool
+		wantBody bool
+	}{
+		{"body hidden", false, false},
+		{"body shown", true, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			mailer := LogMailer{Out: &out, From: "chirpy@example.com", ShowBody: test.showBody}
+			err := mailer.Send(Message{To: "bob@example.com", Subject: "Reset", Body: "https://chirpy.example/reset?token=abc"})
+			if err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+			if !strings.Contains(out.String(), "To: bob@example.com") {
+				t.Errorf("log does not say who the email is for:\n%s", out.String())
+			}
+			if strings.Contains(out.String(), "token=abc") != test.wantBody {
+				t.Errorf("body shown = %v, want %v:\n%s", !test.wantBody, test.wantBody, out.String())
+			}
+		})
+	}
+}
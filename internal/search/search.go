@@ -0,0 +1,95 @@
+package search
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"github/JohnDirewolf/chirpy/internal/entities"
+)
+
+// The layout since: and until: dates are written in.
+const dateLayout = "2006-01-02"
+
+// A search split into the words to match and the operators that narrow it down.
+// Text is left in websearch form, so "quoted phrases", OR and -word are handled by Postgres.
+type Query struct {
+	Text  string
+	From  string
+	Since time.Time
+	Until time.Time
+	Tags  []string
+}
+
+// Parse splits a search such as `"good morning" from:bob since:2024-01-01 #coffee` into its parts.
+// Until is returned as the start of the day after, so the day given is included.
+func Parse(raw string) (Query, error) {
+	//Tags start empty rather than nil, an empty list means no tag filter.
+	query := Query{Tags: []string{}}
+	words := make([]string, 0)
+
+	for _, token := range tokenize(raw) {
+		lowerToken := strings.ToLower(token)
+		switch {
+		case strings.HasPrefix(lowerToken, "from:"):
+			query.From = strings.TrimPrefix(token[len("from:"):], "@")
+			if query.From == "" {
+				return Query{}, errors.New("from: needs a user")
+			}
+		case strings.HasPrefix(lowerToken, "since:"):
+			since, err := time.Parse(dateLayout, token[len("since:"):])
+			if err != nil {
+				return Query{}, errors.New("since: needs a date like 2006-01-02")
+			}
+			query.Since = since
+		case strings.HasPrefix(lowerToken, "until:"):
+			until, err := time.Parse(dateLayout, token[len("until:"):])
+			if err != nil {
+				return Query{}, errors.New("until: needs a date like 2006-01-02")
+			}
+			query.Until = until.AddDate(0, 0, 1)
+		case strings.HasPrefix(token, "#"):
+			tag := entities.Tag(token)
+			if tag == "" {
+				return Query{}, errors.New("# needs a tag")
+			}
+			if !slices.Contains(query.Tags, tag) {
+				query.Tags = append(query.Tags, tag)
+			}
+		default:
+			words = append(words, token)
+		}
+	}
+
+	query.Text = strings.Join(words, " ")
+	if query.Text == "" && query.From == "" && query.Since.IsZero() && query.Until.IsZero() && len(query.Tags) == 0 {
+		return Query{}, errors.New("search is empty")
+	}
+	return query, nil
+}
+
+// Splits on spaces, except inside double quotes, so a phrase stays one token with its quotes.
+func tokenize(raw string) []string {
+	tokens := make([]string, 0)
+	var current strings.Builder
+	inQuotes := false
+	for _, character := range raw {
+		if character == '"' {
+			inQuotes = !inQuotes
+		}
+		if unicode.IsSpace(character) && !inQuotes {
+			if current.Len() > 0 {
+				tokens = append(tokens, current.String())
+				current.Reset()
+			}
+			continue
+		}
+		current.WriteRune(character)
+	}
+	if current.Len() > 0 {
+		tokens = append(tokens, current.String())
+	}
+	return tokens
+}
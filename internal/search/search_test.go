@@ -0,0 +1,51 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	day := func(year int, month time.Month, date int) time.Time {
+		return time.Date(year, month, date, 0, 0, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name string
+		raw  string
+		want Query
+	}{
+		{"words", "good morning", Query{Text: "good morning", Tags: []string{}}},
+		{"phrase kept whole", `"good morning"   world`, Query{Text: `"good morning" world`, Tags: []string{}}},
+		{"websearch operators left alone", "coffee OR tea -decaf", Query{Text: "coffee OR tea -decaf", Tags: []string{}}},
+		{"from", "from:bob hello", Query{Text: "hello", From: "bob", Tags: []string{}}},
+		{"from with @", "from:@bob", Query{From: "bob", Tags: []string{}}},
+		{"operators ignore case", "FROM:bob Since:2024-01-01", Query{From: "bob", Since: day(2024, 1, 1), Tags: []string{}}},
+		{"until includes the day", "until:2024-01-31", Query{Until: day(2024, 2, 1), Tags: []string{}}},
+		{"tags lower case and once", "#Coffee #coffee #tea", Query{Tags: []string{"coffee", "tea"}}},
+		{"everything", `"good morning" from:bob since:2024-01-01 #coffee`, Query{Text: `"good morning"`, From: "bob", Since: day(2024, 1, 1), Tags: []string{"coffee"}}},
+		{"operator inside quotes is a word", `"from:bob"`, Query{Text: `"from:bob"`, Tags: []string{}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Parse(test.raw)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", test.raw, err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Parse(%q) = %+v, want %+v", test.raw, got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, raw := range []string{"", "   ", "from:", "from:@", "since:yesterday", "until:2024-13-01", "#", "hello #"} {
+		t.Run(raw, func(t *testing.T) {
+			_, err := Parse(raw)
+			if err == nil {
+				t.Errorf("Parse(%q) gave no error", raw)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Storage is where uploaded files live. Keys are flat file names, the storage decides where the bytes go and what URL serves them.
+type Storage interface {
+	Save(key string, data io.Reader) error
+	Delete(key string) error
+	URL(key string) string
+}
+
+// LocalStorage keeps files in a directory on this server and serves them itself.
+type LocalStorage struct {
+	Dir     string
+	BaseURL string
+}
+
+func NewLocalStorage(dir string, baseURL string) (*LocalStorage, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	return &LocalStorage{Dir: dir, BaseURL: strings.TrimSuffix(baseURL, "/")}, nil
+}
+
+func (local *LocalStorage) Save(key string, data io.Reader) error {
+	path, err := local.path(key)
+	if err != nil {
+		return err
+	}
+
+	//Write to a temporary file first, so a failed upload never leaves half a file behind the key.
+	tempFile, err := os.CreateTemp(local.Dir, ".upload-*")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tempFile, data)
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return err
+	}
+	return os.Rename(tempFile.Name(), path)
+}
+
+func (local *LocalStorage) Delete(key string) error {
+	path, err := local.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (local *LocalStorage) URL(key string) string {
+	return local.BaseURL + "/" + key
+}
+
+// Serves stored files by key. Unlike http.FileServer it never lists the directory.
+func (local *LocalStorage) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+	path, err := local.path(strings.TrimPrefix(request.URL.Path, "/"))
+	if err != nil {
+		http.NotFound(response, request)
+		return
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		http.NotFound(response, request)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(response, request)
+		return
+	}
+	http.ServeContent(response, request, info.Name(), info.ModTime(), file)
+}
+
+// Keys are single file names, anything that could reach outside the directory is refused.
+func (local *LocalStorage) path(key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, ".") || strings.ContainsAny(key, `/\`) {
+		return "", errors.New("invalid storage key")
+	}
+	return filepath.Join(local.Dir, key), nil
+}
@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestStorage(t *testing.T) *LocalStorage {
+	t.Helper()
+	local, err := NewLocalStorage(filepath.Join(t.TempDir(), "media"), "http://localhost:8080/media/")
+	if err != nil {
+		t.Fatalf("NewLocalStorage: %v", err)
+	}
+	return local
+}
+
+func TestLocalStorageSaveDelete(t *testing.T) {
+	local := newTestStorage(t)
+	err := local.Save("abc.png", strings.NewReader("image bytes"))
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(local.Dir, "abc.png"))
+	if err != nil || string(data) != "image bytes" {
+		t.Fatalf("stored file = %q, %v", data, err)
+	}
+	if url := local.URL("abc.png"); url != "http://localhost:8080/media/abc.png" {
+		t.Errorf("URL = %q", url)
+	}
+
+	//Saving again replaces the file, and no temporary files are left behind.
+	err = local.Save("abc.png", strings.NewReader("new bytes"))
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	entries, _ := os.ReadDir(local.Dir)
+	if len(entries) != 1 {
+		t.Errorf("directory holds %d files, want 1", len(entries))
+	}
+
+	err = local.Delete("abc.png")
+	if err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	_, err = os.Stat(filepath.Join(local.Dir, "abc.png"))
+	if !os.IsNotExist(err) {
+		t.Errorf("file still there after Delete: %v", err)
+	}
+	err = local.Delete("abc.png")
+	if err != nil {
+		t.Errorf("deleting a missing file: %v", err)
+	}
+}
+
+func TestLocalStorageRejectsKeys(t *testing.T) {
+	local := newTestStorage(t)
+	for _, key := range []string{"", ".", "..", ".hidden", "../escape.png", "a/b.png", `a\b.png`, "/etc/passwd"} {
+		t.Run(key, func(t *testing.T) {
+			err := local.Save(key, strings.NewReader("x"))
+			if err == nil {
+				t.Error("Save accepted the key")
+			}
+			err = local.Delete(key)
+			if err == nil {
+				t.Error("Delete accepted the key")
+			}
+		})
+	}
+	_, err := os.Stat(filepath.Join(filepath.Dir(local.Dir), "escape.png"))
+	if !os.IsNotExist(err) {
+		t.Error("a file was written outside the storage directory")
+	}
+}
+
+func TestLocalStorageServeHTTP(t *testing.T) {
+	local := newTestStorage(t)
+	err := local.Save("abc.png", strings.NewReader("image bytes"))
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	//A file next to the storage directory that must never be served.
+	err = os.WriteFile(filepath.Join(filepath.Dir(local.Dir), "secret.txt"), []byte("secret"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{"stored file", "/abc.png", http.StatusOK, "image bytes"},
+		{"missing file", "/missing.png", http.StatusNotFound, ""},
+		{"directory listing", "/", http.StatusNotFound, ""},
+		{"outside the directory", "/../secret.txt", http.StatusNotFound, ""},
+		{"hidden file", "/.upload-1", http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			//Set the path directly, it may be one the router would have cleaned up.
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.URL.Path = test.path
+			recorder := httptest.NewRecorder()
+			local.ServeHTTP(recorder, request)
+			body, _ := io.ReadAll(recorder.Body)
+			if recorder.Code != test.wantStatus {
+				t.Fatalf("status = %d, want %d", recorder.Code, test.wantStatus)
+			}
+			if test.wantBody != "" && string(body) != test.wantBody {
+				t.Errorf("body = %q, want %q", body, test.wantBody)
+			}
+			if strings.Contains(string(body), "secret") {
+				t.Error("served a file from outside the storage directory")
+			}
+		})
+	}
+}
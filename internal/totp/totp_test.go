@@ -0,0 +1,122 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// The SHA1 secret from RFC 6238 appendix B, "12345678901234567890" base32 encoded.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	//The RFC gives 8 digit codes, ours are the last 6 of them.
+	tests := []struct {
+		unixTime int64
+		want     string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, test := range tests {
+		got, err := Code(rfcSecret, Step(time.Unix(test.unixTime, 0)))
+		if err != nil {
+			t.Fatalf("Code at %d: %v", test.unixTime, err)
+		}
+		if got != test.want {
+			t.Errorf("Code at %d = %q, want %q", test.unixTime, got, test.want)
+		}
+	}
+}
+
+func TestCodeLowerCaseSecret(t *testing.T) {
+	got, err := Code(strings.ToLower(rfcSecret), Step(time.Unix(59, 0)))
+	if err != nil || got != "287082" {
+		t.Errorf("Code with lower case secret = %q, %v, want 287082", got, err)
+	}
+}
+
+func TestCodeBadSecret(t *testing.T) {
+	_, err := Code("not base32!", 1)
+	if err == nil {
+		t.Error("Code with a malformed secret gave no error")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := Step(now)
+	codeAt := func(step int64) string {
+		code, err := Code(rfcSecret, step)
+		if err != nil {
+			t.Fatalf("Code: %v", err)
+		}
+		return code
+	}
+
+	tests := []struct {
+		name     string
+		code     string
+		lastStep int64
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", codeAt(current), 0, current, true},
+		{"one step behind", codeAt(current - 1), 0, current - 1, true},
+		{"one step ahead", codeAt(current + 1), 0, current + 1, true},
+		{"two steps behind", codeAt(current - 2), 0, 0, false},
+		{"two steps ahead", codeAt(current + 2), 0, 0, false},
+		{"spaces ignored", codeAt(current)[:3] + " " + codeAt(current)[3:], 0, current, true},
+		{"already used", codeAt(current), current, 0, false},
+		{"earlier step than last used", codeAt(current - 1), current - 1, 0, false},
+		{"too short", codeAt(current)[:5], 0, 0, false},
+		{"too long", codeAt(current) + "0", 0, 0, false},
+		{"empty", "", 0, 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			step, ok := Validate(rfcSecret, test.code, now, test.lastStep)
+			if ok != test.wantOK || step != test.wantStep {
+				t.Errorf("Validate = %d, %v, want %d, %v", step, ok, test.wantStep, test.wantOK)
+			}
+		})
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatalf("GenerateSecret: %v", err)
+	}
+	key, err := encoding.DecodeString(secret)
+	if err != nil {
+		t.Fatalf("secret is not base32: %v", err)
+	}
+	if len(key) != secretSize {
+		t.Errorf("secret is %d bytes, want %d", len(key), secretSize)
+	}
+	other, _ := GenerateSecret()
+	if other == secret {
+		t.Error("two secrets were the same")
+	}
+}
+
+func TestURI(t *testing.T) {
+	got := URI("Chirpy", "me@example.com", rfcSecret)
+	for _, want := range []string{
+		"otpauth://totp/Chirpy:me@example.com?",
+		"secret=" + rfcSecret,
+		"issuer=Chirpy",
+		"digits=6",
+		"period=30",
+		"algorithm=SHA1",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("URI %q does not contain %q", got, want)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Other services fetch our public keys from here to check tokens without calling us for each one.
+func (cfg *apiConfig) handlerJWKS(response http.ResponseWriter, request *http.Request) {
+	dataMarshalled, err := json.Marshal(cfg.jwtKeys.JWKS())
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Failed create response."))
+		return
+	}
+
+	//Keys only change when the server restarts with a new key, a few minutes of caching is safe.
+	response.Header().Set("Cache-Control", "public, max-age=300")
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusOK)
+	response.Write(dataMarshalled)
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github/JohnDirewolf/chirpy/internal/auth"
+	"github/JohnDirewolf/chirpy/internal/database"
+
+	"github.com/google/uuid"
+)
+
+// Works out which of the chirps the caller has liked.
+// Reading chirps does not need a login, so without a valid bearer token the map is nil and liked_by_me is left off.
+func (cfg *apiConfig) utilityLikedByViewer(request *http.Request, chirpIDs []uuid.UUID) (map[uuid.UUID]bool, error) {
+	userToken, err := auth.GetBearerToken(request.Header)
+	if err != nil {
+		return nil, nil
+	}
+	viewerID, err := cfg.utilityAuthenticate(userToken, scopeChirpsRead)
+	if err != nil {
+		return nil, nil
+	}
+
+	likedList, err := cfg.dbQueries.GetLikedChirpIDs(context.Background(), database.GetLikedChirpIDsParams{
+		UserID:   viewerID,
+		ChirpIds: chirpIDs,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	liked := make(map[uuid.UUID]bool, len(chirpIDs))
+	for _, chirpID := range chirpIDs {
+		liked[chirpID] = false
+	}
+	for _, chirpID := range likedList {
+		liked[chirpID] = true
+	}
+	return liked, nil
+}
+
+func utilityApplyLiked(chirpResponse *chirpsResponse, liked map[uuid.UUID]bool) {
+	if liked == nil {
+		return
+	}
+	likedByMe := liked[chirpResponse.Id]
+	chirpResponse.LikedByMe = &likedByMe
+}
+
+func (cfg *apiConfig) handlerLikeChirp(response http.ResponseWriter, request *http.Request) {
+	cfg.utilitySetLike(response, request, true)
+}
+
+func (cfg *apiConfig) handlerUnlikeChirp(response http.ResponseWriter, request *http.Request) {
+	cfg.utilitySetLike(response, request, false)
+}
+
+// Liking and unliking are the same apart from the query, so they share this.
+func (cfg *apiConfig) utilitySetLike(response http.ResponseWriter, request *http.Request, like bool) {
+	//Validate credentials sent.
+	//Get the user token from the request.
+	userToken, err := auth.GetBearerToken(request.Header)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusUnauthorized)
+		response.Write([]byte("Unathorized: Please login first."))
+		return
+	}
+
+	userID, err := cfg.utilityAuthenticate(userToken, scopeChirpsWrite)
+	if err != nil {
+		utilityWriteAuthError(response, err, scopeChirpsWrite)
+		return
+	}
+
+	chirpID, err := uuid.Parse(request.PathValue("chirpID"))
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Error! Invalid Chirp ID."))
+		return
+	}
+
+	chirp, err := cfg.dbQueries.GetChirpByID(context.Background(), chirpID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			response.WriteHeader(http.StatusNotFound)
+			response.Write([]byte("Error! Chirp not found."))
+			return
+		}
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Error! Server error fetching Chirp."))
+		return
+	}
+
+	//Tombstones cannot be liked, but an old like on one can still be taken back.
+	if like && chirp.DeletedAt.Valid {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusNotFound)
+		response.Write([]byte("Error! Chirp not found."))
+		return
+	}
+
+	//The like row and the count change in one statement, and the count only moves when a row was really added or removed.
+	//That keeps the count right however many likes and unlikes come in at once.
+	if like {
+		_, err = cfg.dbQueries.LikeChirp(context.Background(), database.LikeChirpParams{
+			UserID:  userID,
+			ChirpID: chirp.ID,
+		})
+	} else {
+		_, err = cfg.dbQueries.UnlikeChirp(context.Background(), database.UnlikeChirpParams{
+			UserID:  userID,
+			ChirpID: chirp.ID,
+		})
+	}
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Error! Server error updating like."))
+		return
+	}
+
+	//Success
+	response.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerGetUserLikes(response http.ResponseWriter, request *http.Request) {
+	var likeList []database.GetUserLikesPageDescRow
+
+	userID, err := uuid.Parse(request.PathValue("userID"))
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: user id is malformed."))
+		return
+	}
+
+	//Work out the page size and where the page starts.
+	page, err := utilityParsePageRequest(request.URL.Query())
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: " + err.Error() + "."))
+		return
+	}
+
+	_, err = cfg.dbQueries.GetUserByID(context.Background(), userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			response.WriteHeader(http.StatusNotFound)
+			response.Write([]byte("Not Found: User not found."))
+			return
+		}
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not fetch user."))
+		return
+	}
+
+	//Likes are listed newest first, so only paging back walks up the index.
+	if page.pagingBack {
+		var ascList []database.GetUserLikesPageAscRow
+		ascList, err = cfg.dbQueries.GetUserLikesPageAsc(context.Background(), database.GetUserLikesPageAscParams{
+			UserID:          userID,
+			CursorCreatedAt: page.cursorCreatedAt,
+			CursorID:        page.cursorID,
+			PageLimit:       page.limit + 1,
+		})
+		for _, row := range ascList {
+			likeList = append(likeList, database.GetUserLikesPageDescRow(row))
+		}
+	} else {
+		likeList, err = cfg.dbQueries.GetUserLikesPageDesc(context.Background(), database.GetUserLikesPageDescParams{
+			UserID:          userID,
+			CursorCreatedAt: page.cursorCreatedAt,
+			CursorID:        page.cursorID,
+			PageLimit:       page.limit + 1,
+		})
+	}
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not retrieve likes."))
+		return
+	}
+
+	//Pages follow when the chirps were liked, not when they were written.
+	likeList, nextCursor, prevCursor := utilityPageCursors(likeList, page, func(row database.GetUserLikesPageDescRow) (time.Time, uuid.UUID) {
+		return row.LikedAt, row.Chirp.ID
+	})
+
+	chirpList := make([]database.Chirp, 0, len(likeList))
+	for i := 0; i < len(likeList); i++ {
+		chirpList = append(chirpList, likeList[i].Chirp)
+	}
+	chripListResponse, err := cfg.utilityChirpListResponse(request, chirpList)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not retrieve likes."))
+		return
+	}
+
+	chripListMarshalled, err := json.Marshal(chirpsPageResponse{
+		Chirps:     chripListResponse,
+		NextCursor: nextCursor,
+		PrevCursor: prevCursor,
+	})
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not process Chirps to JSON."))
+		return
+	}
+
+	//Success
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusOK)
+	response.Write(chripListMarshalled)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github/JohnDirewolf/chirpy/internal/database"
+
+	"github.com/google/uuid"
+)
+
+// Failed logins are counted against the account and against the address they come from. A few are let through,
+// after that each one locks out more tries for twice as long as the one before, up to a limit. The lockouts are
+// worked out by ReserveLoginAttempt.
+// Counts start over once a day has passed without a failure.
+const accountFreeLoginFailures = 5
+const ipFreeLoginFailures = 20
+const maxAccountLockout = time.Minute * 15
+const maxIPLockout = time.Hour
+
+const (
+	loginThrottleAccount = "account"
+	loginThrottleIP      = "ip"
+)
+
+// Why a login failed, as kept in failed_logins.
+const (
+	failedLoginPassword     = "password"
+	failedLoginSecondFactor = "second_factor"
+)
+
+// Emails are kept to a sane length in failed_logins and login_throttles, anyone can type anything into the login form.
+const maxFailedLoginEmailLength = 320
+
+// Cuts a value typed in by anyone down to at most maxLength bytes before it is stored, without splitting a character.
+func utilityTruncate(value string, maxLength int) string {
+	if len(value) <= maxLength {
+		return value
+	}
+	return strings.ToValidUTF8(value[:maxLength], "")
+}
+
+// Accounts are counted by the email typed in, whether or not it has an account, so a lockout does not
+// give away who has signed up either.
+func utilityAccountThrottleKey(email string) string {
+	return utilityTruncate(strings.ToLower(strings.TrimSpace(email)), maxFailedLoginEmailLength)
+}
+
+// IPv6 users are usually handed a whole /64, so it is counted as one address.
+func utilityIPThrottleKey(ipAddress string) string {
+	ip := net.ParseIP(ipAddress)
+	if ip == nil {
+		return utilityTruncate(ipAddress, maxFailedLoginEmailLength)
+	}
+	if ip.To4() != nil {
+		return ipAddress
+	}
+	return ip.Mask(net.CIDRMask(64, 128)).String() + "/64"
+}
+
+// One throttle a login attempt is counted against. Once reserved, lockedUntil is the lockout the attempt set, if any.
+type loginThrottle struct {
+	kind         string
+	key          string
+	freeFailures int32
+	maxLockout   time.Duration
+	lockedUntil  sql.NullTime
+}
+
+func utilityLoginThrottles(request *http.Request, email string) []loginThrottle {
+	return []loginThrottle{
+		{kind: loginThrottleAccount, key: utilityAccountThrottleKey(email), freeFailures: accountFreeLoginFailures, maxLockout: maxAccountLockout},
+		{kind: loginThrottleIP, key: utilityIPThrottleKey(utilityClientIP(request)), freeFailures: ipFreeLoginFailures, maxLockout: maxIPLockout},
+	}
+}
+
+// Counts an attempt against the account and the address before the password or code is checked, returning how long
+// to wait instead if either is locked out. Checking first and counting after would let attempts sent all at once
+// through before any of them were counted, so every attempt is taken as a failure until what was reserved is released.
+func (cfg *apiConfig) utilityReserveLoginAttempt(request *http.Request, email string) ([]loginThrottle, time.Duration, error) {
+	return cfg.utilityReserveThrottles(utilityLoginThrottles(request, email))
+}
+
+// Counts an attempt against every one of the throttles, or none of them if any is locked out.
+func (cfg *apiConfig) utilityReserveThrottles(throttles []loginThrottle) ([]loginThrottle, time.Duration, error) {
+	reserved := make([]loginThrottle, 0, len(throttles))
+	for _, throttle := range throttles {
+		throttleData, err := cfg.dbQueries.ReserveLoginAttempt(context.Background(), database.ReserveLoginAttemptParams{
+			Kind:              throttle.kind,
+			Key:               throttle.key,
+			FreeFailures:      throttle.freeFailures,
+			MaxLockoutSeconds: int32(throttle.maxLockout / time.Second),
+		})
+		if err == nil {
+			throttle.lockedUntil = throttleData.LockedUntil
+			reserved = append(reserved, throttle)
+			continue
+		}
+
+		//Whatever stopped this attempt, it was not made, so it is not counted against the throttles before this one.
+		cfg.utilityReleaseThrottles(reserved)
+		if err != sql.ErrNoRows {
+			return nil, 0, err
+		}
+		throttleData, err = cfg.dbQueries.GetLoginThrottle(context.Background(), database.GetLoginThrottleParams{
+			Kind: throttle.kind,
+			Key:  throttle.key,
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+		//The lockout may have run out in the meantime, still a second is better than letting it through uncounted.
+		return nil, max(time.Second, time.Until(throttleData.LockedUntil.Time)), nil
+	}
+	return reserved, 0, nil
+}
+
+// Takes back an attempt that turned out not to be a failure, along with the lockout it set. Nothing was locked out
+// when it was reserved, so unless a later attempt has set a lockout of its own there is none to go back to.
+func (cfg *apiConfig) utilityReleaseThrottles(reserved []loginThrottle) {
+	for _, throttle := range reserved {
+		err := cfg.dbQueries.ReleaseLoginAttempt(context.Background(), database.ReleaseLoginAttemptParams{
+			Kind:        throttle.kind,
+			Key:         throttle.key,
+			LockedUntil: throttle.lockedUntil,
+		})
+		if err != nil {
+			fmt.Printf("Error releasing login attempt: %v\n", err)
+		}
+	}
+}
+
+// Keeps a record of a failed login, it was already counted against the throttles when the attempt was reserved.
+// The login has failed either way, so trouble doing this is only logged.
+func (cfg *apiConfig) utilityRecordLoginFailure(request *http.Request, email string, userID uuid.NullUUID, reason string) {
+	err := cfg.dbQueries.CreateFailedLogin(context.Background(), database.CreateFailedLoginParams{
+		Email:     utilityTruncate(email, maxFailedLoginEmailLength),
+		UserID:    userID,
+		IpAddress: utilityClientIP(request),
+		UserAgent: request.UserAgent(),
+		Reason:    reason,
+	})
+	if err != nil {
+		fmt.Printf("Error recording failed login: %v\n", err)
+	}
+	cfg.utilityAudit(request, auditLoginFailed, uuid.Nil, userID.UUID, map[string]any{"email_hash": cfg.utilityAuditEmail(email), "reason": reason})
+}
+
+// A login that got all the way through wipes the account's failures. The address keeps its count,
+// otherwise logging in to one account now and then would let it keep guessing at others.
+func (cfg *apiConfig) utilityClearLoginFailures(email string) {
+	cfg.utilityClearThrottle(loginThrottle{kind: loginThrottleAccount, key: utilityAccountThrottleKey(email)})
+}
+
+func (cfg *apiConfig) utilityClearThrottle(throttle loginThrottle) {
+	err := cfg.dbQueries.ClearLoginThrottle(context.Background(), database.ClearLoginThrottleParams{
+		Kind: throttle.kind,
+		Key:  throttle.key,
+	})
+	if err != nil {
+		fmt.Printf("Error clearing failed logins: %v\n", err)
+	}
+}
+
+// Retry-After is in whole seconds, rounded up so a client that waits that long is let in.
+func utilityRetryAfterSeconds(retryAfter time.Duration) string {
+	return strconv.Itoa(max(1, int(math.Ceil(retryAfter.Seconds()))))
+}
+
+func utilityWriteTooManyLogins(response http.ResponseWriter, retryAfter time.Duration) {
+	response.Header().Set("Retry-After", utilityRetryAfterSeconds(retryAfter))
+	response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+	response.WriteHeader(http.StatusTooManyRequests)
+	response.Write([]byte("Too Many Requests: Too many failed logins, please try again in " + utilityRetryAfterSeconds(retryAfter) + " seconds."))
+}
@@ -8,9 +8,13 @@ import (
 	"fmt"
 	"github/JohnDirewolf/chirpy/internal/auth"
 	"github/JohnDirewolf/chirpy/internal/database"
+	"github/JohnDirewolf/chirpy/internal/entities"
+	"github/JohnDirewolf/chirpy/internal/mailer"
+	"github/JohnDirewolf/chirpy/internal/storage"
 
 	//"io"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -26,10 +30,23 @@ import (
 
 type apiConfig struct {
 	fileserverHits atomic.Int32
+	db             *sql.DB
 	dbQueries      *database.Queries
 	PLATFORM       string
 	SECRET         string
 	POLKA          string
+	mediaStore     storage.Storage
+	jwtKeys        *auth.KeySet
+	secretBox      *auth.SecretBox
+	//What emails in the audit log are hashed with.
+	auditKey string
+	mailer   mailer.Mailer
+	appURL   string
+	//When set, users must confirm their email before they can post.
+	requireVerifiedEmail bool
+	//How new password hashes are made, and what a new password has to pass.
+	passwordParams auth.PasswordParams
+	passwordPolicy *auth.PasswordPolicy
 }
 
 type chirpsResponse struct {
@@ -37,12 +54,50 @@ type chirpsResponse struct {
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 	Body      string    `json:"body"`
-	UserId    uuid.UUID `json:"user_id"`
+	//Null once the author's account is deleted and only the tombstone is left.
+	UserId     *uuid.UUID `json:"user_id"`
+	InReplyTo  *uuid.UUID `json:"in_reply_to"`
+	RootId     *uuid.UUID `json:"root_id"`
+	ReplyCount int32      `json:"reply_count"`
+	LikeCount  int32      `json:"like_count"`
+	LikedByMe  *bool      `json:"liked_by_me,omitempty"`
+	Deleted    bool       `json:"deleted,omitempty"`
+	//Rechirps and quotes carry the chirp they share inline.
+	RechirpCount int32           `json:"rechirp_count"`
+	QuoteCount   int32           `json:"quote_count"`
+	RechirpOf    *chirpsResponse `json:"rechirp_of,omitempty"`
+	QuoteOf      *chirpsResponse `json:"quote_of,omitempty"`
+	//Hashtags, mentions and links found in the body.
+	Entities []chirpEntityResponse `json:"entities"`
+	//Uploaded images, in the order they were attached.
+	Attachments []mediaResponse `json:"attachments"`
+	//Only filled in when the client asks for it with ?expand=author.
+	Author *authorSummaryResponse `json:"author,omitempty"`
 }
 
+// Pages of chirps carry opaque cursors for the pages either side, blank when there is no such page.
+type chirpsPageResponse struct {
+	Chirps     []chirpsResponse `json:"chirps"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+	PrevCursor string           `json:"prev_cursor,omitempty"`
+}
+
+// Each revision is a body the chirp used to have, and when it was written and then replaced.
+type chirpRevisionResponse struct {
+	Id         uuid.UUID `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	ReplacedAt time.Time `json:"replaced_at"`
+	Body       string    `json:"body"`
+	ChirpId    uuid.UUID `json:"chirp_id"`
+}
+
+// Refresh tokens last 60 days from when they are issued.
+const refreshTokenDuration = time.Hour * 24 * 60
+
 type userRequest struct {
 	Password string `json:"password"`
 	Email    string `json:"email"`
+	Handle   string `json:"handle"`
 	//ExpiresInSeconds int32  `json:"expires_in_seconds"` - No longer used, access token expire in 1 hour.
 }
 
@@ -54,6 +109,16 @@ type userResponse struct {
 	Token        string    `json:"token"`
 	RefreshToken string    `json:"refresh_token"`
 	IsChirpyRed  bool      `json:"is_chirpy_red"`
+	//The public profile.
+	Handle        string     `json:"handle"`
+	DisplayName   string     `json:"display_name"`
+	Bio           string     `json:"bio"`
+	AvatarMediaId *uuid.UUID `json:"avatar_media_id"`
+	//Whether the email is confirmed, and any new one still waiting to be.
+	EmailVerified bool   `json:"email_verified"`
+	PendingEmail  string `json:"pending_email,omitempty"`
+	//user, moderator or admin.
+	Role string `json:"role"`
 }
 
 func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
@@ -74,6 +139,129 @@ func utilityProfanityFilter(rawString string) string {
 	return strings.Join(rawArray, " ")
 }
 
+// Points at the UUID held in a nullable column, nil when there is none.
+func utilityNullUUID(nullUUID uuid.NullUUID) *uuid.UUID {
+	if !nullUUID.Valid {
+		return nil
+	}
+	return &nullUUID.UUID
+}
+
+// Copies a chirp from the database into our JSON structure.
+func utilityChirpResponse(chirp database.Chirp) chirpsResponse {
+	chirpResponse := chirpsResponse{
+		Id:           chirp.ID,
+		CreatedAt:    chirp.CreatedAt,
+		UpdatedAt:    chirp.UpdatedAt,
+		Body:         chirp.Body,
+		UserId:       utilityNullUUID(chirp.UserID),
+		ReplyCount:   chirp.ReplyCount,
+		LikeCount:    chirp.LikeCount,
+		Deleted:      chirp.DeletedAt.Valid,
+		RechirpCount: chirp.RechirpCount,
+		QuoteCount:   chirp.QuoteCount,
+	}
+	if chirp.ParentID.Valid {
+		chirpResponse.InReplyTo = &chirp.ParentID.UUID
+	}
+	if chirp.RootID.Valid {
+		chirpResponse.RootId = &chirp.RootID.UUID
+	}
+	return chirpResponse
+}
+
+// How many levels of shared chirps we fill in, enough for a rechirp of a quote to show what was quoted.
+const sharedChirpDepth = 2
+
+// Copies a list of chirps into our JSON structure, along with whether the caller has liked each one and the chirps they share.
+func (cfg *apiConfig) utilityChirpListResponse(request *http.Request, chirpList []database.Chirp) ([]chirpsResponse, error) {
+	return cfg.utilityHydrateChirps(request, chirpList, sharedChirpDepth)
+}
+
+func (cfg *apiConfig) utilityHydrateChirps(request *http.Request, chirpList []database.Chirp, depth int) ([]chirpsResponse, error) {
+	chirpListResponse := make([]chirpsResponse, 0, len(chirpList))
+	if len(chirpList) == 0 {
+		return chirpListResponse, nil
+	}
+
+	chirpIDs := make([]uuid.UUID, 0, len(chirpList))
+	sharedIDs := make([]uuid.UUID, 0)
+	for i := 0; i < len(chirpList); i++ {
+		chirpIDs = append(chirpIDs, chirpList[i].ID)
+		if chirpList[i].RechirpOf.Valid {
+			sharedIDs = append(sharedIDs, chirpList[i].RechirpOf.UUID)
+		}
+		if chirpList[i].QuoteOf.Valid {
+			sharedIDs = append(sharedIDs, chirpList[i].QuoteOf.UUID)
+		}
+	}
+	liked, err := cfg.utilityLikedByViewer(request, chirpIDs)
+	if err != nil {
+		return nil, err
+	}
+	entitiesByChirp, err := cfg.utilityChirpEntities(chirpIDs)
+	if err != nil {
+		return nil, err
+	}
+	mediaByChirp, err := cfg.utilityChirpMedia(chirpIDs)
+	if err != nil {
+		return nil, err
+	}
+	var authorsByID map[uuid.UUID]authorSummaryResponse
+	if utilityExpandAuthor(request) {
+		authorIDs := make([]uuid.UUID, 0, len(chirpList))
+		for i := 0; i < len(chirpList); i++ {
+			if chirpList[i].UserID.Valid {
+				authorIDs = append(authorIDs, chirpList[i].UserID.UUID)
+			}
+		}
+		authorsByID, err = cfg.utilityAuthorSummaries(authorIDs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	//Load every shared chirp on the page in one go. A deleted one comes back as its tombstone.
+	sharedByID := make(map[uuid.UUID]chirpsResponse)
+	if depth > 0 && len(sharedIDs) > 0 {
+		sharedList, err := cfg.dbQueries.GetChirpsByIDs(context.Background(), sharedIDs)
+		if err != nil {
+			return nil, err
+		}
+		sharedListResponse, err := cfg.utilityHydrateChirps(request, sharedList, depth-1)
+		if err != nil {
+			return nil, err
+		}
+		for _, sharedResponse := range sharedListResponse {
+			sharedByID[sharedResponse.Id] = sharedResponse
+		}
+	}
+
+	for i := 0; i < len(chirpList); i++ {
+		chirpResponse := utilityChirpResponse(chirpList[i])
+		utilityApplyLiked(&chirpResponse, liked)
+		chirpResponse.Entities = entitiesByChirp[chirpList[i].ID]
+		if chirpResponse.Entities == nil {
+			chirpResponse.Entities = []chirpEntityResponse{}
+		}
+		chirpResponse.Attachments = mediaByChirp[chirpList[i].ID]
+		if chirpResponse.Attachments == nil {
+			chirpResponse.Attachments = []mediaResponse{}
+		}
+		if author, found := authorsByID[chirpList[i].UserID.UUID]; found && chirpList[i].UserID.Valid {
+			chirpResponse.Author = &author
+		}
+		if sharedResponse, found := sharedByID[chirpList[i].RechirpOf.UUID]; found && chirpList[i].RechirpOf.Valid {
+			chirpResponse.RechirpOf = &sharedResponse
+		}
+		if sharedResponse, found := sharedByID[chirpList[i].QuoteOf.UUID]; found && chirpList[i].QuoteOf.Valid {
+			chirpResponse.QuoteOf = &sharedResponse
+		}
+		chirpListResponse = append(chirpListResponse, chirpResponse)
+	}
+	return chirpListResponse, nil
+}
+
 func endHandler(response http.ResponseWriter, request *http.Request) {
 	response.Header().Add("Content-Type", "text/plain; charset=utf-8")
 	response.WriteHeader(http.StatusOK)
@@ -121,6 +309,7 @@ func (cfg *apiConfig) reset(response http.ResponseWriter, request *http.Request)
 
 	//Reset the server hits count
 	cfg.fileserverHits.Store(0)
+	cfg.utilityAudit(request, auditAdminReset, utilityRequestCaller(request).UserID, uuid.Nil, nil)
 
 	//fmt.Println(cfg.fileserverHits.Load())
 	//Send a response that status is Ok
@@ -151,8 +340,11 @@ func (cfg *apiConfig) handlerPostChirps(response http.ResponseWriter, request *h
 	*/
 
 	type requestParameters struct {
-		Body   string    `json:"body"`
-		UserID uuid.UUID `json:"user_id"`
+		Body      string      `json:"body"`
+		UserID    uuid.UUID   `json:"user_id"`
+		InReplyTo *uuid.UUID  `json:"in_reply_to"`
+		QuoteOf   *uuid.UUID  `json:"quote_of"`
+		MediaIDs  []uuid.UUID `json:"media_ids"`
 	}
 
 	decoder := json.NewDecoder(request.Body)
@@ -178,11 +370,24 @@ func (cfg *apiConfig) handlerPostChirps(response http.ResponseWriter, request *h
 		return
 	}
 
-	requestParams.UserID, err = auth.ValidateJWT(userToken, cfg.SECRET)
+	requestParams.UserID, err = cfg.utilityAuthenticate(userToken, scopeChirpsWrite)
+	if err != nil {
+		utilityWriteAuthError(response, err, scopeChirpsWrite)
+		return
+	}
+
+	//Posting can be held back until the user has confirmed their email.
+	canPost, err := cfg.utilityCanPost(requestParams.UserID)
 	if err != nil {
 		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
-		response.WriteHeader(http.StatusUnauthorized)
-		response.Write([]byte("Unathorized: credentials invalid. Please login again."))
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not check user."))
+		return
+	}
+	if !canPost {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusForbidden)
+		response.Write([]byte("Forbidden: Please verify your email before posting."))
 		return
 	}
 
@@ -194,6 +399,14 @@ func (cfg *apiConfig) handlerPostChirps(response http.ResponseWriter, request *h
 		return
 	}
 
+	//Check the attachments, each one can only be attached once.
+	if len(requestParams.MediaIDs) > maxMediaPerChirp {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte(fmt.Sprintf("Bad Request: A Chirp can have at most %d attachments.", maxMediaPerChirp)))
+		return
+	}
+
 	//Apply our Profanity Filter
 	requestParams.Body = utilityProfanityFilter(requestParams.Body)
 
@@ -204,7 +417,88 @@ func (cfg *apiConfig) handlerPostChirps(response http.ResponseWriter, request *h
 		UserID: requestParams.UserID,
 	}
 
-	returnChirpParams, err := cfg.dbQueries.CreateChirp(context.Background(), createChirpParams)
+	//A reply or quote and the count on the chirp it points at are saved together.
+	tx, err := cfg.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not save Chirp."))
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	if requestParams.InReplyTo != nil {
+		//Lock the parent so it cannot be deleted out from under the reply.
+		parentChirp, err := qtx.GetChirpByIDForUpdate(context.Background(), *requestParams.InReplyTo)
+		if err == sql.ErrNoRows || (err == nil && parentChirp.DeletedAt.Valid) {
+			response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			response.WriteHeader(http.StatusNotFound)
+			response.Write([]byte("Not Found: Chirp being replied to does not exist."))
+			return
+		}
+		if err != nil {
+			response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			response.WriteHeader(http.StatusInternalServerError)
+			response.Write([]byte("Internal Server Error: Could not fetch Chirp being replied to."))
+			return
+		}
+
+		//A rechirp has nothing of its own to reply to.
+		if parentChirp.RechirpOf.Valid {
+			response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			response.WriteHeader(http.StatusBadRequest)
+			response.Write([]byte("Bad Request: Reply to the original Chirp, not the rechirp."))
+			return
+		}
+
+		//Every reply in a conversation points at the chirp that started it.
+		createChirpParams.ParentID = uuid.NullUUID{UUID: parentChirp.ID, Valid: true}
+		createChirpParams.RootID = parentChirp.RootID
+		if !parentChirp.RootID.Valid {
+			createChirpParams.RootID = uuid.NullUUID{UUID: parentChirp.ID, Valid: true}
+		}
+
+		err = qtx.IncrementReplyCount(context.Background(), parentChirp.ID)
+		if err != nil {
+			response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			response.WriteHeader(http.StatusInternalServerError)
+			response.Write([]byte("Internal Server Error: Could not save Chirp."))
+			return
+		}
+	}
+
+	if requestParams.QuoteOf != nil {
+		//Lock the quoted chirp too, so it cannot be deleted while we point at it.
+		quotedChirp, err := qtx.GetChirpByIDForUpdate(context.Background(), *requestParams.QuoteOf)
+		//Quoting a rechirp quotes the chirp it shares.
+		if err == nil && quotedChirp.RechirpOf.Valid {
+			quotedChirp, err = qtx.GetChirpByIDForUpdate(context.Background(), quotedChirp.RechirpOf.UUID)
+		}
+		if err == sql.ErrNoRows || (err == nil && quotedChirp.DeletedAt.Valid) {
+			response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			response.WriteHeader(http.StatusNotFound)
+			response.Write([]byte("Not Found: Chirp being quoted does not exist."))
+			return
+		}
+		if err != nil {
+			response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			response.WriteHeader(http.StatusInternalServerError)
+			response.Write([]byte("Internal Server Error: Could not fetch Chirp being quoted."))
+			return
+		}
+
+		createChirpParams.QuoteOf = uuid.NullUUID{UUID: quotedChirp.ID, Valid: true}
+		err = qtx.IncrementQuoteCount(context.Background(), quotedChirp.ID)
+		if err != nil {
+			response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			response.WriteHeader(http.StatusInternalServerError)
+			response.Write([]byte("Internal Server Error: Could not save Chirp."))
+			return
+		}
+	}
+
+	returnChirpParams, err := qtx.CreateChirp(context.Background(), createChirpParams)
 	if err != nil {
 		//fmt.Printf("CreateChirp error: %v\n", err)
 		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
@@ -213,16 +507,55 @@ func (cfg *apiConfig) handlerPostChirps(response http.ResponseWriter, request *h
 		return
 	}
 
+	//Pick out the hashtags, mentions and links now, so reads never have to parse.
+	err = utilitySaveChirpEntities(qtx, returnChirpParams)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not save Chirp."))
+		return
+	}
+
+	//Only the uploader's own media that is not on another chirp yet can be attached.
+	for position, mediaID := range requestParams.MediaIDs {
+		attached, err := qtx.AttachMedia(context.Background(), database.AttachMediaParams{
+			ChirpID:  uuid.NullUUID{UUID: returnChirpParams.ID, Valid: true},
+			Position: sql.NullInt32{Int32: int32(position), Valid: true},
+			ID:       mediaID,
+			UserID:   requestParams.UserID,
+		})
+		if err != nil {
+			response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			response.WriteHeader(http.StatusInternalServerError)
+			response.Write([]byte("Internal Server Error: Could not save Chirp."))
+			return
+		}
+		if attached != 1 {
+			response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			response.WriteHeader(http.StatusBadRequest)
+			response.Write([]byte("Bad Request: Media " + mediaID.String() + " does not exist or is already attached."))
+			return
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not save Chirp."))
+		return
+	}
+
 	//Again, we are doing a explicit copy to our response struct from the response from the query.
-	responseParams := chirpsResponse{
-		Id:        returnChirpParams.ID,
-		CreatedAt: returnChirpParams.CreatedAt,
-		UpdatedAt: returnChirpParams.UpdatedAt,
-		Body:      returnChirpParams.Body,
-		UserId:    returnChirpParams.UserID,
+	responseList, err := cfg.utilityChirpListResponse(request, []database.Chirp{returnChirpParams})
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not create response."))
+		return
 	}
 
-	dataMarshalled, err := json.Marshal(responseParams)
+	dataMarshalled, err := json.Marshal(responseList[0])
 	if err != nil {
 		//There was an error in the decoding, so we do a error response, we do not use params here.
 		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
@@ -262,19 +595,32 @@ func (cfg *apiConfig) handlerGetChirps(response http.ResponseWriter, request *ht
 	sortOrder = strings.ToLower(request.URL.Query().Get("sort"))
 	//Only desc does anything, missing or bad data just defaults to asc in the if-then below.
 
-	//Use either the query for all chirps or the query for just the chirps of the user id
-	if userID == "" {
-		if sortOrder == desc {
-			chirpList, err = cfg.dbQueries.GetAllChirpsDesc(context.Background())
-		} else {
-			chirpList, err = cfg.dbQueries.GetAllChirpsAsc(context.Background())
-		}
+	//Work out the page size and where the page starts.
+	page, err := utilityParsePageRequest(request.URL.Query())
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: " + err.Error() + "."))
+		return
+	}
+
+	authorID := uuid.NullUUID{UUID: uuidUserID, Valid: userID != ""}
+
+	//Going forward in an ascending feed or back in a descending feed both walk up the index, otherwise we walk down.
+	if (sortOrder == desc) == page.pagingBack {
+		chirpList, err = cfg.dbQueries.GetChirpsPageAsc(context.Background(), database.GetChirpsPageAscParams{
+			UserID:          authorID,
+			CursorCreatedAt: page.cursorCreatedAt,
+			CursorID:        page.cursorID,
+			PageLimit:       page.limit + 1,
+		})
 	} else {
-		if sortOrder == desc {
-			chirpList, err = cfg.dbQueries.GetChirpsByUserIDdesc(context.Background(), uuidUserID)
-		} else {
-			chirpList, err = cfg.dbQueries.GetChirpsByUserIDasc(context.Background(), uuidUserID)
-		}
+		chirpList, err = cfg.dbQueries.GetChirpsPageDesc(context.Background(), database.GetChirpsPageDescParams{
+			UserID:          authorID,
+			CursorCreatedAt: page.cursorCreatedAt,
+			CursorID:        page.cursorID,
+			PageLimit:       page.limit + 1,
+		})
 	}
 
 	//Check for an error in the query
@@ -285,6 +631,8 @@ func (cfg *apiConfig) handlerGetChirps(response http.ResponseWriter, request *ht
 		return
 	}
 
+	chirpList, nextCursor, prevCursor := utilityPageCursors(chirpList, page, utilityChirpKey)
+
 	//Check if we have no tweets to show. This also works for a user id that does not exist.
 	if len(chirpList) == 0 {
 		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
@@ -294,19 +642,22 @@ func (cfg *apiConfig) handlerGetChirps(response http.ResponseWriter, request *ht
 	}
 
 	//Go through the return chripList and convert it to our JSON structure
-	chripListResponse := make([]chirpsResponse, 0, len(chirpList))
-	for i := 0; i < len(chirpList); i++ {
-		chripListResponse = append(chripListResponse, chirpsResponse{
-			Id:        chirpList[i].ID,
-			CreatedAt: chirpList[i].CreatedAt,
-			UpdatedAt: chirpList[i].UpdatedAt,
-			Body:      chirpList[i].Body,
-			UserId:    chirpList[i].UserID,
-		})
+	chripListResponse, err := cfg.utilityChirpListResponse(request, chirpList)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not retrieve Chirps."))
+		return
 	}
 
-	//chripListResponse should now be an array of JSON compatible items. Marshall and send
-	chripListMarshalled, err := json.Marshal(chripListResponse)
+	pageResponse := chirpsPageResponse{
+		Chirps:     chripListResponse,
+		NextCursor: nextCursor,
+		PrevCursor: prevCursor,
+	}
+
+	//pageResponse should now be JSON compatible. Marshall and send
+	chripListMarshalled, err := json.Marshal(pageResponse)
 	if err != nil {
 		//There was an error in the decoding, so we do a error response, we do not use params here.
 		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
@@ -348,13 +699,23 @@ func (cfg *apiConfig) handlerGetChirpByID(response http.ResponseWriter, request
 		return
 	}
 
-	chripMarshalled, err := json.Marshal(chirpsResponse{
-		Id:        chirp.ID,
-		CreatedAt: chirp.CreatedAt,
-		UpdatedAt: chirp.UpdatedAt,
-		Body:      chirp.Body,
-		UserId:    chirp.UserID,
-	})
+	//A deleted chirp is only kept as a tombstone for its thread.
+	if chirp.DeletedAt.Valid {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusNotFound)
+		response.Write([]byte("Error! Chirp not found."))
+		return
+	}
+
+	chirpResponseList, err := cfg.utilityChirpListResponse(request, []database.Chirp{chirp})
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Error! Server error fetching Chirp."))
+		return
+	}
+
+	chripMarshalled, err := json.Marshal(chirpResponseList[0])
 
 	if err != nil {
 		//There was an error in the decoding, so we do an error response, we do not use params here.
@@ -370,6 +731,54 @@ func (cfg *apiConfig) handlerGetChirpByID(response http.ResponseWriter, request
 	response.Write(chripMarshalled)
 }
 
+// Removes a chirp and keeps the counts on the chirps around it right.
+// Run it inside a transaction that holds the lock on the chirp.
+func utilityDeleteChirp(qtx *database.Queries, chirp database.Chirp) error {
+	//Rechirps are only pointers, so they go along with the chirp they share.
+	err := qtx.DeleteRechirpsOf(context.Background(), uuid.NullUUID{UUID: chirp.ID, Valid: true})
+	if err != nil {
+		return err
+	}
+
+	if chirp.ReplyCount > 0 || chirp.QuoteCount > 0 {
+		//Replies or quotes still point at this chirp, so leave a tombstone to hold them together.
+		err = qtx.TombstoneChirp(context.Background(), chirp.ID)
+		if err != nil {
+			return err
+		}
+		err = qtx.DeleteChirpEntities(context.Background(), chirp.ID)
+		if err != nil {
+			return err
+		}
+		return qtx.DeleteChirpRevisions(context.Background(), chirp.ID)
+	}
+
+	//Delete the tweet, and take it off the counts of the chirps it pointed at.
+	err = qtx.DeleteChirpByID(context.Background(), chirp.ID)
+	if err != nil {
+		return err
+	}
+	if chirp.ParentID.Valid {
+		err = qtx.DecrementReplyCount(context.Background(), chirp.ParentID.UUID)
+		if err != nil {
+			return err
+		}
+	}
+	if chirp.RechirpOf.Valid {
+		err = qtx.DecrementRechirpCount(context.Background(), chirp.RechirpOf.UUID)
+		if err != nil {
+			return err
+		}
+	}
+	if chirp.QuoteOf.Valid {
+		err = qtx.DecrementQuoteCount(context.Background(), chirp.QuoteOf.UUID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (cfg *apiConfig) handlerDeleteChirpByID(response http.ResponseWriter, request *http.Request) {
 	//Validate credentials sent.
 	//Get the user token from the request.
@@ -381,11 +790,9 @@ func (cfg *apiConfig) handlerDeleteChirpByID(response http.ResponseWriter, reque
 		return
 	}
 
-	userID, err := auth.ValidateJWT(userToken, cfg.SECRET)
+	caller, err := cfg.utilityAuthenticateCaller(userToken, scopeChirpsWrite)
 	if err != nil {
-		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
-		response.WriteHeader(http.StatusUnauthorized)
-		response.Write([]byte("Unathorized: credentials invalid. Please login again."))
+		utilityWriteAuthError(response, err, scopeChirpsWrite)
 		return
 	}
 
@@ -399,7 +806,18 @@ func (cfg *apiConfig) handlerDeleteChirpByID(response http.ResponseWriter, reque
 		return
 	}
 
-	chirp, err := cfg.dbQueries.GetChirpByID(context.Background(), chirpID) //Chirp, error
+	//The chirp is locked so nobody can reply to it while we decide how to delete it.
+	tx, err := cfg.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Error! Server error deleteing Chirp."))
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	chirp, err := qtx.GetChirpByIDForUpdate(context.Background(), chirpID) //Chirp, error
 	if err != nil {
 		if err == sql.ErrNoRows {
 			// No chirp found with this ID
@@ -415,84 +833,58 @@ func (cfg *apiConfig) handlerDeleteChirpByID(response http.ResponseWriter, reque
 		return
 	}
 
-	//Verify the current user is the tweet to delete author.
-	if chirp.UserID != userID {
+	//Already deleted, the tombstone is all that is left.
+	if chirp.DeletedAt.Valid {
 		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
-		response.WriteHeader(http.StatusForbidden)
-		response.Write([]byte("Forbidden! Only author can delete chirps."))
+		response.WriteHeader(http.StatusNotFound)
+		response.Write([]byte("Error! Chirp not found."))
 		return
 	}
 
-	//Delete the tweet.
-	err = cfg.dbQueries.DeleteChirpByID(context.Background(), chirp.ID)
-	if err != nil {
+	//Verify the current user is the tweet to delete author, moderators and admins can delete any chirp.
+	if chirp.UserID.UUID != caller.UserID && !caller.hasRole(roleModerator) {
 		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
-		response.WriteHeader(http.StatusInternalServerError)
-		response.Write([]byte("Error! Server error deleteing Chirp."))
+		response.WriteHeader(http.StatusForbidden)
+		response.Write([]byte("Forbidden! Only author or a moderator can delete chirps."))
 		return
 	}
 
-	//Success
-	response.WriteHeader(http.StatusNoContent)
-	return
-}
-
-func (cfg *apiConfig) createUser(response http.ResponseWriter, request *http.Request) {
-	//Decode the request, get the email of the user we are creating.
-	decoder := json.NewDecoder(request.Body)
-	requestBody := userRequest{}
-	err := decoder.Decode(&requestBody)
-	if err != nil {
-		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
-		response.WriteHeader(http.StatusBadRequest)
-		response.Write([]byte("Bad Request: Did not understand request."))
-		return
+	//Attachments go even when a tombstone stays behind.
+	storageKeys, err := qtx.DeleteChirpMedia(context.Background(), uuid.NullUUID{UUID: chirp.ID, Valid: true})
+	if err == nil {
+		err = utilityDeleteChirp(qtx, chirp)
 	}
-
-	//Hash the password.
-	requestBody.Password, err = auth.HashPassword(requestBody.Password)
 	if err != nil {
 		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
-		response.WriteHeader(http.StatusBadRequest)
-		response.Write([]byte("Bad Request: Problem with password."))
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Error! Server error deleteing Chirp."))
 		return
 	}
 
-	returned, err := cfg.dbQueries.CreateUser(context.Background(), database.CreateUserParams{
-		HashedPassword: requestBody.Password,
-		Email:          requestBody.Email,
-	})
+	err = tx.Commit()
 	if err != nil {
 		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
 		response.WriteHeader(http.StatusInternalServerError)
-		response.Write([]byte("Internal Server Error: Failed to create new user."))
+		response.Write([]byte("Error! Server error deleteing Chirp."))
 		return
 	}
 
-	//fmt.Printf("createUser returned.ID: %v\n", returned.ID)
+	cfg.utilityAudit(request, auditChirpDeleted, caller.UserID, chirp.UserID.UUID, map[string]any{"chirp_id": chirp.ID})
 
-	dataMarshalled, err := json.Marshal(userResponse{
-		Id:          returned.ID,
-		CreatedAt:   returned.CreatedAt,
-		UpdatedAt:   returned.UpdatedAt,
-		Email:       returned.Email,
-		IsChirpyRed: returned.IsChirpyRed,
-	})
-	//fmt.Printf("createUser dataMarshalled: %v\n", dataMarshalled)
-	if err != nil {
-		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
-		response.WriteHeader(http.StatusInternalServerError)
-		response.Write([]byte("Internal Server Error: Failed create response."))
-		return
+	//The files are only removed once the rows are gone for good. A file we fail to remove is just wasted space.
+	for _, storageKey := range storageKeys {
+		err = cfg.mediaStore.Delete(storageKey)
+		if err != nil {
+			fmt.Printf("Error deleting media file %v: %v\n", storageKey, err)
+		}
 	}
 
-	response.Header().Set("Content-Type", "application/json")
-	response.WriteHeader(http.StatusCreated)
-	response.Write(dataMarshalled)
+	//Success
+	response.WriteHeader(http.StatusNoContent)
 	return
 }
 
-func (cfg *apiConfig) handlerUpdateUser(response http.ResponseWriter, request *http.Request) {
+func (cfg *apiConfig) handlerUpdateChirp(response http.ResponseWriter, request *http.Request) {
 	//Validate credentials sent.
 	//Get the user token from the request.
 	userToken, err := auth.GetBearerToken(request.Header)
@@ -503,20 +895,364 @@ func (cfg *apiConfig) handlerUpdateUser(response http.ResponseWriter, request *h
 		return
 	}
 
-	userID, err := auth.ValidateJWT(userToken, cfg.SECRET)
+	userID, err := cfg.utilityAuthenticate(userToken, scopeChirpsWrite)
 	if err != nil {
-		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
-		response.WriteHeader(http.StatusUnauthorized)
-		response.Write([]byte("Unathorized: credentials invalid. Please login again."))
+		utilityWriteAuthError(response, err, scopeChirpsWrite)
 		return
 	}
 
-	//We have a valid user.
-	type requestParameters struct {
-		Password string `json:"password"`
-		Email    string `json:"email"`
-	}
-
+	//Extract the Chirp ID from the URL of the request
+	chirpID, err := uuid.Parse(request.PathValue("chirpID"))
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Error! Invalid Chirp ID."))
+		return
+	}
+
+	type requestParameters struct {
+		Body string `json:"body"`
+	}
+
+	decoder := json.NewDecoder(request.Body)
+	requestParams := requestParameters{}
+	err = decoder.Decode(&requestParams)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: Could not decode JSON in request."))
+		return
+	}
+
+	//The new body gets the same checks as a new chirp.
+	if len(requestParams.Body) > 140 {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: Chirp is longer then 140 characters."))
+		return
+	}
+	requestParams.Body = utilityProfanityFilter(requestParams.Body)
+
+	//Saving the old body and the new one has to happen together, and the row is locked so two edits cannot both save the same old body.
+	tx, err := cfg.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Error! Server error updating Chirp."))
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	chirp, err := qtx.GetChirpByIDForUpdate(context.Background(), chirpID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			// No chirp found with this ID
+			response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			response.WriteHeader(http.StatusNotFound)
+			response.Write([]byte("Error! Chirp not found."))
+			return
+		}
+		// Some other error occurred
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Error! Server error fetching Chirp."))
+		return
+	}
+
+	//A deleted chirp cannot be edited.
+	if chirp.DeletedAt.Valid {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusNotFound)
+		response.Write([]byte("Error! Chirp not found."))
+		return
+	}
+
+	//A rechirp has no body of its own to edit.
+	if chirp.RechirpOf.Valid {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: Rechirps cannot be edited."))
+		return
+	}
+
+	//Verify the current user is the author of the chirp to edit.
+	if chirp.UserID.UUID != userID {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusForbidden)
+		response.Write([]byte("Forbidden! Only author can edit chirps."))
+		return
+	}
+
+	//Nothing changed, so there is no revision to keep. Just send back the chirp as it is.
+	if chirp.Body != requestParams.Body {
+		//The old body was written when the chirp was last updated.
+		err = qtx.CreateChirpRevision(context.Background(), database.CreateChirpRevisionParams{
+			CreatedAt: chirp.UpdatedAt,
+			Body:      chirp.Body,
+			ChirpID:   chirp.ID,
+		})
+		if err != nil {
+			response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			response.WriteHeader(http.StatusInternalServerError)
+			response.Write([]byte("Error! Server error saving Chirp revision."))
+			return
+		}
+
+		chirp, err = qtx.UpdateChirp(context.Background(), database.UpdateChirpParams{
+			Body: requestParams.Body,
+			ID:   chirp.ID,
+		})
+		if err != nil {
+			response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			response.WriteHeader(http.StatusInternalServerError)
+			response.Write([]byte("Error! Server error updating Chirp."))
+			return
+		}
+
+		//The old entities point into the old body, so parse the new one from scratch.
+		err = qtx.DeleteChirpEntities(context.Background(), chirp.ID)
+		if err == nil {
+			err = utilitySaveChirpEntities(qtx, chirp)
+		}
+		if err != nil {
+			response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			response.WriteHeader(http.StatusInternalServerError)
+			response.Write([]byte("Error! Server error updating Chirp."))
+			return
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Error! Server error updating Chirp."))
+		return
+	}
+
+	chirpResponseList, err := cfg.utilityChirpListResponse(request, []database.Chirp{chirp})
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Error! Server error fetching Chirp."))
+		return
+	}
+
+	chripMarshalled, err := json.Marshal(chirpResponseList[0])
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not process Chirp to JSON."))
+		return
+	}
+
+	//Success
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusOK)
+	response.Write(chripMarshalled)
+}
+
+func (cfg *apiConfig) handlerGetChirpRevisions(response http.ResponseWriter, request *http.Request) {
+	//Extract the Chirp ID
+	chirpID, err := uuid.Parse(request.PathValue("chirpID"))
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Error! Invalid Chirp ID."))
+		return
+	}
+
+	//Make sure the chirp exists, a chirp that was never edited just has no revisions.
+	chirp, err := cfg.dbQueries.GetChirpByID(context.Background(), chirpID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			response.WriteHeader(http.StatusNotFound)
+			response.Write([]byte("Error! Chirp not found."))
+			return
+		}
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Error! Server error fetching Chirp."))
+		return
+	}
+
+	//The revisions went with the body when the chirp was deleted.
+	if chirp.DeletedAt.Valid {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusNotFound)
+		response.Write([]byte("Error! Chirp not found."))
+		return
+	}
+
+	revisionList, err := cfg.dbQueries.GetChirpRevisions(context.Background(), chirpID)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not retrieve Chirp revisions."))
+		return
+	}
+
+	revisionListResponse := make([]chirpRevisionResponse, 0, len(revisionList))
+	for i := 0; i < len(revisionList); i++ {
+		revisionListResponse = append(revisionListResponse, chirpRevisionResponse{
+			Id:         revisionList[i].ID,
+			CreatedAt:  revisionList[i].CreatedAt,
+			ReplacedAt: revisionList[i].ReplacedAt,
+			Body:       revisionList[i].Body,
+			ChirpId:    revisionList[i].ChirpID,
+		})
+	}
+
+	revisionListMarshalled, err := json.Marshal(revisionListResponse)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not process Chirp revisions to JSON."))
+		return
+	}
+
+	//Success
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusOK)
+	response.Write(revisionListMarshalled)
+}
+
+func (cfg *apiConfig) createUser(response http.ResponseWriter, request *http.Request) {
+	//Decode the request, get the email of the user we are creating.
+	decoder := json.NewDecoder(request.Body)
+	requestBody := userRequest{}
+	err := decoder.Decode(&requestBody)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: Did not understand request."))
+		return
+	}
+
+	if !utilityValidEmail(requestBody.Email) {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: Invalid email address."))
+		return
+	}
+
+	err = cfg.passwordPolicy.Check(requestBody.Password)
+	if err != nil {
+		cfg.utilityWritePasswordRejected(response, err)
+		return
+	}
+
+	//Hash the password.
+	requestBody.Password, err = auth.HashPassword(requestBody.Password, cfg.passwordParams)
+	if err == auth.ErrHashingBusy {
+		utilityWriteHashingBusy(response)
+		return
+	}
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: Problem with password."))
+		return
+	}
+
+	//A handle is optional when signing up, without one the user gets a random one.
+	requestBody.Handle = strings.TrimPrefix(requestBody.Handle, "@")
+	if requestBody.Handle == "" {
+		requestBody.Handle = utilityGenerateHandle()
+	}
+	if !entities.ValidHandle(requestBody.Handle) {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: handle must be 3 to 30 letters, numbers or underscores."))
+		return
+	}
+
+	returned, err := cfg.dbQueries.CreateUser(context.Background(), database.CreateUserParams{
+		HashedPassword: requestBody.Password,
+		Email:          requestBody.Email,
+		Handle:         requestBody.Handle,
+	})
+	if utilityIsUniqueViolation(err) {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusConflict)
+		response.Write([]byte("Conflict: That email or handle is already in use."))
+		return
+	}
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Failed to create new user."))
+		return
+	}
+
+	//fmt.Printf("createUser returned.ID: %v\n", returned.ID)
+
+	//The account works straight away, the email is confirmed whenever the user follows the link.
+	verificationToken, err := utilityCreateEmailVerification(cfg.dbQueries, returned.ID, returned.Email)
+	if err != nil {
+		fmt.Printf("Error creating email verification for %v: %v\n", returned.ID, err)
+	} else {
+		cfg.utilitySendEmailVerification(returned.Email, verificationToken)
+	}
+
+	dataMarshalled, err := json.Marshal(userResponse{
+		Id:          returned.ID,
+		CreatedAt:   returned.CreatedAt,
+		UpdatedAt:   returned.UpdatedAt,
+		Email:       returned.Email,
+		IsChirpyRed: returned.IsChirpyRed,
+		Handle:      returned.Handle,
+		DisplayName: returned.DisplayName,
+		Bio:         returned.Bio,
+		Role:        roleUser,
+	})
+	//fmt.Printf("createUser dataMarshalled: %v\n", dataMarshalled)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Failed create response."))
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusCreated)
+	response.Write(dataMarshalled)
+	return
+}
+
+func (cfg *apiConfig) handlerUpdateUser(response http.ResponseWriter, request *http.Request) {
+	//Validate credentials sent.
+	//Get the user token from the request.
+	userToken, err := auth.GetBearerToken(request.Header)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusUnauthorized)
+		response.Write([]byte("Unathorized: Please login first."))
+		return
+	}
+
+	caller, err := cfg.utilityAuthenticateCaller(userToken, scopeProfileWrite)
+	if err != nil {
+		utilityWriteAuthError(response, err, scopeProfileWrite)
+		return
+	}
+	userID := caller.UserID
+	sessionID := caller.SessionID
+
+	//We have a valid user.
+	//Every field is optional, anything left out keeps its current value.
+	type requestParameters struct {
+		Password      string     `json:"password"`
+		Email         string     `json:"email"`
+		Handle        *string    `json:"handle"`
+		DisplayName   *string    `json:"display_name"`
+		Bio           *string    `json:"bio"`
+		AvatarMediaID *uuid.UUID `json:"avatar_media_id"`
+		RemoveAvatar  bool       `json:"remove_avatar"`
+	}
+
 	decoder := json.NewDecoder(request.Body)
 	requestParams := requestParameters{}
 	err = decoder.Decode(&requestParams)
@@ -527,16 +1263,93 @@ func (cfg *apiConfig) handlerUpdateUser(response http.ResponseWriter, request *h
 		return
 	}
 
-	hashedPassword, err := auth.HashPassword(requestParams.Password)
+	//A token can change the profile, but taking over the account with it would be too easy.
+	if !caller.isLogin() && (requestParams.Password != "" || requestParams.Email != "") {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusForbidden)
+		response.Write([]byte("Forbidden: Password and email can only be changed when logged in."))
+		return
+	}
+	if requestParams.Password != "" {
+		err = cfg.passwordPolicy.Check(requestParams.Password)
+		if err != nil {
+			cfg.utilityWritePasswordRejected(response, err)
+			return
+		}
+	}
+
+	tx, err := cfg.db.BeginTx(context.Background(), nil)
 	if err != nil {
 		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
 		response.WriteHeader(http.StatusInternalServerError)
-		response.Write([]byte("Internal Server Error: Error processing email/password."))
+		response.Write([]byte("Internal Server Error: Error unable to update user."))
 		return
 	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
 
-	err = cfg.dbQueries.UpdateUser(context.Background(), database.UpdateUserParams{
-		Email:          requestParams.Email,
+	userData, err := qtx.GetUserByID(context.Background(), userID)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusUnauthorized)
+		response.Write([]byte("Unathorized: credentials invalid. Please login again."))
+		return
+	}
+
+	//A new email is not used until it is confirmed, until then the user keeps their current one.
+	verificationToken := ""
+	if requestParams.Email != "" && requestParams.Email != userData.Email {
+		if !utilityValidEmail(requestParams.Email) {
+			response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			response.WriteHeader(http.StatusBadRequest)
+			response.Write([]byte("Bad Request: Invalid email address."))
+			return
+		}
+		//Taken emails are not turned away here, that would tell anyone logged in who has an account.
+		//The change just never goes through, as confirming it fails.
+		err = qtx.SetPendingEmail(context.Background(), database.SetPendingEmailParams{
+			PendingEmail: sql.NullString{String: requestParams.Email, Valid: true},
+			ID:           userID,
+		})
+		if err == nil {
+			verificationToken, err = utilityCreateEmailVerification(qtx, userID, requestParams.Email)
+		}
+		if err != nil {
+			response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			response.WriteHeader(http.StatusInternalServerError)
+			response.Write([]byte("Internal Server Error: Error unable to update email/password."))
+			return
+		}
+	} else if requestParams.Email == userData.Email && userData.PendingEmail.Valid {
+		//Asking for the current email back calls off the change.
+		err = qtx.SetPendingEmail(context.Background(), database.SetPendingEmailParams{
+			PendingEmail: sql.NullString{},
+			ID:           userID,
+		})
+		if err != nil {
+			response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			response.WriteHeader(http.StatusInternalServerError)
+			response.Write([]byte("Internal Server Error: Error unable to update email/password."))
+			return
+		}
+	}
+	hashedPassword := userData.HashedPassword
+	if requestParams.Password != "" {
+		hashedPassword, err = auth.HashPassword(requestParams.Password, cfg.passwordParams)
+		if err == auth.ErrHashingBusy {
+			utilityWriteHashingBusy(response)
+			return
+		}
+		if err != nil {
+			response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			response.WriteHeader(http.StatusInternalServerError)
+			response.Write([]byte("Internal Server Error: Error processing email/password."))
+			return
+		}
+	}
+
+	err = qtx.UpdateUser(context.Background(), database.UpdateUserParams{
+		Email:          userData.Email,
 		HashedPassword: hashedPassword,
 		ID:             userID,
 	})
@@ -547,20 +1360,99 @@ func (cfg *apiConfig) handlerUpdateUser(response http.ResponseWriter, request *h
 		return
 	}
 
-	userData, err := cfg.dbQueries.GetUser(context.Background(), requestParams.Email)
+	//A new password logs out everywhere else and revokes every token and app, in case the old one was how someone got in.
+	if requestParams.Password != "" {
+		err = utilityRevokeAllAccess(qtx, userID, sessionID)
+		if err != nil {
+			response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			response.WriteHeader(http.StatusInternalServerError)
+			response.Write([]byte("Internal Server Error: Error unable to update email/password."))
+			return
+		}
+	}
+
+	//Now the profile.
+	profileParams := database.UpdateUserProfileParams{
+		Handle:        userData.Handle,
+		DisplayName:   userData.DisplayName,
+		Bio:           userData.Bio,
+		AvatarMediaID: userData.AvatarMediaID,
+		ID:            userID,
+	}
+	if requestParams.Handle != nil {
+		profileParams.Handle = strings.TrimPrefix(*requestParams.Handle, "@")
+	}
+	if requestParams.DisplayName != nil {
+		profileParams.DisplayName = strings.TrimSpace(*requestParams.DisplayName)
+	}
+	if requestParams.Bio != nil {
+		profileParams.Bio = strings.TrimSpace(*requestParams.Bio)
+	}
+	err = utilityValidateProfile(profileParams.Handle, profileParams.DisplayName, profileParams.Bio)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: " + err.Error() + "."))
+		return
+	}
+
+	if requestParams.RemoveAvatar {
+		profileParams.AvatarMediaID = uuid.NullUUID{}
+	} else if requestParams.AvatarMediaID != nil {
+		//An avatar must be the user's own upload, and not one already on a chirp.
+		avatar, err := qtx.GetMediaByID(context.Background(), *requestParams.AvatarMediaID)
+		if err != nil && err != sql.ErrNoRows {
+			response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			response.WriteHeader(http.StatusInternalServerError)
+			response.Write([]byte("Internal Server Error: Error unable to update user."))
+			return
+		}
+		if err == sql.ErrNoRows || avatar.UserID != userID || avatar.ChirpID.Valid {
+			response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			response.WriteHeader(http.StatusBadRequest)
+			response.Write([]byte("Bad Request: Avatar must be one of your uploads that is not on a Chirp."))
+			return
+		}
+		profileParams.AvatarMediaID = uuid.NullUUID{UUID: avatar.ID, Valid: true}
+	}
+
+	userData, err = qtx.UpdateUserProfile(context.Background(), profileParams)
+	if utilityIsUniqueViolation(err) {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusConflict)
+		response.Write([]byte("Conflict: That handle is taken."))
+		return
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
 	if err != nil {
 		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
 		response.WriteHeader(http.StatusInternalServerError)
-		response.Write([]byte("Internal Server Error: Error unable retrieve updated user data."))
+		response.Write([]byte("Internal Server Error: Error unable to update user."))
 		return
 	}
+	if verificationToken != "" {
+		cfg.utilitySendEmailVerification(requestParams.Email, verificationToken)
+		cfg.utilityAudit(request, auditEmailChangeRequested, userID, userID, map[string]any{"email_hash": cfg.utilityAuditEmail(userData.Email), "new_email_hash": cfg.utilityAuditEmail(requestParams.Email)})
+	}
+	if requestParams.Password != "" {
+		cfg.utilityAudit(request, auditPasswordChanged, userID, userID, map[string]any{"session_id": sessionID})
+	}
 
 	dataMarshalled, err := json.Marshal(userResponse{
-		Id:          userData.ID,
-		CreatedAt:   userData.CreatedAt,
-		UpdatedAt:   userData.UpdatedAt,
-		Email:       userData.Email,
-		IsChirpyRed: userData.IsChirpyRed,
+		Id:            userData.ID,
+		CreatedAt:     userData.CreatedAt,
+		UpdatedAt:     userData.UpdatedAt,
+		Email:         userData.Email,
+		IsChirpyRed:   userData.IsChirpyRed,
+		Handle:        userData.Handle,
+		DisplayName:   userData.DisplayName,
+		Bio:           userData.Bio,
+		AvatarMediaId: utilityNullUUID(userData.AvatarMediaID),
+		EmailVerified: userData.EmailVerifiedAt.Valid,
+		PendingEmail:  userData.PendingEmail.String,
+		Role:          userData.Role,
 	})
 	if err != nil {
 		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
@@ -588,9 +1480,36 @@ func (cfg *apiConfig) handlerLogin(response http.ResponseWriter, request *http.R
 		return
 	}
 
+	//Too many failures for this account or from this address and no password is even checked until the lockout ends.
+	reserved, retryAfter, err := cfg.utilityReserveLoginAttempt(request, requestBody.Email)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Unable to login."))
+		return
+	}
+	if retryAfter > 0 {
+		utilityWriteTooManyLogins(response, retryAfter)
+		return
+	}
+
 	//Get the user information, including the hashed_password.
 	userData, err := cfg.dbQueries.GetUser(context.Background(), requestBody.Email)
-	if err != nil {
+	if err != nil && err != sql.ErrNoRows {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Unable to login."))
+		return
+	}
+	if err == sql.ErrNoRows {
+		//Check the password against nothing, so this takes as long as a wrong password for a real account.
+		err = auth.CheckDummyPasswordHash(requestBody.Password, cfg.passwordParams)
+		if err == auth.ErrHashingBusy {
+			cfg.utilityReleaseThrottles(reserved)
+			utilityWriteHashingBusy(response)
+			return
+		}
+		cfg.utilityRecordLoginFailure(request, requestBody.Email, uuid.NullUUID{}, failedLoginPassword)
 		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
 		response.WriteHeader(http.StatusUnauthorized)
 		response.Write([]byte("Incorrect email or password"))
@@ -598,16 +1517,93 @@ func (cfg *apiConfig) handlerLogin(response http.ResponseWriter, request *http.R
 	}
 
 	//Validate the password.
-	err = auth.CheckPasswordHash(requestBody.Password, userData.HashedPassword)
+	outdated, err := auth.CheckPasswordHash(requestBody.Password, userData.HashedPassword, cfg.passwordParams)
+	if err == auth.ErrHashingBusy {
+		cfg.utilityReleaseThrottles(reserved)
+		utilityWriteHashingBusy(response)
+		return
+	}
 	if err != nil {
+		cfg.utilityRecordLoginFailure(request, requestBody.Email, uuid.NullUUID{UUID: userData.ID, Valid: true}, failedLoginPassword)
 		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
 		response.WriteHeader(http.StatusUnauthorized)
 		response.Write([]byte("Incorrect email or password"))
 		return
 	}
+	cfg.utilityReleaseThrottles(reserved)
+	if outdated {
+		cfg.utilityRehashPassword(userData, requestBody.Password)
+	}
+
+	//Only said once the password is right, so it does not give away that an account exists.
+	if userData.SuspendedAt.Valid {
+		utilityWriteSuspended(response)
+		return
+	}
+
+	//Users with two-factor turned on get a challenge to answer at /api/login/2fa instead of their tokens.
+	totpCredential, err := cfg.dbQueries.GetTOTPCredential(context.Background(), userData.ID)
+	if err != nil && err != sql.ErrNoRows {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Unable to login."))
+		return
+	}
+	if err == nil && totpCredential.ConfirmedAt.Valid {
+		cfg.utilityStartLoginChallenge(response, userData)
+		return
+	}
 
+	cfg.utilityCompleteLogin(response, request, userData)
+}
+
+// Password hashing is held back when too many are being made at once, the client can try again shortly.
+func utilityWriteHashingBusy(response http.ResponseWriter) {
+	response.Header().Set("Retry-After", "1")
+	response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+	response.WriteHeader(http.StatusServiceUnavailable)
+	response.Write([]byte("Service Unavailable: The server is busy, please try again shortly."))
+}
+
+// Tells the user why their new password was turned away by the password policy.
+func (cfg *apiConfig) utilityWritePasswordRejected(response http.ResponseWriter, err error) {
+	message := "Password is not allowed."
+	switch err {
+	case auth.ErrPasswordTooShort:
+		message = fmt.Sprintf("Password must be at least %d characters.", cfg.passwordPolicy.MinLength)
+	case auth.ErrPasswordTooLong:
+		message = fmt.Sprintf("Password must be at most %d characters.", auth.MaxPasswordLength)
+	case auth.ErrPasswordBreached:
+		message = "This password has appeared in a data breach, please choose another."
+	}
+	response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+	response.WriteHeader(http.StatusBadRequest)
+	response.Write([]byte("Bad Request: " + message))
+}
+
+// The password is only ever in hand at login, so that is when a hash made with older settings is replaced.
+// The login goes ahead either way, so failing to do it is only logged.
+func (cfg *apiConfig) utilityRehashPassword(userData database.User, password string) {
+	hashedPassword, err := auth.HashPassword(password, cfg.passwordParams)
+	if err == nil {
+		//Only if the hash is still the one checked, a password changed in the meantime is left alone.
+		err = cfg.dbQueries.RehashUserPassword(context.Background(), database.RehashUserPasswordParams{
+			NewHashedPassword: hashedPassword,
+			ID:                userData.ID,
+			OldHashedPassword: userData.HashedPassword,
+		})
+	}
+	if err != nil {
+		fmt.Printf("Error upgrading password hash for user %v: %v\n", userData.ID, err)
+	}
+}
+
+// Hands out the access and refresh tokens once a user has proven who they are, starting a new session.
+func (cfg *apiConfig) utilityCompleteLogin(response http.ResponseWriter, request *http.Request, userData database.User) {
 	//The user was verified so we now create and pass them a token.
-	token, err := auth.MakeJWT(userData.ID, cfg.SECRET)
+	//Each login starts a new session, a family of refresh tokens that every token refreshed from this one joins.
+	sessionID := uuid.New()
+	token, err := auth.MakeJWT(userData.ID, sessionID, userData.Role, cfg.jwtKeys)
 	if err != nil {
 		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
 		response.WriteHeader(http.StatusInternalServerError)
@@ -629,8 +1625,11 @@ func (cfg *apiConfig) handlerLogin(response http.ResponseWriter, request *http.R
 		Token:     refreshToken,
 		CreatedAt: time.Now().UTC(),
 		UpdatedAt: time.Now().UTC(),
-		ExpiresAt: time.Now().Add(time.Hour * 24 * 60).UTC(),
+		ExpiresAt: time.Now().Add(refreshTokenDuration).UTC(),
 		UserID:    userData.ID,
+		FamilyID:  sessionID,
+		UserAgent: request.UserAgent(),
+		IpAddress: utilityClientIP(request),
 	})
 	if err != nil {
 		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
@@ -640,13 +1639,20 @@ func (cfg *apiConfig) handlerLogin(response http.ResponseWriter, request *http.R
 	}
 
 	dataMarshalled, err := json.Marshal(userResponse{
-		Id:           userData.ID,
-		CreatedAt:    userData.CreatedAt,
-		UpdatedAt:    userData.UpdatedAt,
-		Email:        userData.Email,
-		Token:        token,
-		RefreshToken: refreshToken,
-		IsChirpyRed:  userData.IsChirpyRed,
+		Id:            userData.ID,
+		CreatedAt:     userData.CreatedAt,
+		UpdatedAt:     userData.UpdatedAt,
+		Email:         userData.Email,
+		Token:         token,
+		RefreshToken:  refreshToken,
+		IsChirpyRed:   userData.IsChirpyRed,
+		Handle:        userData.Handle,
+		DisplayName:   userData.DisplayName,
+		Bio:           userData.Bio,
+		AvatarMediaId: utilityNullUUID(userData.AvatarMediaID),
+		EmailVerified: userData.EmailVerifiedAt.Valid,
+		PendingEmail:  userData.PendingEmail.String,
+		Role:          userData.Role,
 	})
 	if err != nil {
 		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
@@ -655,6 +1661,10 @@ func (cfg *apiConfig) handlerLogin(response http.ResponseWriter, request *http.R
 		return
 	}
 
+	//Only a login that got all the way through, second factor and all, wipes the account's failed logins.
+	cfg.utilityClearLoginFailures(userData.Email)
+	cfg.utilityAudit(request, auditLogin, userData.ID, userData.ID, map[string]any{"session_id": sessionID})
+
 	response.Header().Set("Content-Type", "application/json")
 	response.WriteHeader(http.StatusOK)
 	response.Write(dataMarshalled)
@@ -671,8 +1681,19 @@ func (cfg *apiConfig) handlerRefresh(response http.ResponseWriter, request *http
 		return
 	}
 
+	//The token row is locked, so two refreshes with the same token cannot both rotate it.
+	tx, err := cfg.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Cannot refresh tokens."))
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
 	//Try to get the refreshToken data from the database.
-	refreshTokenData, err := cfg.dbQueries.GetRefreshToken(context.Background(), refreshToken)
+	refreshTokenData, err := qtx.GetRefreshToken(context.Background(), refreshToken)
 	if err != nil {
 		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
 		response.WriteHeader(http.StatusUnauthorized)
@@ -680,7 +1701,87 @@ func (cfg *apiConfig) handlerRefresh(response http.ResponseWriter, request *http
 		return
 	}
 
-	accessToken, err := auth.MakeJWT(refreshTokenData.UserID, cfg.SECRET)
+	//Tokens given to apps are refreshed at /oauth/token, where the app has to identify itself.
+	if refreshTokenData.ClientID.Valid {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusUnauthorized)
+		response.Write([]byte("Unauthorized: Please try to login again."))
+		return
+	}
+
+	//A token that was already swapped for a new one should never come back. If it does, someone else has a copy,
+	//so every token in its family is revoked and whoever holds them has to login again.
+	if refreshTokenData.UsedAt.Valid {
+		err = qtx.RevokeRefreshTokenFamily(context.Background(), refreshTokenData.FamilyID)
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			fmt.Printf("Error revoking refresh token family %v: %v\n", refreshTokenData.FamilyID, err)
+		}
+		cfg.utilityAudit(request, auditRefreshTokenReused, uuid.Nil, refreshTokenData.UserID, map[string]any{"session_id": refreshTokenData.FamilyID})
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusUnauthorized)
+		response.Write([]byte("Unauthorized: Please try to login again."))
+		return
+	}
+
+	if refreshTokenData.RevokedAt.Valid || !refreshTokenData.ExpiresAt.After(time.Now().UTC()) {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusUnauthorized)
+		response.Write([]byte("Unauthorized: Please try to login again."))
+		return
+	}
+
+	//The new access token carries the role the user has now.
+	userData, err := qtx.GetUserByID(context.Background(), refreshTokenData.UserID)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Cannot refresh tokens."))
+		return
+	}
+	if userData.SuspendedAt.Valid {
+		utilityWriteSuspended(response)
+		return
+	}
+
+	//Swap the token for a new one in the same family.
+	newRefreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Cannot generate refresh token."))
+		return
+	}
+
+	err = qtx.CreateRefreshToken(context.Background(), database.CreateRefreshTokenParams{
+		Token:     newRefreshToken,
+		CreatedAt: time.Now().UTC(),
+		UpdatedAt: time.Now().UTC(),
+		ExpiresAt: time.Now().Add(refreshTokenDuration).UTC(),
+		UserID:    refreshTokenData.UserID,
+		FamilyID:  refreshTokenData.FamilyID,
+		UserAgent: request.UserAgent(),
+		IpAddress: utilityClientIP(request),
+	})
+	if err == nil {
+		err = qtx.RotateRefreshToken(context.Background(), database.RotateRefreshTokenParams{
+			Token:      refreshTokenData.Token,
+			ReplacedBy: sql.NullString{String: newRefreshToken, Valid: true},
+		})
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Cannot store refresh token."))
+		return
+	}
+
+	accessToken, err := auth.MakeJWT(refreshTokenData.UserID, refreshTokenData.FamilyID, userData.Role, cfg.jwtKeys)
 	if err != nil {
 		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
 		response.WriteHeader(http.StatusInternalServerError)
@@ -688,7 +1789,8 @@ func (cfg *apiConfig) handlerRefresh(response http.ResponseWriter, request *http
 		return
 	}
 
-	dataMarshalled, err := json.Marshal(map[string]string{"token": accessToken})
+	//The old refresh token is spent, the client must keep the new one.
+	dataMarshalled, err := json.Marshal(map[string]string{"token": accessToken, "refresh_token": newRefreshToken})
 	if err != nil {
 		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
 		response.WriteHeader(http.StatusInternalServerError)
@@ -712,13 +1814,38 @@ func (cfg *apiConfig) handlerRevoke(response http.ResponseWriter, request *http.
 		return
 	}
 
-	err = cfg.dbQueries.RevokeRefreshToken(context.Background(), refreshToken)
+	//Revoking ends the whole family, so tokens this one was rotated from or into stop working too.
+	//The token is locked while it is revoked, so a refresh at the same time cannot slip in between.
+	tx, err := cfg.db.BeginTx(context.Background(), nil)
 	if err != nil {
 		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
 		response.WriteHeader(http.StatusInternalServerError)
 		response.Write([]byte("Internal Server Error: Failed to revoke token."))
 		return
 	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	refreshTokenData, err := qtx.GetRefreshToken(context.Background(), refreshToken)
+	if err == sql.ErrNoRows {
+		response.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err == nil {
+		err = qtx.RevokeRefreshToken(context.Background(), refreshToken)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Failed to revoke token."))
+		return
+	}
+	if !refreshTokenData.RevokedAt.Valid {
+		cfg.utilityAudit(request, auditSessionRevoked, refreshTokenData.UserID, refreshTokenData.UserID, map[string]any{"session_id": refreshTokenData.FamilyID})
+	}
 
 	response.WriteHeader(http.StatusNoContent)
 }
@@ -778,6 +1905,7 @@ func (cfg *apiConfig) handlerUpgradeUser(response http.ResponseWriter, request *
 		response.Write([]byte("Not Found: User not found."))
 		return
 	}
+	cfg.utilityAudit(request, auditChirpyRedUpgraded, uuid.Nil, userID, map[string]any{"event": polkaParams.Event})
 
 	//Success
 	response.WriteHeader(http.StatusNoContent)
@@ -793,11 +1921,155 @@ func main() {
 		os.Exit(1)
 	}
 
+	//Uploads are kept on local disk unless told otherwise.
+	mediaDir := os.Getenv("MEDIA_DIR")
+	if mediaDir == "" {
+		mediaDir = "media"
+	}
+	mediaBaseURL := os.Getenv("MEDIA_BASE_URL")
+	if mediaBaseURL == "" {
+		mediaBaseURL = "/media"
+	}
+	localStore, err := storage.NewLocalStorage(mediaDir, mediaBaseURL)
+	if err != nil {
+		fmt.Printf("Error in setting up media storage: %v", err)
+		os.Exit(1)
+	}
+
+	//Tokens are signed with the keys in JWT_KEYS_DIR when it is set, otherwise with SECRET.
+	//Tokens signed with SECRET before the switch are only still accepted until JWT_ACCEPT_LEGACY_SECRET_UNTIL,
+	//so the switch need not log anybody out but the secret stops working soon after.
+	jwtKeys := auth.NewHMACKeySet(os.Getenv("SECRET"))
+	if jwtKeysDir := os.Getenv("JWT_KEYS_DIR"); jwtKeysDir != "" {
+		jwtKeys, err = auth.LoadKeySet(jwtKeysDir)
+		if err != nil {
+			fmt.Printf("Error in loading JWT signing keys: %v", err)
+			os.Exit(1)
+		}
+		if legacyUntil := os.Getenv("JWT_ACCEPT_LEGACY_SECRET_UNTIL"); legacyUntil != "" && os.Getenv("SECRET") != "" {
+			until, err := time.Parse(time.RFC3339, legacyUntil)
+			if err != nil {
+				fmt.Printf("Error in JWT_ACCEPT_LEGACY_SECRET_UNTIL, it must be a time like 2024-06-01T12:00:00Z: %v", err)
+				os.Exit(1)
+			}
+			jwtKeys.AcceptHMAC(os.Getenv("SECRET"), time.Now(), until)
+		}
+	}
+
+	//Two-factor secrets are encrypted with TOTP_ENCRYPTION_KEY, without it two-factor cannot be turned on.
+	var secretBox *auth.SecretBox
+	if totpKey := os.Getenv("TOTP_ENCRYPTION_KEY"); totpKey != "" {
+		secretBox, err = auth.NewSecretBox(totpKey)
+		if err != nil {
+			fmt.Printf("Error in loading TOTP encryption key: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	//Emails in the audit log are hashed with AUDIT_KEY, or SECRET when it is not set. Changing it means
+	//events from before can no longer be found by email.
+	auditKey := os.Getenv("AUDIT_KEY")
+	if auditKey == "" {
+		auditKey = os.Getenv("SECRET")
+	}
+	if auditKey == "" {
+		fmt.Printf("Error in audit log setup: AUDIT_KEY or SECRET must be set")
+		os.Exit(1)
+	}
+
+	//Passwords are hashed with argon2id, its costs can be raised as hardware gets faster.
+	passwordParams := auth.DefaultPasswordParams
+	for _, setting := range []struct {
+		name  string
+		value *uint32
+	}{
+		{"PASSWORD_MEMORY_KIB", &passwordParams.Memory},
+		{"PASSWORD_ITERATIONS", &passwordParams.Iterations},
+	} {
+		if rawValue := os.Getenv(setting.name); rawValue != "" {
+			value, err := strconv.ParseUint(rawValue, 10, 32)
+			if err != nil || value == 0 {
+				fmt.Printf("Error in %v: must be a whole number above 0", setting.name)
+				os.Exit(1)
+			}
+			*setting.value = uint32(value)
+		}
+	}
+	if rawParallelism := os.Getenv("PASSWORD_PARALLELISM"); rawParallelism != "" {
+		parallelism, err := strconv.ParseUint(rawParallelism, 10, 8)
+		if err != nil || parallelism == 0 {
+			fmt.Printf("Error in PASSWORD_PARALLELISM: must be a whole number from 1 to 255")
+			os.Exit(1)
+		}
+		passwordParams.Parallelism = uint8(parallelism)
+	}
+
+	//New passwords must be long enough and not on the breached password list, if one is given.
+	passwordMinLength := 8
+	if rawMinLength := os.Getenv("PASSWORD_MIN_LENGTH"); rawMinLength != "" {
+		passwordMinLength, err = strconv.Atoi(rawMinLength)
+		if err != nil || passwordMinLength < 1 {
+			fmt.Printf("Error in PASSWORD_MIN_LENGTH: must be a whole number above 0")
+			os.Exit(1)
+		}
+	}
+	passwordPolicy, err := auth.LoadPasswordPolicy(passwordMinLength, os.Getenv("BREACHED_PASSWORDS_FILE"))
+	if err != nil {
+		fmt.Printf("Error in loading breached passwords: %v", err)
+		os.Exit(1)
+	}
+
+	//Email goes out over SMTP, into files or just to the log, the log is the default so nothing is sent by accident.
+	mailFrom := os.Getenv("MAIL_FROM")
+	if mailFrom == "" {
+		mailFrom = "no-reply@localhost"
+	}
+	var mailSender mailer.Mailer
+	switch os.Getenv("MAIL_DRIVER") {
+	case "smtp":
+		mailSender = mailer.SMTPMailer{
+			Addr:     os.Getenv("SMTP_ADDR"),
+			From:     mailFrom,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+		}
+	case "file":
+		mailDir := os.Getenv("MAIL_DIR")
+		if mailDir == "" {
+			mailDir = "mail"
+		}
+		mailSender, err = mailer.NewFileMailer(mailDir, mailFrom)
+		if err != nil {
+			fmt.Printf("Error in setting up mail directory: %v", err)
+			os.Exit(1)
+		}
+	default:
+		//Emails carry live links, so they are only printed in full in development.
+		mailSender = mailer.LogMailer{Out: os.Stdout, From: mailFrom, ShowBody: os.Getenv("PLATFORM") == "dev"}
+	}
+	//Links in emails point at the client app.
+	appURL := strings.TrimSuffix(os.Getenv("APP_URL"), "/")
+	if appURL == "" {
+		appURL = "http://localhost:8080"
+	}
+
 	cfg := &apiConfig{
-		dbQueries: database.New(db),
-		PLATFORM:  os.Getenv("PLATFORM"),
-		SECRET:    os.Getenv("SECRET"),
-		POLKA:     os.Getenv("POLKA_KEY"),
+		db:         db,
+		dbQueries:  database.New(db),
+		PLATFORM:   os.Getenv("PLATFORM"),
+		SECRET:     os.Getenv("SECRET"),
+		POLKA:      os.Getenv("POLKA_KEY"),
+		mediaStore: localStore,
+		jwtKeys:    jwtKeys,
+		secretBox:  secretBox,
+		auditKey:   auditKey,
+		mailer:     mailSender,
+		appURL:     appURL,
+
+		passwordParams: passwordParams,
+		passwordPolicy: passwordPolicy,
+
+		requireVerifiedEmail: os.Getenv("REQUIRE_VERIFIED_EMAIL") == "true",
 	}
 
 	testing := false
@@ -817,23 +2089,86 @@ func main() {
 	srv.Addr = ":8080"
 	//File Server
 	mux.Handle("/app/", http.StripPrefix("/app", cfg.middlewareMetricsInc(http.FileServer(http.Dir(".")))))
+	mux.Handle("GET /media/", http.StripPrefix("/media", localStore))
 	//Check on status
 	mux.HandleFunc("GET /api/healthz", endHandler)
+	//Public keys for checking our tokens
+	mux.HandleFunc("GET /.well-known/jwks.json", cfg.handlerJWKS)
 	//Hit Metric functions
 	//mux.HandleFunc("GET /api/metrics", hits.handler)
-	mux.HandleFunc("GET /admin/metrics", cfg.adminHandler)
-	mux.HandleFunc("POST /admin/reset", cfg.reset)
+	//Admin functions, everything under /admin needs the admin role.
+	adminMux := http.NewServeMux()
+	adminMux.HandleFunc("GET /admin/metrics", cfg.adminHandler)
+	adminMux.HandleFunc("POST /admin/reset", cfg.reset)
+	adminMux.HandleFunc("GET /admin/users", cfg.handlerAdminGetUsers)
+	adminMux.HandleFunc("GET /admin/users/{userID}", cfg.handlerAdminGetUser)
+	adminMux.HandleFunc("DELETE /admin/users/{userID}", cfg.handlerAdminDeleteUser)
+	adminMux.HandleFunc("GET /admin/users/{userID}/chirps", cfg.handlerAdminGetUserChirps)
+	adminMux.HandleFunc("GET /admin/users/{userID}/sessions", cfg.handlerAdminGetUserSessions)
+	adminMux.HandleFunc("PUT /admin/users/{userID}/role", cfg.handlerSetUserRole)
+	adminMux.HandleFunc("GET /admin/audit-events", cfg.handlerAdminGetAuditEvents)
+	adminMux.HandleFunc("POST /admin/users/{userID}/suspend", cfg.handlerAdminSuspendUser)
+	adminMux.HandleFunc("POST /admin/users/{userID}/unsuspend", cfg.handlerAdminUnsuspendUser)
+	adminMux.HandleFunc("POST /admin/users/{userID}/logout", cfg.handlerAdminLogoutUser)
+	adminMux.HandleFunc("POST /admin/users/{userID}/password-reset", cfg.handlerAdminResetPassword)
+	mux.Handle("/admin/", cfg.middlewareRequireRole(roleAdmin, adminMux))
 	//Chirp functions
 	mux.HandleFunc("POST /api/chirps", cfg.handlerPostChirps)
 	mux.HandleFunc("GET /api/chirps", cfg.handlerGetChirps)
 	mux.HandleFunc("GET /api/chirps/{chirpID}", cfg.handlerGetChirpByID)
 	mux.HandleFunc("DELETE /api/chirps/{chirpID}", cfg.handlerDeleteChirpByID)
+	mux.HandleFunc("PUT /api/chirps/{chirpID}", cfg.handlerUpdateChirp)
+	mux.HandleFunc("GET /api/chirps/{chirpID}/revisions", cfg.handlerGetChirpRevisions)
+	mux.HandleFunc("GET /api/chirps/{chirpID}/thread", cfg.handlerGetChirpThread)
+	mux.HandleFunc("POST /api/chirps/{chirpID}/like", cfg.handlerLikeChirp)
+	mux.HandleFunc("DELETE /api/chirps/{chirpID}/like", cfg.handlerUnlikeChirp)
+	mux.HandleFunc("POST /api/chirps/{chirpID}/rechirp", cfg.handlerRechirp)
+	mux.HandleFunc("DELETE /api/chirps/{chirpID}/rechirp", cfg.handlerUndoRechirp)
+	mux.HandleFunc("POST /api/media", cfg.handlerUploadMedia)
 	//User functions
 	mux.HandleFunc("POST /api/login", cfg.handlerLogin)
+	mux.HandleFunc("POST /api/login/2fa", cfg.handlerLogin2FA)
+	mux.HandleFunc("POST /api/password/forgot", cfg.handlerForgotPassword)
+	mux.HandleFunc("POST /api/password/reset", cfg.handlerResetPassword)
+	mux.HandleFunc("POST /api/email/verify", cfg.handlerVerifyEmail)
+	mux.HandleFunc("POST /api/email/verify/resend", cfg.handlerResendEmailVerification)
 	mux.HandleFunc("POST /api/users", cfg.createUser)
 	mux.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
 	mux.HandleFunc("POST /api/revoke", cfg.handlerRevoke)
 	mux.HandleFunc("PUT /api/users", cfg.handlerUpdateUser)
+	mux.HandleFunc("GET /api/users/{handle}", cfg.handlerGetUserProfile)
+	//Two-factor functions
+	mux.HandleFunc("POST /api/2fa/totp", cfg.handlerEnrollTOTP)
+	mux.HandleFunc("POST /api/2fa/totp/confirm", cfg.handlerConfirmTOTP)
+	mux.HandleFunc("DELETE /api/2fa/totp", cfg.handlerDisableTOTP)
+	mux.HandleFunc("POST /api/2fa/recovery-codes", cfg.handlerRegenerateRecoveryCodes)
+	//Session functions
+	mux.HandleFunc("GET /api/sessions", cfg.handlerGetSessions)
+	mux.HandleFunc("DELETE /api/sessions/{sessionID}", cfg.handlerRevokeSession)
+	mux.HandleFunc("POST /api/sessions/revoke-all", cfg.handlerRevokeAllSessions)
+	mux.HandleFunc("GET /api/me/security-log", cfg.handlerGetSecurityLog)
+	//OAuth functions
+	mux.HandleFunc("POST /api/oauth/clients", cfg.handlerCreateOAuthClient)
+	mux.HandleFunc("GET /api/oauth/clients", cfg.handlerGetOAuthClients)
+	mux.HandleFunc("DELETE /api/oauth/clients/{clientID}", cfg.handlerDeleteOAuthClient)
+	mux.HandleFunc("GET /oauth/authorize", cfg.handlerOAuthAuthorize)
+	mux.HandleFunc("POST /oauth/authorize", cfg.handlerOAuthConsent)
+	mux.HandleFunc("POST /oauth/token", cfg.handlerOAuthToken)
+	mux.HandleFunc("POST /oauth/revoke", cfg.handlerOAuthRevoke)
+	//Personal access token functions
+	mux.HandleFunc("POST /api/tokens", cfg.handlerCreatePersonalAccessToken)
+	mux.HandleFunc("GET /api/tokens", cfg.handlerGetPersonalAccessTokens)
+	mux.HandleFunc("DELETE /api/tokens/{tokenID}", cfg.handlerRevokePersonalAccessToken)
+	//Follow functions
+	mux.HandleFunc("POST /api/users/{userID}/follow", cfg.handlerFollowUser)
+	mux.HandleFunc("DELETE /api/users/{userID}/follow", cfg.handlerUnfollowUser)
+	mux.HandleFunc("GET /api/users/{userID}/followers", cfg.handlerGetFollowers)
+	mux.HandleFunc("GET /api/users/{userID}/following", cfg.handlerGetFollowing)
+	mux.HandleFunc("GET /api/users/{userID}/likes", cfg.handlerGetUserLikes)
+	mux.HandleFunc("GET /api/timeline", cfg.handlerGetTimeline)
+
+	mux.HandleFunc("GET /api/hashtags/{tag}/chirps", cfg.handlerGetHashtagChirps)
+	mux.HandleFunc("GET /api/search/chirps", cfg.handlerSearchChirps)
 	//Webhooks
 	mux.HandleFunc("POST /api/polka/webhooks", cfg.handlerUpgradeUser)
 
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"unicode/utf8"
+
+	"github/JohnDirewolf/chirpy/internal/auth"
+	"github/JohnDirewolf/chirpy/internal/database"
+	"github/JohnDirewolf/chirpy/internal/imaging"
+
+	"github.com/google/uuid"
+)
+
+// Limits on what can be uploaded and attached.
+const maxMediaBytes = 5 << 20
+const maxMediaPerChirp = 4
+const maxAltTextLength = 1000
+
+// The types we accept, and the extension the file is stored under.
+var mediaExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+type mediaResponse struct {
+	Id          uuid.UUID                `json:"id"`
+	Url         string                   `json:"url"`
+	ContentType string                   `json:"content_type"`
+	Width       int32                    `json:"width"`
+	Height      int32                    `json:"height"`
+	AltText     string                   `json:"alt_text"`
+	Blurhash    string                   `json:"blurhash"`
+	Thumbnails  []mediaThumbnailResponse `json:"thumbnails"`
+}
+
+// Every image has the same set of thumbnails, smallest first, named small, medium and large.
+type mediaThumbnailResponse struct {
+	Name   string `json:"name"`
+	Url    string `json:"url"`
+	Width  int32  `json:"width"`
+	Height int32  `json:"height"`
+}
+
+func (cfg *apiConfig) utilityMediaResponse(media database.Medium, thumbnails []database.MediaThumbnail) mediaResponse {
+	attachment := mediaResponse{
+		Id:          media.ID,
+		Url:         cfg.mediaStore.URL(media.StorageKey),
+		ContentType: media.ContentType,
+		Width:       media.Width,
+		Height:      media.Height,
+		AltText:     media.AltText,
+		Blurhash:    media.Blurhash,
+		Thumbnails:  make([]mediaThumbnailResponse, 0, len(thumbnails)),
+	}
+	for _, thumbnail := range thumbnails {
+		attachment.Thumbnails = append(attachment.Thumbnails, mediaThumbnailResponse{
+			Name:   thumbnail.Name,
+			Url:    cfg.mediaStore.URL(thumbnail.StorageKey),
+			Width:  thumbnail.Width,
+			Height: thumbnail.Height,
+		})
+	}
+	return attachment
+}
+
+// Loads the attachments for a list of chirps, keyed by chirp and in the order they were attached.
+func (cfg *apiConfig) utilityChirpMedia(chirpIDs []uuid.UUID) (map[uuid.UUID][]mediaResponse, error) {
+	mediaList, err := cfg.dbQueries.GetChirpMedia(context.Background(), chirpIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	mediaByChirp := make(map[uuid.UUID][]mediaResponse)
+	if len(mediaList) == 0 {
+		return mediaByChirp, nil
+	}
+
+	mediaIDs := make([]uuid.UUID, 0, len(mediaList))
+	for _, media := range mediaList {
+		mediaIDs = append(mediaIDs, media.ID)
+	}
+	thumbnailList, err := cfg.dbQueries.GetMediaThumbnails(context.Background(), mediaIDs)
+	if err != nil {
+		return nil, err
+	}
+	thumbnailsByMedia := make(map[uuid.UUID][]database.MediaThumbnail)
+	for _, thumbnail := range thumbnailList {
+		thumbnailsByMedia[thumbnail.MediaID] = append(thumbnailsByMedia[thumbnail.MediaID], thumbnail)
+	}
+
+	for _, media := range mediaList {
+		mediaByChirp[media.ChirpID.UUID] = append(mediaByChirp[media.ChirpID.UUID], cfg.utilityMediaResponse(media, thumbnailsByMedia[media.ID]))
+	}
+	return mediaByChirp, nil
+}
+
+func (cfg *apiConfig) handlerUploadMedia(response http.ResponseWriter, request *http.Request) {
+	//Validate credentials sent.
+	//Get the user token from the request.
+	userToken, err := auth.GetBearerToken(request.Header)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusUnauthorized)
+		response.Write([]byte("Unathorized: Please login first."))
+		return
+	}
+
+	userID, err := cfg.utilityAuthenticate(userToken, scopeChirpsWrite)
+	if err != nil {
+		utilityWriteAuthError(response, err, scopeChirpsWrite)
+		return
+	}
+
+	//Leave a little room over the file limit for the rest of the form.
+	request.Body = http.MaxBytesReader(response, request.Body, maxMediaBytes+(64<<10))
+	err = request.ParseMultipartForm(maxMediaBytes)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusRequestEntityTooLarge)
+		response.Write([]byte(fmt.Sprintf("Request Entity Too Large: Uploads are limited to %d MB.", maxMediaBytes>>20)))
+		return
+	}
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: Upload the file as multipart/form-data."))
+		return
+	}
+
+	file, _, err := request.FormFile("file")
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: Upload a file in the file field."))
+		return
+	}
+	defer file.Close()
+
+	altText := request.FormValue("alt_text")
+	if utf8.RuneCountInString(altText) > maxAltTextLength {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte(fmt.Sprintf("Bad Request: Alt text is longer then %d characters.", maxAltTextLength)))
+		return
+	}
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not read upload."))
+		return
+	}
+	if len(data) > maxMediaBytes {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusRequestEntityTooLarge)
+		response.Write([]byte(fmt.Sprintf("Request Entity Too Large: Uploads are limited to %d MB.", maxMediaBytes>>20)))
+		return
+	}
+
+	//Go by what the bytes are, not what the client says they are.
+	contentType := http.DetectContentType(data)
+	extension, allowed := mediaExtensions[contentType]
+	if !allowed {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusUnsupportedMediaType)
+		response.Write([]byte("Unsupported Media Type: Only JPEG, PNG and GIF images can be uploaded."))
+		return
+	}
+
+	//Turn the image upright, strip its metadata and make the thumbnails and placeholder.
+	processed, err := imaging.Process(data, contentType)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: Image could not be read."))
+		return
+	}
+
+	mediaID := uuid.New()
+	storageKey := mediaID.String() + extension
+	err = cfg.mediaStore.Save(storageKey, bytes.NewReader(processed.Original.Data))
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not save upload."))
+		return
+	}
+	savedKeys := []string{storageKey}
+	//Do not leave files behind that nothing points at.
+	deleteSaved := func() {
+		for _, savedKey := range savedKeys {
+			cfg.mediaStore.Delete(savedKey)
+		}
+	}
+
+	for _, thumbnail := range processed.Thumbnails {
+		thumbnailKey := mediaID.String() + "_" + thumbnail.Name + mediaExtensions[thumbnail.ContentType]
+		err = cfg.mediaStore.Save(thumbnailKey, bytes.NewReader(thumbnail.Data))
+		if err != nil {
+			deleteSaved()
+			response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			response.WriteHeader(http.StatusInternalServerError)
+			response.Write([]byte("Internal Server Error: Could not save upload."))
+			return
+		}
+		savedKeys = append(savedKeys, thumbnailKey)
+	}
+
+	//The image and its thumbnails are saved together.
+	tx, err := cfg.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		deleteSaved()
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not save upload."))
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	media, err := qtx.CreateMedia(context.Background(), database.CreateMediaParams{
+		ID:          mediaID,
+		UserID:      userID,
+		StorageKey:  storageKey,
+		ContentType: contentType,
+		SizeBytes:   int64(len(processed.Original.Data)),
+		Width:       int32(processed.Original.Width),
+		Height:      int32(processed.Original.Height),
+		AltText:     altText,
+		Blurhash:    processed.Blurhash,
+	})
+	thumbnails := make([]database.MediaThumbnail, 0, len(processed.Thumbnails))
+	for i := 0; err == nil && i < len(processed.Thumbnails); i++ {
+		var thumbnail database.MediaThumbnail
+		thumbnail, err = qtx.CreateMediaThumbnail(context.Background(), database.CreateMediaThumbnailParams{
+			MediaID:    mediaID,
+			Name:       processed.Thumbnails[i].Name,
+			StorageKey: savedKeys[i+1],
+			Width:      int32(processed.Thumbnails[i].Width),
+			Height:     int32(processed.Thumbnails[i].Height),
+		})
+		thumbnails = append(thumbnails, thumbnail)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		deleteSaved()
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not save upload."))
+		return
+	}
+
+	dataMarshalled, err := json.Marshal(cfg.utilityMediaResponse(media, thumbnails))
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not create response."))
+		return
+	}
+
+	//Success
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusCreated)
+	response.Write(dataMarshalled)
+}
@@ -0,0 +1,964 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github/JohnDirewolf/chirpy/internal/auth"
+	"github/JohnDirewolf/chirpy/internal/database"
+
+	"github.com/google/uuid"
+)
+
+// How long an app has to swap an authorization code for tokens, codes also only work once.
+const authorizationCodeDuration = time.Minute * 5
+
+const maxClientNameLength = 100
+const maxRedirectURIs = 10
+
+// What each scope lets an app do, as the user is shown it on the consent page.
+var scopeDescriptions = map[string]string{
+	scopeChirpsRead:   "See your timeline and which chirps you have liked",
+	scopeChirpsWrite:  "Post, edit and delete chirps, and like and rechirp for you",
+	scopeProfileWrite: "Change your profile and who you follow",
+}
+
+// An app registered to use Chirpy accounts through OAuth. Apps that can keep a secret get one, apps that
+// cannot, like ones running in a browser or on a phone, rely on PKCE alone.
+type oauthClientResponse struct {
+	ClientId     uuid.UUID `json:"client_id"`
+	CreatedAt    time.Time `json:"created_at"`
+	Name         string    `json:"name"`
+	RedirectUris []string  `json:"redirect_uris"`
+	Scopes       []string  `json:"scopes"`
+	Confidential bool      `json:"confidential"`
+	//Only in the response when the app is registered, after that only its hash is kept.
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+type oauthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+}
+
+// An authorization request that has been checked, everything the consent page needs to carry through to the code.
+type authorizationRequest struct {
+	Client        database.OauthClient
+	RedirectURI   string
+	Scopes        []string
+	State         string
+	CodeChallenge string
+}
+
+func utilityOAuthClientResponse(clientData database.OauthClient) oauthClientResponse {
+	return oauthClientResponse{
+		ClientId:     clientData.ID,
+		CreatedAt:    clientData.CreatedAt,
+		Name:         clientData.Name,
+		RedirectUris: clientData.RedirectUris,
+		Scopes:       clientData.Scopes,
+		Confidential: clientData.SecretHash.Valid,
+	}
+}
+
+// Redirect URIs must be https, apart from ones back to the same machine which native apps listen on.
+// They are matched exactly, so they cannot have a fragment.
+func utilityValidRedirectURI(rawURI string) bool {
+	redirectURI, err := url.Parse(rawURI)
+	if err != nil || !redirectURI.IsAbs() || redirectURI.Host == "" || redirectURI.Fragment != "" || redirectURI.User != nil {
+		return false
+	}
+	if redirectURI.Scheme == "https" {
+		return true
+	}
+	if redirectURI.Scheme == "http" {
+		host := redirectURI.Hostname()
+		ip := net.ParseIP(host)
+		return host == "localhost" || (ip != nil && ip.IsLoopback())
+	}
+	return false
+}
+
+// The token endpoint talks OAuth, so its errors are JSON in the form apps expect instead of plain text.
+func utilityOAuthError(response http.ResponseWriter, status int, code string, description string) {
+	dataMarshalled, _ := json.Marshal(map[string]string{"error": code, "error_description": description})
+	if status == http.StatusUnauthorized {
+		response.Header().Set("WWW-Authenticate", `Basic realm="chirpy"`)
+	}
+	response.Header().Set("Content-Type", "application/json")
+	response.Header().Set("Cache-Control", "no-store")
+	response.WriteHeader(status)
+	response.Write(dataMarshalled)
+}
+
+// Sends the user back to the app, adding the params to whatever query the redirect URI already has.
+func utilityOAuthRedirect(response http.ResponseWriter, request *http.Request, redirectURI string, params url.Values) {
+	target, err := url.Parse(redirectURI)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Unable to return to the app."))
+		return
+	}
+	query := target.Query()
+	for key, values := range params {
+		//An app that sent no state gets none back.
+		if len(values) == 1 && values[0] == "" {
+			continue
+		}
+		query[key] = values
+	}
+	target.RawQuery = query.Encode()
+	http.Redirect(response, request, target.String(), http.StatusSeeOther)
+}
+
+// Apps send their credentials with HTTP Basic auth or as client_id and client_secret in the form.
+// An app registered without a secret is only identified, PKCE is what stops anyone else using its codes.
+func (cfg *apiConfig) utilityAuthenticateOAuthClient(request *http.Request) (database.OauthClient, error) {
+	clientIDString, clientSecret, hasBasic := request.BasicAuth()
+	if !hasBasic {
+		clientIDString = request.PostForm.Get("client_id")
+		clientSecret = request.PostForm.Get("client_secret")
+	}
+	clientID, err := uuid.Parse(clientIDString)
+	if err != nil {
+		return database.OauthClient{}, errors.New("client_id is malformed")
+	}
+	clientData, err := cfg.dbQueries.GetOAuthClient(context.Background(), clientID)
+	if err != nil {
+		return database.OauthClient{}, err
+	}
+	if clientData.SecretHash.Valid {
+		if subtle.ConstantTimeCompare([]byte(auth.HashToken(clientSecret)), []byte(clientData.SecretHash.String)) != 1 {
+			return database.OauthClient{}, errors.New("client secret is wrong")
+		}
+	} else if clientSecret != "" {
+		return database.OauthClient{}, errors.New("client has no secret")
+	}
+	return clientData, nil
+}
+
+// Checks the app and where to send the user back to. If either is wrong there is nowhere safe to send an error,
+// so it is shown to the user instead.
+func (cfg *apiConfig) utilityCheckOAuthClientRedirect(values url.Values) (database.OauthClient, string, error) {
+	clientID, err := uuid.Parse(values.Get("client_id"))
+	if err != nil {
+		return database.OauthClient{}, "", errors.New("The app's client_id is malformed.")
+	}
+	clientData, err := cfg.dbQueries.GetOAuthClient(context.Background(), clientID)
+	if err == sql.ErrNoRows {
+		return database.OauthClient{}, "", errors.New("The app is not registered with Chirpy.")
+	}
+	if err != nil {
+		return database.OauthClient{}, "", errors.New("Unable to look up the app, please try again.")
+	}
+	//It must be exactly one the app registered, and the app sends it again when it swaps the code.
+	redirectURI := values.Get("redirect_uri")
+	if !slices.Contains(clientData.RedirectUris, redirectURI) {
+		return database.OauthClient{}, "", errors.New("The app asked to return to an address it has not registered.")
+	}
+	return clientData, redirectURI, nil
+}
+
+// Checks the rest of an authorization request once the app and redirect URI are known to be good.
+// A problem gives the OAuth error code to send back to the app.
+func utilityCheckAuthorizationRequest(clientData database.OauthClient, redirectURI string, values url.Values) (authorizationRequest, string, string) {
+	authRequest := authorizationRequest{
+		Client:      clientData,
+		RedirectURI: redirectURI,
+		State:       values.Get("state"),
+	}
+	if values.Get("response_type") != "code" {
+		return authRequest, "unsupported_response_type", "Only the authorization code flow is supported."
+	}
+	//PKCE is required of every app, and only with S256, plain would give the verifier away.
+	authRequest.CodeChallenge = values.Get("code_challenge")
+	if authRequest.CodeChallenge == "" || values.Get("code_challenge_method") != "S256" {
+		return authRequest, "invalid_request", "A code_challenge with code_challenge_method S256 is required."
+	}
+	if !auth.ValidPKCEValue(authRequest.CodeChallenge) {
+		return authRequest, "invalid_request", "The code_challenge is malformed."
+	}
+	//Asking for no scopes asks for everything the app was registered with.
+	requested := strings.Fields(values.Get("scope"))
+	if len(requested) == 0 {
+		requested = clientData.Scopes
+	}
+	scopes, err := utilityParseScopes(requested)
+	if err != nil {
+		return authRequest, "invalid_scope", err.Error()
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(clientData.Scopes, scope) {
+			return authRequest, "invalid_scope", "The app is not registered for the " + scope + " scope."
+		}
+	}
+	authRequest.Scopes = scopes
+	return authRequest, "", ""
+}
+
+func (cfg *apiConfig) handlerCreateOAuthClient(response http.ResponseWriter, request *http.Request) {
+	//Validate credentials sent.
+	//Get the user token from the request.
+	userToken, err := auth.GetBearerToken(request.Header)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusUnauthorized)
+		response.Write([]byte("Unathorized: Please login first."))
+		return
+	}
+
+	userID, err := cfg.utilityValidateJWT(userToken)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusUnauthorized)
+		response.Write([]byte("Unathorized: credentials invalid. Please login again."))
+		return
+	}
+
+	type requestParameters struct {
+		Name         string   `json:"name"`
+		RedirectUris []string `json:"redirect_uris"`
+		Scopes       []string `json:"scopes"`
+		//Apps that run on a server can keep a secret, ones in a browser or on a phone cannot.
+		Confidential bool `json:"confidential"`
+	}
+
+	decoder := json.NewDecoder(request.Body)
+	requestParams := requestParameters{}
+	err = decoder.Decode(&requestParams)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: Did not understand request."))
+		return
+	}
+
+	name := strings.TrimSpace(requestParams.Name)
+	if name == "" || utf8.RuneCountInString(name) > maxClientNameLength {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte(fmt.Sprintf("Bad Request: An app needs a name of up to %d characters.", maxClientNameLength)))
+		return
+	}
+	if len(requestParams.RedirectUris) == 0 || len(requestParams.RedirectUris) > maxRedirectURIs {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte(fmt.Sprintf("Bad Request: An app needs between 1 and %d redirect URIs.", maxRedirectURIs)))
+		return
+	}
+	for _, redirectURI := range requestParams.RedirectUris {
+		if !utilityValidRedirectURI(redirectURI) {
+			response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			response.WriteHeader(http.StatusBadRequest)
+			response.Write([]byte("Bad Request: Redirect URIs must be https, or http to localhost, with no fragment."))
+			return
+		}
+	}
+	scopes, err := utilityParseScopes(requestParams.Scopes)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: " + err.Error() + "."))
+		return
+	}
+	if len(scopes) == 0 {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: An app needs at least one scope, one of " + strings.Join(knownScopes, ", ") + "."))
+		return
+	}
+
+	clientSecret := ""
+	secretHash := sql.NullString{}
+	if requestParams.Confidential {
+		clientSecret, err = auth.MakeRefreshToken()
+		if err != nil {
+			response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			response.WriteHeader(http.StatusInternalServerError)
+			response.Write([]byte("Internal Server Error: Unable to generate client secret."))
+			return
+		}
+		secretHash = sql.NullString{String: auth.HashToken(clientSecret), Valid: true}
+	}
+
+	clientData, err := cfg.dbQueries.CreateOAuthClient(context.Background(), database.CreateOAuthClientParams{
+		OwnerID:      userID,
+		Name:         name,
+		RedirectUris: slices.Compact(slices.Sorted(slices.Values(requestParams.RedirectUris))),
+		Scopes:       scopes,
+		SecretHash:   secretHash,
+	})
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Unable to register app."))
+		return
+	}
+
+	clientResponse := utilityOAuthClientResponse(clientData)
+	clientResponse.ClientSecret = clientSecret
+	dataMarshalled, err := json.Marshal(clientResponse)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Failed create response."))
+		return
+	}
+
+	//Success
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusCreated)
+	response.Write(dataMarshalled)
+}
+
+func (cfg *apiConfig) handlerGetOAuthClients(response http.ResponseWriter, request *http.Request) {
+	//Validate credentials sent.
+	//Get the user token from the request.
+	userToken, err := auth.GetBearerToken(request.Header)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusUnauthorized)
+		response.Write([]byte("Unathorized: Please login first."))
+		return
+	}
+
+	userID, err := cfg.utilityValidateJWT(userToken)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusUnauthorized)
+		response.Write([]byte("Unathorized: credentials invalid. Please login again."))
+		return
+	}
+
+	clientList, err := cfg.dbQueries.GetOAuthClientsByOwner(context.Background(), userID)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not retrieve apps."))
+		return
+	}
+
+	clientListResponse := make([]oauthClientResponse, 0, len(clientList))
+	for _, clientData := range clientList {
+		clientListResponse = append(clientListResponse, utilityOAuthClientResponse(clientData))
+	}
+
+	dataMarshalled, err := json.Marshal(clientListResponse)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Failed create response."))
+		return
+	}
+
+	//Success
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusOK)
+	response.Write(dataMarshalled)
+}
+
+func (cfg *apiConfig) handlerDeleteOAuthClient(response http.ResponseWriter, request *http.Request) {
+	//Validate credentials sent.
+	//Get the user token from the request.
+	userToken, err := auth.GetBearerToken(request.Header)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusUnauthorized)
+		response.Write([]byte("Unathorized: Please login first."))
+		return
+	}
+
+	userID, err := cfg.utilityValidateJWT(userToken)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusUnauthorized)
+		response.Write([]byte("Unathorized: credentials invalid. Please login again."))
+		return
+	}
+
+	clientID, err := uuid.Parse(request.PathValue("clientID"))
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: client id is malformed."))
+		return
+	}
+
+	//Deleting an app takes its codes and every token it was given with it.
+	rowsDeleted, err := cfg.dbQueries.DeleteOAuthClient(context.Background(), database.DeleteOAuthClientParams{
+		ID:      clientID,
+		OwnerID: userID,
+	})
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Failed to delete app."))
+		return
+	}
+	if rowsDeleted == 0 {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusNotFound)
+		response.Write([]byte("Not Found: App not found."))
+		return
+	}
+
+	//Success
+	response.WriteHeader(http.StatusNoContent)
+}
+
+// The consent page. The user signs in to Chirpy here, so the app never sees their password,
+// and everything about the request rides along in hidden fields.
+var consentPage = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<title>Chirpy - Allow access</title>
+</head>
+<body>
+{{if .Fatal}}
+	<h1>Something went wrong</h1>
+	<p>{{.Fatal}}</p>
+{{else}}
+	<h1>{{.Request.Client.Name}} would like to use your Chirpy account</h1>
+	<p>If you allow it, {{.Request.Client.Name}} will be able to:</p>
+	<ul>
+	{{range .Scopes}}<li>{{.}}</li>
+	{{end}}
+	</ul>
+	<p>You will be sent back to {{.RedirectHost}}. You can take access away at any time by ending its session.</p>
+	{{if .Error}}<p><strong>{{.Error}}</strong></p>{{end}}
+	<form method="POST" action="/oauth/authorize">
+		<input type="hidden" name="response_type" value="code">
+		<input type="hidden" name="client_id" value="{{.Request.Client.ID}}">
+		<input type="hidden" name="redirect_uri" value="{{.Request.RedirectURI}}">
+		<input type="hidden" name="scope" value="{{.Scope}}">
+		<input type="hidden" name="state" value="{{.Request.State}}">
+		<input type="hidden" name="code_challenge" value="{{.Request.CodeChallenge}}">
+		<input type="hidden" name="code_challenge_method" value="S256">
+		<p><label>Email <input type="email" name="email" value="{{.Email}}" autocomplete="username"></label></p>
+		<p><label>Password <input type="password" name="password" autocomplete="current-password"></label></p>
+		<p><label>Two-factor or recovery code, if you use one <input type="text" name="code" autocomplete="one-time-code"></label></p>
+		<button type="submit" name="decision" value="allow">Allow</button>
+		<button type="submit" name="decision" value="deny">Deny</button>
+	</form>
+{{end}}
+</body>
+</html>
+`))
+
+type consentPageData struct {
+	Fatal        string
+	Error        string
+	Request      authorizationRequest
+	Scopes       []string
+	Scope        string
+	RedirectHost string
+	Email        string
+}
+
+func utilityRenderConsentPage(response http.ResponseWriter, status int, pageData consentPageData) {
+	for _, scope := range pageData.Request.Scopes {
+		pageData.Scopes = append(pageData.Scopes, scopeDescriptions[scope])
+	}
+	pageData.Scope = strings.Join(pageData.Request.Scopes, " ")
+	redirectURI, err := url.Parse(pageData.Request.RedirectURI)
+	if err == nil {
+		pageData.RedirectHost = redirectURI.Host
+	}
+
+	//The page takes a password, so it must never be framed by another site or kept in a cache.
+	response.Header().Set("Content-Type", "text/html; charset=utf-8")
+	response.Header().Set("Cache-Control", "no-store")
+	response.Header().Set("X-Frame-Options", "DENY")
+	response.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	response.WriteHeader(status)
+	err = consentPage.Execute(response, pageData)
+	if err != nil {
+		fmt.Printf("Error rendering consent page: %v\n", err)
+	}
+}
+
+func (cfg *apiConfig) handlerOAuthAuthorize(response http.ResponseWriter, request *http.Request) {
+	values := request.URL.Query()
+	clientData, redirectURI, err := cfg.utilityCheckOAuthClientRedirect(values)
+	if err != nil {
+		utilityRenderConsentPage(response, http.StatusBadRequest, consentPageData{Fatal: err.Error()})
+		return
+	}
+
+	authRequest, errorCode, errorDescription := utilityCheckAuthorizationRequest(clientData, redirectURI, values)
+	if errorCode != "" {
+		utilityOAuthRedirect(response, request, redirectURI, url.Values{
+			"error":             {errorCode},
+			"error_description": {errorDescription},
+			"state":             {authRequest.State},
+		})
+		return
+	}
+
+	//Success
+	utilityRenderConsentPage(response, http.StatusOK, consentPageData{Request: authRequest})
+}
+
+func (cfg *apiConfig) handlerOAuthConsent(response http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		utilityRenderConsentPage(response, http.StatusBadRequest, consentPageData{Fatal: "Did not understand request."})
+		return
+	}
+	values := request.PostForm
+	clientData, redirectURI, err := cfg.utilityCheckOAuthClientRedirect(values)
+	if err != nil {
+		utilityRenderConsentPage(response, http.StatusBadRequest, consentPageData{Fatal: err.Error()})
+		return
+	}
+
+	authRequest, errorCode, errorDescription := utilityCheckAuthorizationRequest(clientData, redirectURI, values)
+	if errorCode != "" {
+		utilityOAuthRedirect(response, request, redirectURI, url.Values{
+			"error":             {errorCode},
+			"error_description": {errorDescription},
+			"state":             {authRequest.State},
+		})
+		return
+	}
+
+	if values.Get("decision") != "allow" {
+		utilityOAuthRedirect(response, request, redirectURI, url.Values{
+			"error":             {"access_denied"},
+			"error_description": {"The user did not allow access."},
+			"state":             {authRequest.State},
+		})
+		return
+	}
+
+	//The user signs in just as they would at /api/login, second factor, lockouts and all.
+	email := values.Get("email")
+	reserved, retryAfter, err := cfg.utilityReserveLoginAttempt(request, email)
+	if err != nil {
+		utilityRenderConsentPage(response, http.StatusInternalServerError, consentPageData{Request: authRequest, Email: email, Error: "Unable to sign in, please try again."})
+		return
+	}
+	if retryAfter > 0 {
+		response.Header().Set("Retry-After", utilityRetryAfterSeconds(retryAfter))
+		utilityRenderConsentPage(response, http.StatusTooManyRequests, consentPageData{Request: authRequest, Email: email, Error: "Too many failed sign ins, please try again in " + utilityRetryAfterSeconds(retryAfter) + " seconds."})
+		return
+	}
+	userData, err := cfg.dbQueries.GetUser(context.Background(), email)
+	if err != nil && err != sql.ErrNoRows {
+		utilityRenderConsentPage(response, http.StatusInternalServerError, consentPageData{Request: authRequest, Email: email, Error: "Unable to sign in, please try again."})
+		return
+	}
+	if err == sql.ErrNoRows {
+		err = auth.CheckDummyPasswordHash(values.Get("password"), cfg.passwordParams)
+		if err == auth.ErrHashingBusy {
+			cfg.utilityReleaseThrottles(reserved)
+			response.Header().Set("Retry-After", "1")
+			utilityRenderConsentPage(response, http.StatusServiceUnavailable, consentPageData{Request: authRequest, Email: email, Error: "The server is busy, please try again shortly."})
+			return
+		}
+		cfg.utilityRecordLoginFailure(request, email, uuid.NullUUID{}, failedLoginPassword)
+		utilityRenderConsentPage(response, http.StatusUnauthorized, consentPageData{Request: authRequest, Email: email, Error: "Incorrect email or password."})
+		return
+	}
+	outdated, err := auth.CheckPasswordHash(values.Get("password"), userData.HashedPassword, cfg.passwordParams)
+	if err == auth.ErrHashingBusy {
+		cfg.utilityReleaseThrottles(reserved)
+		response.Header().Set("Retry-After", "1")
+		utilityRenderConsentPage(response, http.StatusServiceUnavailable, consentPageData{Request: authRequest, Email: email, Error: "The server is busy, please try again shortly."})
+		return
+	}
+	if err != nil {
+		cfg.utilityRecordLoginFailure(request, email, uuid.NullUUID{UUID: userData.ID, Valid: true}, failedLoginPassword)
+		utilityRenderConsentPage(response, http.StatusUnauthorized, consentPageData{Request: authRequest, Email: email, Error: "Incorrect email or password."})
+		return
+	}
+	cfg.utilityReleaseThrottles(reserved)
+	if outdated {
+		cfg.utilityRehashPassword(userData, values.Get("password"))
+	}
+	if userData.SuspendedAt.Valid {
+		utilityRenderConsentPage(response, http.StatusForbidden, consentPageData{Request: authRequest, Email: email, Error: "This account is suspended."})
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		utilityRenderConsentPage(response, http.StatusInternalServerError, consentPageData{Request: authRequest, Email: email, Error: "Unable to sign in, please try again."})
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	totpCredential, err := qtx.GetTOTPCredential(context.Background(), userData.ID)
+	if err != nil && err != sql.ErrNoRows {
+		utilityRenderConsentPage(response, http.StatusInternalServerError, consentPageData{Request: authRequest, Email: email, Error: "Unable to sign in, please try again."})
+		return
+	}
+	if err == nil && totpCredential.ConfirmedAt.Valid {
+		//Six digits is a code from the app, anything else is taken as a recovery code.
+		secondFactor := secondFactorRequest{Code: strings.TrimSpace(values.Get("code"))}
+		if len(secondFactor.Code) != 6 {
+			secondFactor = secondFactorRequest{RecoveryCode: secondFactor.Code}
+		}
+		codeGiven := secondFactor.Code != "" || secondFactor.RecoveryCode != ""
+		valid := false
+		//A code is a guess of its own, counted like the one at /api/login/2fa.
+		if codeGiven {
+			reserved, retryAfter, err = cfg.utilityReserveLoginAttempt(request, email)
+			if err == nil && retryAfter > 0 {
+				response.Header().Set("Retry-After", utilityRetryAfterSeconds(retryAfter))
+				utilityRenderConsentPage(response, http.StatusTooManyRequests, consentPageData{Request: authRequest, Email: email, Error: "Too many failed sign ins, please try again in " + utilityRetryAfterSeconds(retryAfter) + " seconds."})
+				return
+			}
+		}
+		if codeGiven && err == nil {
+			valid, err = cfg.utilityCheckSecondFactor(qtx, userData.ID, secondFactor)
+		}
+		if err != nil {
+			utilityRenderConsentPage(response, http.StatusInternalServerError, consentPageData{Request: authRequest, Email: email, Error: "Unable to sign in, please try again."})
+			return
+		}
+		if valid {
+			cfg.utilityReleaseThrottles(reserved)
+		}
+		if !valid {
+			//Leaving the code out is not a guess, the user may not have known they needed one.
+			if codeGiven {
+				cfg.utilityRecordLoginFailure(request, email, uuid.NullUUID{UUID: userData.ID, Valid: true}, failedLoginSecondFactor)
+			}
+			utilityRenderConsentPage(response, http.StatusUnauthorized, consentPageData{Request: authRequest, Email: email, Error: "Enter your password and a current code from your authenticator app, or a recovery code."})
+			return
+		}
+	}
+
+	//Only the hash of the code is stored, the app gets the code itself through the redirect.
+	authorizationCode, err := auth.MakeRefreshToken()
+	if err == nil {
+		err = qtx.CreateOAuthAuthorizationCode(context.Background(), database.CreateOAuthAuthorizationCodeParams{
+			CodeHash:      auth.HashToken(authorizationCode),
+			ExpiresAt:     time.Now().Add(authorizationCodeDuration).UTC(),
+			ClientID:      clientData.ID,
+			UserID:        userData.ID,
+			RedirectUri:   redirectURI,
+			Scopes:        authRequest.Scopes,
+			CodeChallenge: authRequest.CodeChallenge,
+		})
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		utilityRenderConsentPage(response, http.StatusInternalServerError, consentPageData{Request: authRequest, Email: email, Error: "Unable to sign in, please try again."})
+		return
+	}
+
+	//Success
+	cfg.utilityClearLoginFailures(userData.Email)
+	cfg.utilityAudit(request, auditLogin, userData.ID, userData.ID, map[string]any{"client_id": clientData.ID, "scopes": authRequest.Scopes})
+	utilityOAuthRedirect(response, request, redirectURI, url.Values{
+		"code":  {authorizationCode},
+		"state": {authRequest.State},
+	})
+}
+
+func (cfg *apiConfig) handlerOAuthToken(response http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		utilityOAuthError(response, http.StatusBadRequest, "invalid_request", "Did not understand request.")
+		return
+	}
+
+	clientData, err := cfg.utilityAuthenticateOAuthClient(request)
+	if err != nil {
+		utilityOAuthError(response, http.StatusUnauthorized, "invalid_client", "Client authentication failed.")
+		return
+	}
+
+	switch request.PostForm.Get("grant_type") {
+	case "authorization_code":
+		cfg.utilityAuthorizationCodeGrant(response, request, clientData)
+	case "refresh_token":
+		cfg.utilityRefreshTokenGrant(response, request, clientData)
+	default:
+		utilityOAuthError(response, http.StatusBadRequest, "unsupported_grant_type", "Only authorization_code and refresh_token grants are supported.")
+	}
+}
+
+// Starts a new session for the app, the same way a login does, with its refresh token in refresh_tokens.
+func (cfg *apiConfig) utilityIssueOAuthTokens(response http.ResponseWriter, userID uuid.UUID, sessionID uuid.UUID, clientData database.OauthClient, scopes []string, refreshToken string) {
+	accessToken, err := auth.MakeClientJWT(userID, sessionID, clientData.ID, scopes, cfg.jwtKeys)
+	if err != nil {
+		utilityOAuthError(response, http.StatusInternalServerError, "server_error", "Cannot generate access token.")
+		return
+	}
+
+	dataMarshalled, err := json.Marshal(oauthTokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(time.Hour.Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        strings.Join(scopes, " "),
+	})
+	if err != nil {
+		utilityOAuthError(response, http.StatusInternalServerError, "server_error", "Failed create response.")
+		return
+	}
+
+	//Success
+	response.Header().Set("Content-Type", "application/json")
+	response.Header().Set("Cache-Control", "no-store")
+	response.WriteHeader(http.StatusOK)
+	response.Write(dataMarshalled)
+}
+
+func (cfg *apiConfig) utilityAuthorizationCodeGrant(response http.ResponseWriter, request *http.Request, clientData database.OauthClient) {
+	//A malformed verifier is turned away before the code is looked at, so it does not use the code up.
+	if !auth.ValidPKCEValue(request.PostForm.Get("code_verifier")) {
+		utilityOAuthError(response, http.StatusBadRequest, "invalid_request", "The code_verifier is malformed.")
+		return
+	}
+
+	//The code row is locked, so a code cannot be swapped twice at once.
+	tx, err := cfg.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		utilityOAuthError(response, http.StatusInternalServerError, "server_error", "Cannot issue tokens.")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	codeData, err := qtx.GetOAuthAuthorizationCodeForUpdate(context.Background(), auth.HashToken(request.PostForm.Get("code")))
+	if err != nil && err != sql.ErrNoRows {
+		utilityOAuthError(response, http.StatusInternalServerError, "server_error", "Cannot issue tokens.")
+		return
+	}
+	if err == sql.ErrNoRows || codeData.ClientID != clientData.ID {
+		utilityOAuthError(response, http.StatusBadRequest, "invalid_grant", "The authorization code is invalid.")
+		return
+	}
+
+	//A code used a second time has been stolen or replayed, so the tokens it gave out the first time are revoked too.
+	if codeData.UsedAt.Valid {
+		if codeData.FamilyID.Valid {
+			err = qtx.RevokeRefreshTokenFamily(context.Background(), codeData.FamilyID.UUID)
+			if err == nil {
+				err = tx.Commit()
+			}
+			if err != nil {
+				fmt.Printf("Error revoking refresh token family %v: %v\n", codeData.FamilyID.UUID, err)
+			}
+		}
+		cfg.utilityAudit(request, auditRefreshTokenReused, uuid.Nil, codeData.UserID, map[string]any{"client_id": clientData.ID, "session_id": codeData.FamilyID.UUID, "authorization_code": true})
+		utilityOAuthError(response, http.StatusBadRequest, "invalid_grant", "The authorization code has already been used.")
+		return
+	}
+
+	if !codeData.ExpiresAt.After(time.Now().UTC()) || codeData.RedirectUri != request.PostForm.Get("redirect_uri") {
+		utilityOAuthError(response, http.StatusBadRequest, "invalid_grant", "The authorization code is invalid or has expired.")
+		return
+	}
+	if !auth.CheckCodeVerifier(request.PostForm.Get("code_verifier"), codeData.CodeChallenge) {
+		utilityOAuthError(response, http.StatusBadRequest, "invalid_grant", "The code_verifier does not match.")
+		return
+	}
+	userData, err := qtx.GetUserByID(context.Background(), codeData.UserID)
+	if err != nil {
+		utilityOAuthError(response, http.StatusInternalServerError, "server_error", "Cannot issue tokens.")
+		return
+	}
+	if userData.SuspendedAt.Valid {
+		utilityOAuthError(response, http.StatusBadRequest, "invalid_grant", "The account is suspended.")
+		return
+	}
+
+	sessionID := uuid.New()
+	refreshToken, err := auth.MakeRefreshToken()
+	if err == nil {
+		err = qtx.CreateRefreshToken(context.Background(), database.CreateRefreshTokenParams{
+			Token:     refreshToken,
+			CreatedAt: time.Now().UTC(),
+			UpdatedAt: time.Now().UTC(),
+			ExpiresAt: time.Now().Add(refreshTokenDuration).UTC(),
+			UserID:    codeData.UserID,
+			FamilyID:  sessionID,
+			UserAgent: request.UserAgent(),
+			IpAddress: utilityClientIP(request),
+			ClientID:  uuid.NullUUID{UUID: clientData.ID, Valid: true},
+			Scopes:    codeData.Scopes,
+		})
+	}
+	if err == nil {
+		err = qtx.UseOAuthAuthorizationCode(context.Background(), database.UseOAuthAuthorizationCodeParams{
+			CodeHash: codeData.CodeHash,
+			FamilyID: uuid.NullUUID{UUID: sessionID, Valid: true},
+		})
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		utilityOAuthError(response, http.StatusInternalServerError, "server_error", "Cannot issue tokens.")
+		return
+	}
+
+	cfg.utilityIssueOAuthTokens(response, codeData.UserID, sessionID, clientData, codeData.Scopes, refreshToken)
+}
+
+func (cfg *apiConfig) utilityRefreshTokenGrant(response http.ResponseWriter, request *http.Request, clientData database.OauthClient) {
+	//The token row is locked, so two refreshes with the same token cannot both rotate it.
+	tx, err := cfg.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		utilityOAuthError(response, http.StatusInternalServerError, "server_error", "Cannot refresh tokens.")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	refreshTokenData, err := qtx.GetRefreshToken(context.Background(), request.PostForm.Get("refresh_token"))
+	if err != nil && err != sql.ErrNoRows {
+		utilityOAuthError(response, http.StatusInternalServerError, "server_error", "Cannot refresh tokens.")
+		return
+	}
+	//Only the app a token was issued to can use it, and never a login's token.
+	if err == sql.ErrNoRows || !refreshTokenData.ClientID.Valid || refreshTokenData.ClientID.UUID != clientData.ID {
+		utilityOAuthError(response, http.StatusBadRequest, "invalid_grant", "The refresh token is invalid.")
+		return
+	}
+
+	//Reuse means someone else has a copy, just as at /api/refresh.
+	if refreshTokenData.UsedAt.Valid {
+		err = qtx.RevokeRefreshTokenFamily(context.Background(), refreshTokenData.FamilyID)
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			fmt.Printf("Error revoking refresh token family %v: %v\n", refreshTokenData.FamilyID, err)
+		}
+		cfg.utilityAudit(request, auditRefreshTokenReused, uuid.Nil, refreshTokenData.UserID, map[string]any{"client_id": clientData.ID, "session_id": refreshTokenData.FamilyID})
+		utilityOAuthError(response, http.StatusBadRequest, "invalid_grant", "The refresh token is invalid.")
+		return
+	}
+
+	if refreshTokenData.RevokedAt.Valid || !refreshTokenData.ExpiresAt.After(time.Now().UTC()) {
+		utilityOAuthError(response, http.StatusBadRequest, "invalid_grant", "The refresh token is invalid or has expired.")
+		return
+	}
+	userData, err := qtx.GetUserByID(context.Background(), refreshTokenData.UserID)
+	if err != nil {
+		utilityOAuthError(response, http.StatusInternalServerError, "server_error", "Cannot refresh tokens.")
+		return
+	}
+	if userData.SuspendedAt.Valid {
+		utilityOAuthError(response, http.StatusBadRequest, "invalid_grant", "The account is suspended.")
+		return
+	}
+
+	//An app may ask for fewer scopes than it was given, never more. The refresh token keeps them all.
+	scopes := refreshTokenData.Scopes
+	if requested := strings.Fields(request.PostForm.Get("scope")); len(requested) > 0 {
+		for _, scope := range requested {
+			if !slices.Contains(refreshTokenData.Scopes, scope) {
+				utilityOAuthError(response, http.StatusBadRequest, "invalid_scope", "The app was not given the "+scope+" scope.")
+				return
+			}
+		}
+		scopes, _ = utilityParseScopes(requested)
+	}
+
+	newRefreshToken, err := auth.MakeRefreshToken()
+	if err == nil {
+		err = qtx.CreateRefreshToken(context.Background(), database.CreateRefreshTokenParams{
+			Token:     newRefreshToken,
+			CreatedAt: time.Now().UTC(),
+			UpdatedAt: time.Now().UTC(),
+			ExpiresAt: time.Now().Add(refreshTokenDuration).UTC(),
+			UserID:    refreshTokenData.UserID,
+			FamilyID:  refreshTokenData.FamilyID,
+			UserAgent: request.UserAgent(),
+			IpAddress: utilityClientIP(request),
+			ClientID:  refreshTokenData.ClientID,
+			Scopes:    refreshTokenData.Scopes,
+		})
+	}
+	if err == nil {
+		err = qtx.RotateRefreshToken(context.Background(), database.RotateRefreshTokenParams{
+			Token:      refreshTokenData.Token,
+			ReplacedBy: sql.NullString{String: newRefreshToken, Valid: true},
+		})
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		utilityOAuthError(response, http.StatusInternalServerError, "server_error", "Cannot store refresh token.")
+		return
+	}
+
+	cfg.utilityIssueOAuthTokens(response, refreshTokenData.UserID, refreshTokenData.FamilyID, clientData, scopes, newRefreshToken)
+}
+
+// Revokes an app's access or refresh token, which ends the whole session it belongs to.
+// As the spec asks, a token that is unknown or not the app's gets the same answer as one that was revoked.
+func (cfg *apiConfig) handlerOAuthRevoke(response http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		utilityOAuthError(response, http.StatusBadRequest, "invalid_request", "Did not understand request.")
+		return
+	}
+
+	clientData, err := cfg.utilityAuthenticateOAuthClient(request)
+	if err != nil {
+		utilityOAuthError(response, http.StatusUnauthorized, "invalid_client", "Client authentication failed.")
+		return
+	}
+
+	token := request.PostForm.Get("token")
+	familyID := uuid.NullUUID{}
+	var userID uuid.UUID
+	if claims, err := auth.ParseJWT(token, cfg.jwtKeys); err == nil {
+		sessionID, err := uuid.Parse(claims.SessionID)
+		if err == nil && claims.ClientID == clientData.ID.String() {
+			familyID = uuid.NullUUID{UUID: sessionID, Valid: true}
+			userID, _ = uuid.Parse(claims.Subject)
+		}
+	} else {
+		refreshTokenData, err := cfg.dbQueries.GetRefreshToken(context.Background(), token)
+		if err != nil && err != sql.ErrNoRows {
+			utilityOAuthError(response, http.StatusServiceUnavailable, "server_error", "Failed to revoke token.")
+			return
+		}
+		if err == nil && refreshTokenData.ClientID.Valid && refreshTokenData.ClientID.UUID == clientData.ID {
+			familyID = uuid.NullUUID{UUID: refreshTokenData.FamilyID, Valid: true}
+			userID = refreshTokenData.UserID
+		}
+	}
+
+	if familyID.Valid {
+		err = cfg.dbQueries.RevokeRefreshTokenFamily(context.Background(), familyID.UUID)
+		if err != nil {
+			utilityOAuthError(response, http.StatusServiceUnavailable, "server_error", "Failed to revoke token.")
+			return
+		}
+		cfg.utilityAudit(request, auditOAuthTokenRevoked, uuid.Nil, userID, map[string]any{"client_id": clientData.ID, "session_id": familyID.UUID})
+	}
+
+	//Success
+	response.WriteHeader(http.StatusOK)
+}
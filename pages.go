@@ -0,0 +1,117 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github/JohnDirewolf/chirpy/internal/cursor"
+	"github/JohnDirewolf/chirpy/internal/database"
+
+	"github.com/google/uuid"
+)
+
+// Page sizes for any endpoint that pages through results.
+const defaultPageLimit int32 = 20
+const maxPageLimit int32 = 100
+
+// What the client asked for, a page size and where to start from.
+type pageRequest struct {
+	limit           int32
+	pagingBack      bool
+	hasCursor       bool
+	cursorCreatedAt sql.NullTime
+	cursorID        uuid.NullUUID
+}
+
+func utilityParseLimit(rawLimit string) (int32, error) {
+	if rawLimit == "" {
+		return defaultPageLimit, nil
+	}
+	limit, err := strconv.ParseInt(rawLimit, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	if limit < 1 || int32(limit) > maxPageLimit {
+		return 0, fmt.Errorf("limit %d out of range", limit)
+	}
+	return int32(limit), nil
+}
+
+func utilityParsePageRequest(query url.Values) (pageRequest, error) {
+	page := pageRequest{}
+
+	//Check how many we want on a page, a missing limit gets the default.
+	limit, err := utilityParseLimit(query.Get("limit"))
+	if err != nil {
+		return pageRequest{}, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+	}
+	page.limit = limit
+
+	//We can page forward with after, or back with before, but not both at once.
+	afterCursor := query.Get("after")
+	beforeCursor := query.Get("before")
+	if afterCursor != "" && beforeCursor != "" {
+		return pageRequest{}, errors.New("use either before or after, not both")
+	}
+	page.pagingBack = beforeCursor != ""
+	rawCursor := afterCursor
+	if page.pagingBack {
+		rawCursor = beforeCursor
+	}
+
+	if rawCursor != "" {
+		createdAt, id, err := cursor.Decode(rawCursor)
+		if err != nil {
+			return pageRequest{}, errors.New("cursor is malformed")
+		}
+		page.hasCursor = true
+		page.cursorCreatedAt = sql.NullTime{Time: createdAt, Valid: true}
+		page.cursorID = uuid.NullUUID{UUID: id, Valid: true}
+	}
+	return page, nil
+}
+
+// Queries ask for one more row than fits on the page, if we get it there is another page.
+// This trims that row off, puts a backwards page into feed order, and works out the cursors for the pages either side.
+func utilityPageCursors[T any](items []T, page pageRequest, key func(T) (time.Time, uuid.UUID)) ([]T, string, string) {
+	hasMore := len(items) > int(page.limit)
+	if hasMore {
+		items = items[:page.limit]
+	}
+	//When paging back the query returns the page backwards, flip it back into feed order.
+	if page.pagingBack {
+		slices.Reverse(items)
+	}
+	if len(items) == 0 {
+		return items, "", ""
+	}
+
+	var nextCursor, prevCursor string
+	firstCreatedAt, firstID := key(items[0])
+	lastCreatedAt, lastID := key(items[len(items)-1])
+	if page.pagingBack {
+		//We came back from the next page, so it is always there.
+		nextCursor = cursor.Encode(lastCreatedAt, lastID)
+		if hasMore {
+			prevCursor = cursor.Encode(firstCreatedAt, firstID)
+		}
+	} else {
+		if hasMore {
+			nextCursor = cursor.Encode(lastCreatedAt, lastID)
+		}
+		//Without a cursor this is the first page so there is nothing before it.
+		if page.hasCursor {
+			prevCursor = cursor.Encode(firstCreatedAt, firstID)
+		}
+	}
+	return items, nextCursor, prevCursor
+}
+
+func utilityChirpKey(chirp database.Chirp) (time.Time, uuid.UUID) {
+	return chirp.CreatedAt, chirp.ID
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github/JohnDirewolf/chirpy/internal/auth"
+	"github/JohnDirewolf/chirpy/internal/database"
+	"github/JohnDirewolf/chirpy/internal/mailer"
+
+	"github.com/google/uuid"
+)
+
+// How long a reset link works for.
+const passwordResetDuration = time.Hour
+
+// Reset emails are throttled like logins, by the email asked for and the address asking, so the endpoint
+// cannot be used to flood someone's inbox. Every request counts, there is nothing to get right.
+// Resent verification emails count against the same limits.
+const (
+	resetThrottleEmail = "reset_email"
+	resetThrottleIP    = "reset_ip"
+)
+
+const emailFreeResetRequests = 3
+const ipFreeResetRequests = 10
+const maxResetLockout = time.Hour
+
+// Counts an email about to be sent to email, returning how long to wait when there have been too many.
+func (cfg *apiConfig) utilityReserveEmailSend(request *http.Request, email string) (time.Duration, error) {
+	_, retryAfter, err := cfg.utilityReserveThrottles([]loginThrottle{
+		{kind: resetThrottleEmail, key: utilityAccountThrottleKey(email), freeFailures: emailFreeResetRequests, maxLockout: maxResetLockout},
+		{kind: resetThrottleIP, key: utilityIPThrottleKey(utilityClientIP(request)), freeFailures: ipFreeResetRequests, maxLockout: maxResetLockout},
+	})
+	return retryAfter, err
+}
+
+// Sends an email without holding up the response, so how long a request takes does not give away
+// whether an email was sent. Failures can only be logged.
+func (cfg *apiConfig) utilitySendMail(message mailer.Message) {
+	go func() {
+		err := cfg.mailer.Send(message)
+		if err != nil {
+			fmt.Printf("Error sending email to %v: %v\n", message.To, err)
+		}
+	}()
+}
+
+// Builds a link into the client app at APP_URL carrying a token, like the ones in reset emails.
+func (cfg *apiConfig) utilityAppLink(path string, token string) string {
+	return cfg.appURL + path + "?" + url.Values{"token": {token}}.Encode()
+}
+
+func (cfg *apiConfig) handlerForgotPassword(response http.ResponseWriter, request *http.Request) {
+	type requestParameters struct {
+		Email string `json:"email"`
+	}
+
+	decoder := json.NewDecoder(request.Body)
+	requestParams := requestParameters{}
+	err := decoder.Decode(&requestParams)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: Did not understand request."))
+		return
+	}
+
+	retryAfter, err := cfg.utilityReserveEmailSend(request, requestParams.Email)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Unable to reset password."))
+		return
+	}
+	if retryAfter > 0 {
+		response.Header().Set("Retry-After", utilityRetryAfterSeconds(retryAfter))
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusTooManyRequests)
+		response.Write([]byte("Too Many Requests: Too many reset requests, please try again in " + utilityRetryAfterSeconds(retryAfter) + " seconds."))
+		return
+	}
+
+	//Whether or not the email has an account, the answer is the same and comes straight away, the looking up
+	//and sending happens after, so neither the answer nor how long it takes tells who is signed up.
+	go cfg.utilitySendPasswordReset(requestParams.Email)
+
+	//Success
+	response.WriteHeader(http.StatusAccepted)
+}
+
+// Emails a reset link if the email has an account. Nobody is waiting on this, so failures can only be logged.
+func (cfg *apiConfig) utilitySendPasswordReset(email string) {
+	userData, err := cfg.dbQueries.GetUser(context.Background(), email)
+	if err == sql.ErrNoRows {
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error looking up user for password reset: %v\n", err)
+		return
+	}
+
+	resetToken, err := auth.MakeRefreshToken()
+	if err == nil {
+		//Only the hash is stored, the token itself is only ever in the email.
+		err = cfg.dbQueries.CreatePasswordResetToken(context.Background(), database.CreatePasswordResetTokenParams{
+			TokenHash: auth.HashToken(resetToken),
+			ExpiresAt: time.Now().Add(passwordResetDuration).UTC(),
+			UserID:    userData.ID,
+		})
+	}
+	if err != nil {
+		fmt.Printf("Error creating password reset for user %v: %v\n", userData.ID, err)
+		return
+	}
+
+	err = cfg.mailer.Send(mailer.Message{
+		To:      userData.Email,
+		Subject: "Reset your Chirpy password",
+		Body: "Someone asked to reset the password for your Chirpy account.\n\n" +
+			"To choose a new password, follow this link within the hour:\n" +
+			cfg.utilityAppLink("/reset-password", resetToken) + "\n\n" +
+			"If it was not you, you can ignore this email, your password has not changed.\n",
+	})
+	if err != nil {
+		fmt.Printf("Error sending email to %v: %v\n", userData.Email, err)
+	}
+}
+
+func (cfg *apiConfig) handlerResetPassword(response http.ResponseWriter, request *http.Request) {
+	type requestParameters struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	decoder := json.NewDecoder(request.Body)
+	requestParams := requestParameters{}
+	err := decoder.Decode(&requestParams)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: Did not understand request."))
+		return
+	}
+
+	if requestParams.Password == "" {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: A new password is required."))
+		return
+	}
+	err = cfg.passwordPolicy.Check(requestParams.Password)
+	if err != nil {
+		cfg.utilityWritePasswordRejected(response, err)
+		return
+	}
+
+	//The token row is locked, so it cannot be used twice at once.
+	tx, err := cfg.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Unable to reset password."))
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	resetTokenData, err := qtx.GetPasswordResetTokenForUpdate(context.Background(), auth.HashToken(requestParams.Token))
+	if err != nil && err != sql.ErrNoRows {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Unable to reset password."))
+		return
+	}
+	if err == sql.ErrNoRows || resetTokenData.UsedAt.Valid || !resetTokenData.ExpiresAt.After(time.Now().UTC()) {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: This reset link is invalid or has expired."))
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(requestParams.Password, cfg.passwordParams)
+	if err == auth.ErrHashingBusy {
+		utilityWriteHashingBusy(response)
+		return
+	}
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Unable to reset password."))
+		return
+	}
+
+	//Every reset link the user has stops working, and so does every session and token, whoever knew the old password is locked out.
+	err = qtx.UpdateUserPassword(context.Background(), database.UpdateUserPasswordParams{
+		HashedPassword: hashedPassword,
+		ID:             resetTokenData.UserID,
+	})
+	if err == nil {
+		err = qtx.UsePasswordResetTokens(context.Background(), resetTokenData.UserID)
+	}
+	if err == nil {
+		err = utilityRevokeAllAccess(qtx, resetTokenData.UserID, uuid.Nil)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Unable to reset password."))
+		return
+	}
+	cfg.utilityAudit(request, auditPasswordReset, uuid.Nil, resetTokenData.UserID, nil)
+
+	//Success
+	response.WriteHeader(http.StatusNoContent)
+}
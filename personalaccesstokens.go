@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github/JohnDirewolf/chirpy/internal/auth"
+	"github/JohnDirewolf/chirpy/internal/database"
+
+	"github.com/google/uuid"
+)
+
+const maxTokenNameLength = 100
+
+// The token itself is only in the response when it is made, after that only its hash is kept.
+type personalAccessTokenResponse struct {
+	Id         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Token      string     `json:"token,omitempty"`
+}
+
+func utilityNullTime(nullTime sql.NullTime) *time.Time {
+	if !nullTime.Valid {
+		return nil
+	}
+	return &nullTime.Time
+}
+
+func utilityPersonalAccessTokenResponse(tokenData database.PersonalAccessToken) personalAccessTokenResponse {
+	return personalAccessTokenResponse{
+		Id:         tokenData.ID,
+		Name:       tokenData.Name,
+		Scopes:     tokenData.Scopes,
+		CreatedAt:  tokenData.CreatedAt,
+		ExpiresAt:  utilityNullTime(tokenData.ExpiresAt),
+		LastUsedAt: utilityNullTime(tokenData.LastUsedAt),
+	}
+}
+
+func (cfg *apiConfig) handlerCreatePersonalAccessToken(response http.ResponseWriter, request *http.Request) {
+	//Validate credentials sent.
+	//Get the user token from the request.
+	userToken, err := auth.GetBearerToken(request.Header)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusUnauthorized)
+		response.Write([]byte("Unathorized: Please login first."))
+		return
+	}
+
+	//Only a login can make tokens, so a token can never be used to make itself a stronger one.
+	userID, err := cfg.utilityValidateJWT(userToken)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusUnauthorized)
+		response.Write([]byte("Unathorized: credentials invalid. Please login again."))
+		return
+	}
+
+	type requestParameters struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+		//Optional, without it the token works until it is revoked.
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+
+	decoder := json.NewDecoder(request.Body)
+	requestParams := requestParameters{}
+	err = decoder.Decode(&requestParams)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: Did not understand request."))
+		return
+	}
+
+	name := strings.TrimSpace(requestParams.Name)
+	if name == "" || utf8.RuneCountInString(name) > maxTokenNameLength {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte(fmt.Sprintf("Bad Request: A token needs a name of up to %d characters.", maxTokenNameLength)))
+		return
+	}
+	scopes, err := utilityParseScopes(requestParams.Scopes)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: " + err.Error() + "."))
+		return
+	}
+	if len(scopes) == 0 {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: A token needs at least one scope, one of " + strings.Join(knownScopes, ", ") + "."))
+		return
+	}
+	expiresAt := sql.NullTime{}
+	if requestParams.ExpiresAt != nil {
+		if !requestParams.ExpiresAt.After(time.Now()) {
+			response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			response.WriteHeader(http.StatusBadRequest)
+			response.Write([]byte("Bad Request: Expiry must be in the future."))
+			return
+		}
+		expiresAt = sql.NullTime{Time: requestParams.ExpiresAt.UTC(), Valid: true}
+	}
+
+	personalAccessToken, err := auth.MakePersonalAccessToken()
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Unable to generate token."))
+		return
+	}
+
+	tokenData, err := cfg.dbQueries.CreatePersonalAccessToken(context.Background(), database.CreatePersonalAccessTokenParams{
+		UserID:    userID,
+		Name:      name,
+		TokenHash: auth.HashToken(personalAccessToken),
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Unable to store token."))
+		return
+	}
+
+	tokenResponse := utilityPersonalAccessTokenResponse(tokenData)
+	tokenResponse.Token = personalAccessToken
+	dataMarshalled, err := json.Marshal(tokenResponse)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Failed create response."))
+		return
+	}
+
+	//Success
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusCreated)
+	response.Write(dataMarshalled)
+}
+
+func (cfg *apiConfig) handlerGetPersonalAccessTokens(response http.ResponseWriter, request *http.Request) {
+	//Validate credentials sent.
+	//Get the user token from the request.
+	userToken, err := auth.GetBearerToken(request.Header)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusUnauthorized)
+		response.Write([]byte("Unathorized: Please login first."))
+		return
+	}
+
+	userID, err := cfg.utilityValidateJWT(userToken)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusUnauthorized)
+		response.Write([]byte("Unathorized: credentials invalid. Please login again."))
+		return
+	}
+
+	tokenList, err := cfg.dbQueries.GetPersonalAccessTokens(context.Background(), userID)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not retrieve tokens."))
+		return
+	}
+
+	tokenListResponse := make([]personalAccessTokenResponse, 0, len(tokenList))
+	for _, tokenData := range tokenList {
+		tokenListResponse = append(tokenListResponse, utilityPersonalAccessTokenResponse(tokenData))
+	}
+
+	dataMarshalled, err := json.Marshal(tokenListResponse)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Failed create response."))
+		return
+	}
+
+	//Success
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusOK)
+	response.Write(dataMarshalled)
+}
+
+func (cfg *apiConfig) handlerRevokePersonalAccessToken(response http.ResponseWriter, request *http.Request) {
+	//Validate credentials sent.
+	//Get the user token from the request.
+	userToken, err := auth.GetBearerToken(request.Header)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusUnauthorized)
+		response.Write([]byte("Unathorized: Please login first."))
+		return
+	}
+
+	userID, err := cfg.utilityValidateJWT(userToken)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusUnauthorized)
+		response.Write([]byte("Unathorized: credentials invalid. Please login again."))
+		return
+	}
+
+	tokenID, err := uuid.Parse(request.PathValue("tokenID"))
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: token id is malformed."))
+		return
+	}
+
+	rowsRevoked, err := cfg.dbQueries.RevokePersonalAccessToken(context.Background(), database.RevokePersonalAccessTokenParams{
+		ID:     tokenID,
+		UserID: userID,
+	})
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Failed to revoke token."))
+		return
+	}
+	if rowsRevoked == 0 {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusNotFound)
+		response.Write([]byte("Not Found: Token not found."))
+		return
+	}
+	cfg.utilityAudit(request, auditPersonalAccessTokenRevoked, userID, userID, map[string]any{"token_id": tokenID})
+
+	//Success
+	response.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github/JohnDirewolf/chirpy/internal/entities"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Limits on what a profile can hold.
+const maxDisplayNameLength = 50
+const maxBioLength = 160
+
+type profileResponse struct {
+	Id             uuid.UUID      `json:"id"`
+	Handle         string         `json:"handle"`
+	DisplayName    string         `json:"display_name"`
+	Bio            string         `json:"bio"`
+	Avatar         *mediaResponse `json:"avatar"`
+	CreatedAt      time.Time      `json:"created_at"`
+	FollowerCount  int64          `json:"follower_count"`
+	FollowingCount int64          `json:"following_count"`
+}
+
+// Just enough about an author to show next to their chirp.
+type authorSummaryResponse struct {
+	Id          uuid.UUID `json:"id"`
+	Handle      string    `json:"handle"`
+	DisplayName string    `json:"display_name"`
+	AvatarUrl   string    `json:"avatar_url,omitempty"`
+}
+
+// New users who do not pick a handle get a random one they can change later.
+func utilityGenerateHandle() string {
+	return "user_" + strings.ReplaceAll(uuid.New().String(), "-", "")[:10]
+}
+
+// Reports whether the database refused a write because a unique value, like a handle, is already taken.
+func utilityIsUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// Clients ask for author profiles on chirps with ?expand=author, otherwise chirps just carry the user_id.
+func utilityExpandAuthor(request *http.Request) bool {
+	return slices.Contains(strings.Split(request.URL.Query().Get("expand"), ","), "author")
+}
+
+// Loads the profile summaries for a set of authors, keyed by user.
+func (cfg *apiConfig) utilityAuthorSummaries(userIDs []uuid.UUID) (map[uuid.UUID]authorSummaryResponse, error) {
+	authorList, err := cfg.dbQueries.GetAuthorSummaries(context.Background(), userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	authorsByID := make(map[uuid.UUID]authorSummaryResponse)
+	for _, author := range authorList {
+		authorSummary := authorSummaryResponse{
+			Id:          author.ID,
+			Handle:      author.Handle,
+			DisplayName: author.DisplayName,
+		}
+		if author.AvatarStorageKey.Valid {
+			authorSummary.AvatarUrl = cfg.mediaStore.URL(author.AvatarStorageKey.String)
+		}
+		authorsByID[author.ID] = authorSummary
+	}
+	return authorsByID, nil
+}
+
+func (cfg *apiConfig) handlerGetUserProfile(response http.ResponseWriter, request *http.Request) {
+	//Handles can be given with or without the @.
+	handle := strings.TrimPrefix(request.PathValue("handle"), "@")
+
+	user, err := cfg.dbQueries.GetUserByHandle(context.Background(), handle)
+	if err == sql.ErrNoRows {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusNotFound)
+		response.Write([]byte("Not Found: User not found."))
+		return
+	}
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not retrieve user."))
+		return
+	}
+
+	followerCount, err := cfg.dbQueries.CountFollowers(context.Background(), user.ID)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not retrieve user."))
+		return
+	}
+	followingCount, err := cfg.dbQueries.CountFollowing(context.Background(), user.ID)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not retrieve user."))
+		return
+	}
+
+	profile := profileResponse{
+		Id:             user.ID,
+		Handle:         user.Handle,
+		DisplayName:    user.DisplayName,
+		Bio:            user.Bio,
+		CreatedAt:      user.CreatedAt,
+		FollowerCount:  followerCount,
+		FollowingCount: followingCount,
+	}
+
+	if user.AvatarMediaID.Valid {
+		avatar, err := cfg.dbQueries.GetMediaByID(context.Background(), user.AvatarMediaID.UUID)
+		if err != nil {
+			response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			response.WriteHeader(http.StatusInternalServerError)
+			response.Write([]byte("Internal Server Error: Could not retrieve user."))
+			return
+		}
+		thumbnails, err := cfg.dbQueries.GetMediaThumbnails(context.Background(), []uuid.UUID{avatar.ID})
+		if err != nil {
+			response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			response.WriteHeader(http.StatusInternalServerError)
+			response.Write([]byte("Internal Server Error: Could not retrieve user."))
+			return
+		}
+		avatarResponse := cfg.utilityMediaResponse(avatar, thumbnails)
+		profile.Avatar = &avatarResponse
+	}
+
+	dataMarshalled, err := json.Marshal(profile)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Failed create response."))
+		return
+	}
+
+	//Success
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusOK)
+	response.Write(dataMarshalled)
+}
+
+// Checks the profile fields of an update against our limits, returning what is wrong with them.
+func utilityValidateProfile(handle string, displayName string, bio string) error {
+	if !entities.ValidHandle(handle) {
+		return errors.New("handle must be 3 to 30 letters, numbers or underscores")
+	}
+	if utf8.RuneCountInString(displayName) > maxDisplayNameLength {
+		return fmt.Errorf("display name is longer then %d characters", maxDisplayNameLength)
+	}
+	if utf8.RuneCountInString(bio) > maxBioLength {
+		return fmt.Errorf("bio is longer then %d characters", maxBioLength)
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"github/JohnDirewolf/chirpy/internal/auth"
+	"github/JohnDirewolf/chirpy/internal/database"
+
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerRechirp(response http.ResponseWriter, request *http.Request) {
+	//Validate credentials sent.
+	//Get the user token from the request.
+	userToken, err := auth.GetBearerToken(request.Header)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusUnauthorized)
+		response.Write([]byte("Unathorized: Please login first."))
+		return
+	}
+
+	userID, err := cfg.utilityAuthenticate(userToken, scopeChirpsWrite)
+	if err != nil {
+		utilityWriteAuthError(response, err, scopeChirpsWrite)
+		return
+	}
+
+	//Posting can be held back until the user has confirmed their email.
+	canPost, err := cfg.utilityCanPost(userID)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not check user."))
+		return
+	}
+	if !canPost {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusForbidden)
+		response.Write([]byte("Forbidden: Please verify your email before posting."))
+		return
+	}
+
+	chirpID, err := uuid.Parse(request.PathValue("chirpID"))
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Error! Invalid Chirp ID."))
+		return
+	}
+
+	//The shared chirp is locked, so the same user cannot rechirp it twice at once and it cannot be deleted under us.
+	tx, err := cfg.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Error! Server error saving rechirp."))
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	chirp, err := qtx.GetChirpByIDForUpdate(context.Background(), chirpID)
+	//Rechirping a rechirp shares the chirp it points at.
+	if err == nil && chirp.RechirpOf.Valid {
+		chirp, err = qtx.GetChirpByIDForUpdate(context.Background(), chirp.RechirpOf.UUID)
+	}
+	if err == sql.ErrNoRows || (err == nil && chirp.DeletedAt.Valid) {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusNotFound)
+		response.Write([]byte("Error! Chirp not found."))
+		return
+	}
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Error! Server error fetching Chirp."))
+		return
+	}
+
+	_, err = qtx.GetRechirp(context.Background(), database.GetRechirpParams{
+		UserID:    userID,
+		RechirpOf: uuid.NullUUID{UUID: chirp.ID, Valid: true},
+	})
+	if err == nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusConflict)
+		response.Write([]byte("Conflict: You have already rechirped this Chirp."))
+		return
+	}
+	if err != sql.ErrNoRows {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Error! Server error fetching Chirp."))
+		return
+	}
+
+	//A rechirp is a chirp of our own with no body, pointing at the one we share.
+	rechirp, err := qtx.CreateChirp(context.Background(), database.CreateChirpParams{
+		Body:      "",
+		UserID:    userID,
+		RechirpOf: uuid.NullUUID{UUID: chirp.ID, Valid: true},
+	})
+	if err == nil {
+		err = qtx.IncrementRechirpCount(context.Background(), chirp.ID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Error! Server error saving rechirp."))
+		return
+	}
+
+	responseList, err := cfg.utilityChirpListResponse(request, []database.Chirp{rechirp})
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not create response."))
+		return
+	}
+
+	dataMarshalled, err := json.Marshal(responseList[0])
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not create response."))
+		return
+	}
+
+	//Success
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusCreated)
+	response.Write(dataMarshalled)
+}
+
+func (cfg *apiConfig) handlerUndoRechirp(response http.ResponseWriter, request *http.Request) {
+	//Validate credentials sent.
+	//Get the user token from the request.
+	userToken, err := auth.GetBearerToken(request.Header)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusUnauthorized)
+		response.Write([]byte("Unathorized: Please login first."))
+		return
+	}
+
+	userID, err := cfg.utilityAuthenticate(userToken, scopeChirpsWrite)
+	if err != nil {
+		utilityWriteAuthError(response, err, scopeChirpsWrite)
+		return
+	}
+
+	chirpID, err := uuid.Parse(request.PathValue("chirpID"))
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Error! Invalid Chirp ID."))
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Error! Server error removing rechirp."))
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	//Lock the shared chirp the same way rechirping does before we touch its count.
+	_, err = qtx.GetChirpByIDForUpdate(context.Background(), chirpID)
+	if err == sql.ErrNoRows {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusNotFound)
+		response.Write([]byte("Error! Chirp not found."))
+		return
+	}
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Error! Server error fetching Chirp."))
+		return
+	}
+
+	rechirp, err := qtx.GetRechirp(context.Background(), database.GetRechirpParams{
+		UserID:    userID,
+		RechirpOf: uuid.NullUUID{UUID: chirpID, Valid: true},
+	})
+	if err == sql.ErrNoRows {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusNotFound)
+		response.Write([]byte("Not Found: You have not rechirped this Chirp."))
+		return
+	}
+	if err == nil {
+		err = utilityDeleteChirp(qtx, rechirp)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Error! Server error removing rechirp."))
+		return
+	}
+
+	//Success
+	response.WriteHeader(http.StatusNoContent)
+}
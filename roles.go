@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github/JohnDirewolf/chirpy/internal/auth"
+	"github/JohnDirewolf/chirpy/internal/database"
+
+	"github.com/google/uuid"
+)
+
+// What a user is trusted with. Each role can do everything the ones before it can:
+// moderators can also delete anyone's chirps, admins can also use everything under /admin.
+const (
+	roleUser      = "user"
+	roleModerator = "moderator"
+	roleAdmin     = "admin"
+)
+
+var knownRoles = []string{roleUser, roleModerator, roleAdmin}
+
+// Reports whether the caller has role, or one above it.
+func (caller authCaller) hasRole(role string) bool {
+	callerRank := slices.Index(knownRoles, caller.Role)
+	return callerRank >= 0 && callerRank >= slices.Index(knownRoles, role)
+}
+
+type callerContextKey struct{}
+
+// Lets only a login with role, or one above it, through to next. The caller is put on the request
+// for next to pick up with utilityRequestCaller.
+func (cfg *apiConfig) middlewareRequireRole(role string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		userToken, err := auth.GetBearerToken(request.Header)
+		if err != nil {
+			response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			response.WriteHeader(http.StatusUnauthorized)
+			response.Write([]byte("Unathorized: Please login first."))
+			return
+		}
+
+		//Like the other routes only a login can use, personal access tokens are not accepted and apps are turned away.
+		caller, err := cfg.utilityValidateAccessToken(userToken)
+		if err != nil {
+			response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			response.WriteHeader(http.StatusUnauthorized)
+			response.Write([]byte("Unathorized: credentials invalid. Please login again."))
+			return
+		}
+		if !caller.isLogin() || !caller.hasRole(role) {
+			response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			response.WriteHeader(http.StatusForbidden)
+			response.Write([]byte("Forbidden: This needs the " + role + " role."))
+			return
+		}
+
+		next.ServeHTTP(response, request.WithContext(context.WithValue(request.Context(), callerContextKey{}, caller)))
+	})
+}
+
+// The caller middlewareRequireRole let through.
+func utilityRequestCaller(request *http.Request) authCaller {
+	caller, _ := request.Context().Value(callerContextKey{}).(authCaller)
+	return caller
+}
+
+func (cfg *apiConfig) handlerSetUserRole(response http.ResponseWriter, request *http.Request) {
+	caller := utilityRequestCaller(request)
+
+	userID, err := uuid.Parse(request.PathValue("userID"))
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: user id is malformed."))
+		return
+	}
+	//So there is always at least the admin making the change left.
+	if userID == caller.UserID {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusForbidden)
+		response.Write([]byte("Forbidden: Admins cannot change their own role."))
+		return
+	}
+
+	type requestParameters struct {
+		Role string `json:"role"`
+	}
+
+	decoder := json.NewDecoder(request.Body)
+	requestParams := requestParameters{}
+	err = decoder.Decode(&requestParams)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: Did not understand request."))
+		return
+	}
+	if !slices.Contains(knownRoles, requestParams.Role) {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: Role must be one of " + strings.Join(knownRoles, ", ") + "."))
+		return
+	}
+
+	//Access tokens carry the role, so the user's sessions are ended and the new role applies from their next login.
+	tx, err := cfg.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Failed to change role."))
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	rowsUpdated, err := qtx.SetUserRole(context.Background(), database.SetUserRoleParams{
+		ID:   userID,
+		Role: requestParams.Role,
+	})
+	if err == nil && rowsUpdated == 0 {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusNotFound)
+		response.Write([]byte("Not Found: User not found."))
+		return
+	}
+	if err == nil {
+		err = qtx.RevokeAllSessions(context.Background(), userID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		fmt.Printf("Error changing role of user %v: %v\n", userID, err)
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Failed to change role."))
+		return
+	}
+	cfg.utilityAudit(request, auditAdminRoleChanged, caller.UserID, userID, map[string]any{"role": requestParams.Role})
+
+	//Success
+	response.WriteHeader(http.StatusNoContent)
+}
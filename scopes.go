@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github/JohnDirewolf/chirpy/internal/auth"
+
+	"github.com/google/uuid"
+)
+
+// What a token can be allowed to do. A login can do everything, other tokens only what they were given.
+const (
+	scopeChirpsRead   = "chirps:read"
+	scopeChirpsWrite  = "chirps:write"
+	scopeProfileWrite = "profile:write"
+)
+
+var knownScopes = []string{scopeChirpsRead, scopeChirpsWrite, scopeProfileWrite}
+
+// The token was fine but was not given the scope the route needs.
+var errMissingScope = errors.New("token does not have the scope needed")
+
+// Who is making a request, and what with.
+type authCaller struct {
+	UserID uuid.UUID
+	//Set for a login or an app, personal access tokens are not sessions.
+	SessionID uuid.UUID
+	//Only set for an app the user let in through OAuth.
+	ClientID uuid.UUID
+	//Nil for a login, which can do anything.
+	Scopes []string
+	//Only a login carries the user's role, tokens made for bots and apps are always roleUser.
+	Role string
+}
+
+func (caller authCaller) hasScope(scope string) bool {
+	return caller.Scopes == nil || slices.Contains(caller.Scopes, scope)
+}
+
+// Reports whether the caller logged in, rather than using a token made for a bot, integration or app.
+func (caller authCaller) isLogin() bool {
+	return caller.Scopes == nil
+}
+
+// Accepts a login's or an app's access token, or a personal access token, and checks it carries the scope the route needs.
+// Routes that look after the account itself, like sessions and two-factor, stay on utilityValidateJWT so only a login can use them.
+func (cfg *apiConfig) utilityAuthenticateCaller(userToken string, scope string) (authCaller, error) {
+	var caller authCaller
+	if strings.HasPrefix(userToken, auth.PersonalAccessTokenPrefix) {
+		tokenData, err := cfg.dbQueries.GetActivePersonalAccessToken(context.Background(), auth.HashToken(userToken))
+		if err == sql.ErrNoRows {
+			return authCaller{}, errors.New("personal access token is not valid")
+		}
+		if err != nil {
+			return authCaller{}, err
+		}
+		err = cfg.dbQueries.TouchPersonalAccessToken(context.Background(), tokenData.ID)
+		if err != nil {
+			return authCaller{}, err
+		}
+		caller = authCaller{UserID: tokenData.UserID, Scopes: tokenData.Scopes, Role: roleUser}
+	} else {
+		var err error
+		caller, err = cfg.utilityValidateAccessToken(userToken)
+		if err != nil {
+			return authCaller{}, err
+		}
+	}
+
+	if !caller.hasScope(scope) {
+		return authCaller{}, errMissingScope
+	}
+	return caller, nil
+}
+
+// Answers a request whose token utilityAuthenticate turned down, 403 if it only lacked the scope, 401 otherwise.
+func utilityWriteAuthError(response http.ResponseWriter, err error, scope string) {
+	response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+	if err == errMissingScope {
+		response.WriteHeader(http.StatusForbidden)
+		response.Write([]byte("Forbidden: This token does not have the " + scope + " scope."))
+		return
+	}
+	response.WriteHeader(http.StatusUnauthorized)
+	response.Write([]byte("Unathorized: credentials invalid. Please login again."))
+}
+
+func (cfg *apiConfig) utilityAuthenticate(userToken string, scope string) (uuid.UUID, error) {
+	caller, err := cfg.utilityAuthenticateCaller(userToken, scope)
+	return caller.UserID, err
+}
+
+// Keeps the known scopes, in the order they are listed, and reports any that are not known.
+func utilityParseScopes(requested []string) ([]string, error) {
+	scopes := make([]string, 0, len(requested))
+	for _, scope := range requested {
+		if !slices.Contains(knownScopes, scope) {
+			return nil, errors.New("unknown scope " + scope)
+		}
+	}
+	for _, scope := range knownScopes {
+		if slices.Contains(requested, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes, nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github/JohnDirewolf/chirpy/internal/cursor"
+	"github/JohnDirewolf/chirpy/internal/database"
+	"github/JohnDirewolf/chirpy/internal/search"
+
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerSearchChirps(response http.ResponseWriter, request *http.Request) {
+	query, err := search.Parse(request.URL.Query().Get("q"))
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: " + err.Error() + "."))
+		return
+	}
+
+	limit, err := utilityParseLimit(request.URL.Query().Get("limit"))
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte(fmt.Sprintf("Bad Request: limit must be between 1 and %d.", maxPageLimit)))
+		return
+	}
+
+	//Results are ranked, so the pages only go forward from the best match.
+	if request.URL.Query().Get("before") != "" {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: search results can only be paged with after."))
+		return
+	}
+
+	searchParams := database.SearchChirpsParams{
+		Tags:      query.Tags,
+		PageLimit: limit + 1,
+	}
+	if query.Text != "" {
+		searchParams.Query = sql.NullString{String: query.Text, Valid: true}
+	}
+	if !query.Since.IsZero() {
+		searchParams.Since = sql.NullTime{Time: query.Since, Valid: true}
+	}
+	if !query.Until.IsZero() {
+		searchParams.Until = sql.NullTime{Time: query.Until, Valid: true}
+	}
+	if rawCursor := request.URL.Query().Get("after"); rawCursor != "" {
+		rank, createdAt, id, err := cursor.DecodeRanked(rawCursor)
+		if err != nil {
+			response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			response.WriteHeader(http.StatusBadRequest)
+			response.Write([]byte("Bad Request: cursor is malformed."))
+			return
+		}
+		searchParams.CursorRank = sql.NullFloat64{Float64: float64(rank), Valid: true}
+		searchParams.CursorCreatedAt = sql.NullTime{Time: createdAt, Valid: true}
+		searchParams.CursorID = uuid.NullUUID{UUID: id, Valid: true}
+	}
+
+	//from: takes a user's handle or their ID.
+	var resultList []database.SearchChirpsRow
+	authorFound := true
+	if query.From != "" {
+		authorID, err := uuid.Parse(query.From)
+		if err != nil {
+			author, err := cfg.dbQueries.GetUserByHandle(context.Background(), query.From)
+			if err != nil && err != sql.ErrNoRows {
+				response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+				response.WriteHeader(http.StatusInternalServerError)
+				response.Write([]byte("Internal Server Error: Could not search Chirps."))
+				return
+			}
+			authorFound = err == nil
+			authorID = author.ID
+		}
+		searchParams.UserID = uuid.NullUUID{UUID: authorID, Valid: true}
+	}
+
+	//Nobody by that name has chirped anything, so there is nothing to find.
+	if authorFound {
+		resultList, err = cfg.dbQueries.SearchChirps(context.Background(), searchParams)
+		if err != nil {
+			response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			response.WriteHeader(http.StatusInternalServerError)
+			response.Write([]byte("Internal Server Error: Could not search Chirps."))
+			return
+		}
+	}
+
+	//We asked for one more than fits, if it came back there is another page.
+	var nextCursor string
+	if len(resultList) > int(limit) {
+		resultList = resultList[:limit]
+		lastResult := resultList[len(resultList)-1]
+		nextCursor = cursor.EncodeRanked(lastResult.Rank, lastResult.Chirp.CreatedAt, lastResult.Chirp.ID)
+	}
+
+	chirpList := make([]database.Chirp, 0, len(resultList))
+	for _, result := range resultList {
+		chirpList = append(chirpList, result.Chirp)
+	}
+
+	chripListResponse, err := cfg.utilityChirpListResponse(request, chirpList)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not search Chirps."))
+		return
+	}
+
+	chripListMarshalled, err := json.Marshal(chirpsPageResponse{
+		Chirps:     chripListResponse,
+		NextCursor: nextCursor,
+	})
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not process Chirps to JSON."))
+		return
+	}
+
+	//Success
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusOK)
+	response.Write(chripListMarshalled)
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github/JohnDirewolf/chirpy/internal/auth"
+	"github/JohnDirewolf/chirpy/internal/database"
+
+	"github.com/google/uuid"
+)
+
+// A session is one login, or one app the user let in, the family of refresh tokens that came from it.
+// Where and when it was last used comes from the last time it was refreshed.
+type sessionResponse struct {
+	Id         uuid.UUID `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	UserAgent  string    `json:"user_agent"`
+	IpAddress  string    `json:"ip_address"`
+	Current    bool      `json:"current"`
+	//Set when the session is an app the user let in through OAuth.
+	ClientId   *uuid.UUID `json:"client_id,omitempty"`
+	ClientName string     `json:"client_name,omitempty"`
+}
+
+// The address the request came from, without the port.
+func utilityClientIP(request *http.Request) string {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+	return host
+}
+
+// Checks an access token is signed by us and that the session it was issued for has not ended.
+// The signature alone would keep a token working for its full hour, so logging out, changing a password
+// or being banned only cuts it off once the session is checked as well.
+// Tokens issued to an app through OAuth come back with the app and the scopes it was allowed.
+func (cfg *apiConfig) utilityValidateAccessToken(userToken string) (authCaller, error) {
+	claims, err := auth.ParseJWT(userToken, cfg.jwtKeys)
+	if err != nil {
+		return authCaller{}, err
+	}
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return authCaller{}, errors.New("cannot parse userID")
+	}
+	//Tokens from before sessions were tracked cannot be checked, so they are no longer accepted.
+	if claims.SessionID == "" {
+		return authCaller{}, errors.New("token has no session")
+	}
+	sessionID, err := uuid.Parse(claims.SessionID)
+	if err != nil {
+		return authCaller{}, errors.New("cannot parse sessionID")
+	}
+
+	active, err := cfg.dbQueries.IsSessionActive(context.Background(), database.IsSessionActiveParams{
+		FamilyID: sessionID,
+		UserID:   userID,
+	})
+	if err != nil {
+		return authCaller{}, err
+	}
+	if !active {
+		return authCaller{}, errors.New("session has ended")
+	}
+
+	caller := authCaller{UserID: userID, SessionID: sessionID, Role: roleUser}
+	if claims.ClientID == "" && claims.Role != "" {
+		caller.Role = claims.Role
+	}
+	if claims.ClientID != "" {
+		caller.ClientID, err = uuid.Parse(claims.ClientID)
+		if err != nil {
+			return authCaller{}, errors.New("cannot parse client_id")
+		}
+		//Never nil, even with no scopes an app is not a login.
+		caller.Scopes = append([]string{}, strings.Fields(claims.Scope)...)
+	}
+	return caller, nil
+}
+
+// utilityValidateAccessToken for routes only a login can use, tokens issued to apps are turned away.
+func (cfg *apiConfig) utilityValidateJWTSession(userToken string) (uuid.UUID, uuid.UUID, error) {
+	caller, err := cfg.utilityValidateAccessToken(userToken)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, err
+	}
+	if !caller.isLogin() {
+		return uuid.Nil, uuid.Nil, errors.New("token was issued to an app")
+	}
+	return caller.UserID, caller.SessionID, nil
+}
+
+func (cfg *apiConfig) utilityValidateJWT(userToken string) (uuid.UUID, error) {
+	userID, _, err := cfg.utilityValidateJWTSession(userToken)
+	return userID, err
+}
+
+func (cfg *apiConfig) handlerGetSessions(response http.ResponseWriter, request *http.Request) {
+	//Validate credentials sent.
+	//Get the user token from the request.
+	userToken, err := auth.GetBearerToken(request.Header)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusUnauthorized)
+		response.Write([]byte("Unathorized: Please login first."))
+		return
+	}
+
+	userID, sessionID, err := cfg.utilityValidateJWTSession(userToken)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusUnauthorized)
+		response.Write([]byte("Unathorized: credentials invalid. Please login again."))
+		return
+	}
+
+	sessionList, err := cfg.dbQueries.GetSessions(context.Background(), userID)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not retrieve sessions."))
+		return
+	}
+
+	sessionListResponse := make([]sessionResponse, 0, len(sessionList))
+	for _, session := range sessionList {
+		sessionListResponse = append(sessionListResponse, sessionResponse{
+			Id:         session.FamilyID,
+			CreatedAt:  session.StartedAt,
+			LastUsedAt: session.LastUsedAt,
+			ExpiresAt:  session.ExpiresAt,
+			UserAgent:  session.UserAgent,
+			IpAddress:  session.IpAddress,
+			Current:    session.FamilyID == sessionID,
+			ClientId:   utilityNullUUID(session.ClientID),
+			ClientName: session.ClientName.String,
+		})
+	}
+
+	dataMarshalled, err := json.Marshal(sessionListResponse)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Failed create response."))
+		return
+	}
+
+	//Success
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusOK)
+	response.Write(dataMarshalled)
+}
+
+func (cfg *apiConfig) handlerRevokeSession(response http.ResponseWriter, request *http.Request) {
+	//Validate credentials sent.
+	//Get the user token from the request.
+	userToken, err := auth.GetBearerToken(request.Header)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusUnauthorized)
+		response.Write([]byte("Unathorized: Please login first."))
+		return
+	}
+
+	userID, err := cfg.utilityValidateJWT(userToken)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusUnauthorized)
+		response.Write([]byte("Unathorized: credentials invalid. Please login again."))
+		return
+	}
+
+	sessionID, err := uuid.Parse(request.PathValue("sessionID"))
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: session id is malformed."))
+		return
+	}
+
+	//Only the user's own sessions can be ended, anyone else's look the same as one that does not exist.
+	rowsRevoked, err := cfg.dbQueries.RevokeSession(context.Background(), database.RevokeSessionParams{
+		FamilyID: sessionID,
+		UserID:   userID,
+	})
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Failed to revoke session."))
+		return
+	}
+	if rowsRevoked == 0 {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusNotFound)
+		response.Write([]byte("Not Found: Session not found."))
+		return
+	}
+	cfg.utilityAudit(request, auditSessionRevoked, userID, userID, map[string]any{"session_id": sessionID})
+
+	//Success
+	response.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerRevokeAllSessions(response http.ResponseWriter, request *http.Request) {
+	//Validate credentials sent.
+	//Get the user token from the request.
+	userToken, err := auth.GetBearerToken(request.Header)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusUnauthorized)
+		response.Write([]byte("Unathorized: Please login first."))
+		return
+	}
+
+	userID, err := cfg.utilityValidateJWT(userToken)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusUnauthorized)
+		response.Write([]byte("Unathorized: credentials invalid. Please login again."))
+		return
+	}
+
+	//Logging out everywhere includes here, the caller has to login again too.
+	err = cfg.dbQueries.RevokeAllSessions(context.Background(), userID)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Failed to revoke sessions."))
+		return
+	}
+	cfg.utilityAudit(request, auditAllSessionsRevoked, userID, userID, nil)
+
+	//Success
+	response.WriteHeader(http.StatusNoContent)
+}
+
+// Cuts off every way into the account other than the password: sessions, apps, personal access tokens and
+// authorization codes not yet swapped for tokens. For when someone else may have had the password.
+// The session keepFamilyID is left alone, so users changing their own password stay logged in where they did it,
+// uuid.Nil keeps none.
+func utilityRevokeAllAccess(qtx *database.Queries, userID uuid.UUID, keepFamilyID uuid.UUID) error {
+	var err error
+	if keepFamilyID == uuid.Nil {
+		err = qtx.RevokeAllSessions(context.Background(), userID)
+	} else {
+		err = qtx.RevokeOtherSessions(context.Background(), database.RevokeOtherSessionsParams{
+			UserID:   userID,
+			FamilyID: keepFamilyID,
+		})
+	}
+	if err == nil {
+		err = qtx.RevokeAllPersonalAccessTokens(context.Background(), userID)
+	}
+	if err == nil {
+		err = qtx.ExpireOAuthAuthorizationCodes(context.Background(), userID)
+	}
+	return err
+}
@@ -0,0 +1,93 @@
+-- name: GetUsersPageAsc :many
+SELECT * FROM users
+WHERE (sqlc.narg('search')::text IS NULL OR strpos(lower(email), lower(sqlc.narg('search')::text)) > 0 OR strpos(lower(handle), lower(sqlc.narg('search')::text)) > 0)
+AND (sqlc.narg('role')::text IS NULL OR role = sqlc.narg('role')::text)
+AND (sqlc.narg('suspended')::boolean IS NULL OR (suspended_at IS NOT NULL) = sqlc.narg('suspended')::boolean)
+AND (sqlc.narg('cursor_created_at')::timestamp IS NULL OR (created_at, id) > (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid))
+ORDER BY created_at ASC, id ASC
+LIMIT sqlc.arg('page_limit');
+
+-- name: GetUsersPageDesc :many
+SELECT * FROM users
+WHERE (sqlc.narg('search')::text IS NULL OR strpos(lower(email), lower(sqlc.narg('search')::text)) > 0 OR strpos(lower(handle), lower(sqlc.narg('search')::text)) > 0)
+AND (sqlc.narg('role')::text IS NULL OR role = sqlc.narg('role')::text)
+AND (sqlc.narg('suspended')::boolean IS NULL OR (suspended_at IS NOT NULL) = sqlc.narg('suspended')::boolean)
+AND (sqlc.narg('cursor_created_at')::timestamp IS NULL OR (created_at, id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid))
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('page_limit');
+
+-- name: CountUsers :one
+SELECT COUNT(*) FROM users
+WHERE (sqlc.narg('search')::text IS NULL OR strpos(lower(email), lower(sqlc.narg('search')::text)) > 0 OR strpos(lower(handle), lower(sqlc.narg('search')::text)) > 0)
+AND (sqlc.narg('role')::text IS NULL OR role = sqlc.narg('role')::text)
+AND (sqlc.narg('suspended')::boolean IS NULL OR (suspended_at IS NOT NULL) = sqlc.narg('suspended')::boolean);
+
+-- name: SuspendUser :execrows
+UPDATE users
+SET suspended_at = COALESCE(suspended_at, NOW()), suspension_reason = $2, updated_at = NOW()
+WHERE id = $1;
+
+-- name: UnsuspendUser :execrows
+UPDATE users
+SET suspended_at = NULL, suspension_reason = NULL, updated_at = NOW()
+WHERE id = $1;
+
+-- name: DeleteUserMedia :many
+WITH deleted AS (
+    DELETE FROM media
+    WHERE user_id = $1
+    RETURNING id, storage_key
+)
+SELECT deleted.storage_key FROM deleted
+UNION ALL
+SELECT media_thumbnails.storage_key FROM media_thumbnails
+JOIN deleted ON deleted.id = media_thumbnails.media_id;
+
+-- name: TombstoneUserChirpsInThreads :many
+WITH RECURSIVE kept AS (
+    SELECT chirps.id, chirps.parent_id, chirps.quote_of FROM chirps
+    WHERE chirps.user_id = $1 AND EXISTS (
+        SELECT 1 FROM chirps others
+        WHERE (others.parent_id = chirps.id OR others.quote_of = chirps.id) AND others.user_id IS DISTINCT FROM $1
+    )
+    UNION
+    SELECT chirps.id, chirps.parent_id, chirps.quote_of FROM chirps
+    JOIN kept ON chirps.id = kept.parent_id OR chirps.id = kept.quote_of
+    WHERE chirps.user_id = $1
+)
+UPDATE chirps
+SET updated_at = NOW(), deleted_at = COALESCE(deleted_at, NOW()), body = '', rechirp_count = 0, user_id = NULL
+WHERE id IN (SELECT id FROM kept)
+RETURNING id;
+
+-- name: DecrementLikeCountsForUser :exec
+UPDATE chirps
+SET like_count = like_count - 1
+FROM likes
+WHERE likes.chirp_id = chirps.id AND likes.user_id = $1 AND chirps.user_id IS DISTINCT FROM $1;
+
+-- name: DecrementReplyCountsForUser :exec
+UPDATE chirps
+SET reply_count = reply_count - replies.count
+FROM (SELECT parent_id, COUNT(*)::INTEGER AS count FROM chirps WHERE user_id = $1 AND parent_id IS NOT NULL GROUP BY parent_id) replies
+WHERE chirps.id = replies.parent_id AND chirps.user_id IS DISTINCT FROM $1;
+
+-- name: DecrementRechirpCountsForUser :exec
+UPDATE chirps
+SET rechirp_count = rechirp_count - rechirps.count
+FROM (SELECT rechirp_of, COUNT(*)::INTEGER AS count FROM chirps WHERE user_id = $1 AND rechirp_of IS NOT NULL GROUP BY rechirp_of) rechirps
+WHERE chirps.id = rechirps.rechirp_of AND chirps.user_id IS DISTINCT FROM $1;
+
+-- name: DecrementQuoteCountsForUser :exec
+UPDATE chirps
+SET quote_count = quote_count - quotes.count
+FROM (SELECT quote_of, COUNT(*)::INTEGER AS count FROM chirps WHERE user_id = $1 AND quote_of IS NOT NULL GROUP BY quote_of) quotes
+WHERE chirps.id = quotes.quote_of AND chirps.user_id IS DISTINCT FROM $1;
+
+-- name: DeleteUser :execrows
+DELETE FROM users
+WHERE id = $1;
+
+-- name: DeleteFailedLoginsForUser :exec
+DELETE FROM failed_logins
+WHERE user_id = $1 OR LOWER(email) = LOWER($2);
\ No newline at end of file
@@ -0,0 +1,19 @@
+-- name: GetThreadChirps :many
+SELECT * FROM chirps
+WHERE root_id = $1
+ORDER BY created_at ASC, id ASC;
+
+-- name: IncrementReplyCount :exec
+UPDATE chirps
+SET reply_count = reply_count + 1
+WHERE id = $1;
+
+-- name: DecrementReplyCount :exec
+UPDATE chirps
+SET reply_count = reply_count - 1
+WHERE id = $1;
+
+-- name: TombstoneChirp :exec
+UPDATE chirps
+SET updated_at = NOW(), deleted_at = NOW(), body = '', rechirp_count = 0
+WHERE id = $1;
\ No newline at end of file
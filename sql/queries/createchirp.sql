@@ -1,4 +1,4 @@
 -- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
-VALUES (gen_random_uuid (), NOW(), NOW(), $1, $2)
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, parent_id, root_id, rechirp_of, quote_of)
+VALUES (gen_random_uuid (), NOW(), NOW(), $1, $2, $3, $4, $5, $6)
 RETURNING *;
\ No newline at end of file
@@ -5,12 +5,24 @@ INSERT INTO refresh_tokens (
     updated_at,
     expires_at,
     revoked_at,
-    user_id
+    user_id,
+    family_id,
+    user_agent,
+    ip_address,
+    last_used_at,
+    client_id,
+    scopes
 ) VALUES (
     $1,
     $2,
     $3,
     $4,
     NULL,
-    $5
+    $5,
+    $6,
+    $7,
+    $8,
+    NOW(),
+    $9,
+    $10
 );
\ No newline at end of file
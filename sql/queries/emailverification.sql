@@ -0,0 +1,28 @@
+-- name: CreateEmailVerificationToken :exec
+INSERT INTO email_verification_tokens (token_hash, expires_at, user_id, email)
+VALUES ($1, $2, $3, $4);
+
+-- name: GetEmailVerificationTokenForUpdate :one
+SELECT * FROM email_verification_tokens
+WHERE token_hash = $1
+FOR UPDATE;
+
+-- name: UseEmailVerificationToken :exec
+UPDATE email_verification_tokens
+SET used_at = NOW()
+WHERE token_hash = $1;
+
+-- name: MarkEmailVerified :execrows
+UPDATE users
+SET email_verified_at = NOW(), updated_at = NOW()
+WHERE id = $1 AND email = $2;
+
+-- name: SetPendingEmail :exec
+UPDATE users
+SET pending_email = $1, updated_at = NOW()
+WHERE id = $2;
+
+-- name: ConfirmPendingEmail :execrows
+UPDATE users
+SET email = pending_email, pending_email = NULL, email_verified_at = NOW(), updated_at = NOW()
+WHERE id = $1 AND pending_email = $2;
\ No newline at end of file
@@ -0,0 +1,28 @@
+-- name: CreateChirpEntity :exec
+INSERT INTO chirp_entities (chirp_id, kind, start_byte, end_byte, start_rune, end_rune, text, tag, user_id)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9);
+
+-- name: DeleteChirpEntities :exec
+DELETE FROM chirp_entities
+WHERE chirp_id = $1;
+
+-- name: GetChirpEntities :many
+SELECT * FROM chirp_entities
+WHERE chirp_id = ANY(sqlc.arg('chirp_ids')::uuid[])
+ORDER BY chirp_id, start_byte;
+
+-- name: GetHashtagChirpsPageAsc :many
+SELECT * FROM chirps
+WHERE id IN (SELECT chirp_id FROM chirp_entities WHERE tag = sqlc.arg('tag'))
+AND (sqlc.narg('cursor_created_at')::timestamp IS NULL OR (created_at, id) > (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid))
+AND deleted_at IS NULL
+ORDER BY created_at ASC, id ASC
+LIMIT sqlc.arg('page_limit');
+
+-- name: GetHashtagChirpsPageDesc :many
+SELECT * FROM chirps
+WHERE id IN (SELECT chirp_id FROM chirp_entities WHERE tag = sqlc.arg('tag'))
+AND (sqlc.narg('cursor_created_at')::timestamp IS NULL OR (created_at, id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid))
+AND deleted_at IS NULL
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('page_limit');
\ No newline at end of file
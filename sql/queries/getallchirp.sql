@@ -1,7 +0,0 @@
--- name: GetAllChirpsAsc :many
-SELECT * FROM chirps
-ORDER BY created_at ASC;
-
--- name: GetAllChirpsDesc :many
-SELECT * FROM chirps
-ORDER BY created_at DESC;
\ No newline at end of file
@@ -1,3 +1,9 @@
 -- name: GetRefreshToken :one
 SELECT * FROM refresh_tokens
-WHERE token = $1 AND expires_at > NOW() AND revoked_at IS NULL;
\ No newline at end of file
+WHERE token = $1
+FOR UPDATE;
+
+-- name: RotateRefreshToken :exec
+UPDATE refresh_tokens
+SET updated_at = NOW(), used_at = NOW(), replaced_by = $2
+WHERE token = $1;
\ No newline at end of file
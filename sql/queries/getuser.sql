@@ -1,3 +1,7 @@
 -- name: GetUser :one
 SELECT * FROM users
-WHERE email = $1;
\ No newline at end of file
+WHERE email = $1;
+
+-- name: GetUserByID :one
+SELECT * FROM users
+WHERE id = $1;
\ No newline at end of file
@@ -0,0 +1,42 @@
+-- name: LikeChirp :execrows
+WITH inserted AS (
+    INSERT INTO likes (user_id, chirp_id, created_at)
+    VALUES ($1, $2, NOW())
+    ON CONFLICT DO NOTHING
+    RETURNING chirp_id
+)
+UPDATE chirps
+SET like_count = like_count + 1
+WHERE id IN (SELECT chirp_id FROM inserted);
+
+-- name: UnlikeChirp :execrows
+WITH deleted AS (
+    DELETE FROM likes
+    WHERE user_id = $1 AND chirp_id = $2
+    RETURNING chirp_id
+)
+UPDATE chirps
+SET like_count = like_count - 1
+WHERE id IN (SELECT chirp_id FROM deleted);
+
+-- name: GetLikedChirpIDs :many
+SELECT chirp_id FROM likes
+WHERE user_id = sqlc.arg('user_id') AND chirp_id = ANY(sqlc.arg('chirp_ids')::uuid[]);
+
+-- name: GetUserLikesPageAsc :many
+SELECT sqlc.embed(chirps), likes.created_at AS liked_at FROM likes
+JOIN chirps ON chirps.id = likes.chirp_id
+WHERE likes.user_id = sqlc.arg('user_id')
+AND (sqlc.narg('cursor_created_at')::timestamp IS NULL OR (likes.created_at, likes.chirp_id) > (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid))
+AND chirps.deleted_at IS NULL
+ORDER BY likes.created_at ASC, likes.chirp_id ASC
+LIMIT sqlc.arg('page_limit');
+
+-- name: GetUserLikesPageDesc :many
+SELECT sqlc.embed(chirps), likes.created_at AS liked_at FROM likes
+JOIN chirps ON chirps.id = likes.chirp_id
+WHERE likes.user_id = sqlc.arg('user_id')
+AND (sqlc.narg('cursor_created_at')::timestamp IS NULL OR (likes.created_at, likes.chirp_id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid))
+AND chirps.deleted_at IS NULL
+ORDER BY likes.created_at DESC, likes.chirp_id DESC
+LIMIT sqlc.arg('page_limit');
\ No newline at end of file
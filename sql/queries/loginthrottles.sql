@@ -0,0 +1,37 @@
+-- name: GetLoginThrottle :one
+SELECT * FROM login_throttles
+WHERE kind = $1 AND key = $2;
+
+-- name: ReserveLoginAttempt :one
+INSERT INTO login_throttles (kind, key, failures, last_failure_at)
+VALUES ($1, $2, 1, NOW())
+ON CONFLICT (kind, key) DO UPDATE
+SET failures = CASE
+        WHEN login_throttles.last_failure_at < NOW() - INTERVAL '1 day' THEN 1
+        ELSE login_throttles.failures + 1
+    END,
+    last_failure_at = NOW(),
+    locked_until = CASE
+        WHEN login_throttles.last_failure_at < NOW() - INTERVAL '1 day' THEN NULL
+        WHEN login_throttles.failures + 1 <= $3::INTEGER THEN NULL
+        ELSE NOW() + LEAST(
+            INTERVAL '1 second' * POWER(2, LEAST(login_throttles.failures - $3::INTEGER, 30)),
+            INTERVAL '1 second' * $4::INTEGER
+        )
+    END
+WHERE login_throttles.locked_until IS NULL OR login_throttles.locked_until <= NOW()
+RETURNING *;
+
+-- name: ReleaseLoginAttempt :exec
+UPDATE login_throttles
+SET failures = GREATEST(failures - 1, 0),
+    locked_until = CASE WHEN locked_until = $3 THEN NULL ELSE locked_until END
+WHERE kind = $1 AND key = $2;
+
+-- name: ClearLoginThrottle :exec
+DELETE FROM login_throttles
+WHERE kind = $1 AND key = $2;
+
+-- name: CreateFailedLogin :exec
+INSERT INTO failed_logins (id, created_at, email, user_id, ip_address, user_agent, reason)
+VALUES (gen_random_uuid(), NOW(), $1, $2, $3, $4, $5);
\ No newline at end of file
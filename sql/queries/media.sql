@@ -0,0 +1,40 @@
+-- name: CreateMedia :one
+INSERT INTO media (id, created_at, user_id, storage_key, content_type, size_bytes, width, height, alt_text, blurhash)
+VALUES ($1, NOW(), $2, $3, $4, $5, $6, $7, $8, $9)
+RETURNING *;
+
+-- name: CreateMediaThumbnail :one
+INSERT INTO media_thumbnails (media_id, name, storage_key, width, height)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING *;
+
+-- name: AttachMedia :execrows
+UPDATE media
+SET chirp_id = $1, position = $2
+WHERE id = $3 AND user_id = $4 AND chirp_id IS NULL
+AND NOT EXISTS (SELECT 1 FROM users WHERE users.avatar_media_id = media.id);
+
+-- name: GetMediaByID :one
+SELECT * FROM media
+WHERE id = $1;
+
+-- name: GetChirpMedia :many
+SELECT * FROM media
+WHERE chirp_id = ANY(sqlc.arg('chirp_ids')::uuid[])
+ORDER BY chirp_id, position;
+
+-- name: GetMediaThumbnails :many
+SELECT * FROM media_thumbnails
+WHERE media_id = ANY(sqlc.arg('media_ids')::uuid[])
+ORDER BY media_id, width;
+
+-- name: DeleteChirpMedia :many
+WITH deleted AS (
+    DELETE FROM media
+    WHERE chirp_id = $1
+    RETURNING id, storage_key
+)
+SELECT deleted.storage_key FROM deleted
+UNION ALL
+SELECT media_thumbnails.storage_key FROM media_thumbnails
+JOIN deleted ON deleted.id = media_thumbnails.media_id;
\ No newline at end of file
@@ -0,0 +1,18 @@
+-- name: GetUserByHandle :one
+SELECT * FROM users
+WHERE lower(handle) = lower($1);
+
+-- name: GetUsersByHandles :many
+SELECT id, handle FROM users
+WHERE lower(handle) = ANY(sqlc.arg('handles')::text[]);
+
+-- name: UpdateUserProfile :one
+UPDATE users
+SET updated_at = NOW(), handle = $1, display_name = $2, bio = $3, avatar_media_id = $4
+WHERE id = $5
+RETURNING *;
+
+-- name: GetAuthorSummaries :many
+SELECT users.id, users.handle, users.display_name, media_thumbnails.storage_key AS avatar_storage_key FROM users
+LEFT JOIN media_thumbnails ON media_thumbnails.media_id = users.avatar_media_id AND media_thumbnails.name = 'small'
+WHERE users.id = ANY(sqlc.arg('ids')::uuid[]);
\ No newline at end of file
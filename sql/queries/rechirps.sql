@@ -0,0 +1,31 @@
+-- name: GetChirpsByIDs :many
+SELECT * FROM chirps
+WHERE id = ANY(sqlc.arg('ids')::uuid[]);
+
+-- name: GetRechirp :one
+SELECT * FROM chirps
+WHERE user_id = $1 AND rechirp_of = $2;
+
+-- name: DeleteRechirpsOf :exec
+DELETE FROM chirps
+WHERE rechirp_of = $1;
+
+-- name: IncrementRechirpCount :exec
+UPDATE chirps
+SET rechirp_count = rechirp_count + 1
+WHERE id = $1;
+
+-- name: DecrementRechirpCount :exec
+UPDATE chirps
+SET rechirp_count = rechirp_count - 1
+WHERE id = $1;
+
+-- name: IncrementQuoteCount :exec
+UPDATE chirps
+SET quote_count = quote_count + 1
+WHERE id = $1;
+
+-- name: DecrementQuoteCount :exec
+UPDATE chirps
+SET quote_count = quote_count - 1
+WHERE id = $1;
\ No newline at end of file
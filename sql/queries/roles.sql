@@ -0,0 +1,4 @@
+-- name: SetUserRole :execrows
+UPDATE users
+SET role = $2, updated_at = NOW()
+WHERE id = $1;
\ No newline at end of file
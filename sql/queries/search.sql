@@ -0,0 +1,12 @@
+-- name: SearchChirps :many
+SELECT sqlc.embed(chirps), COALESCE(ts_rank(chirps.search_vector, websearch_to_tsquery('english', sqlc.narg('query')::text)), 0)::real AS rank FROM chirps
+WHERE (sqlc.narg('query')::text IS NULL OR chirps.search_vector @@ websearch_to_tsquery('english', sqlc.narg('query')::text))
+AND (sqlc.narg('user_id')::uuid IS NULL OR chirps.user_id = sqlc.narg('user_id')::uuid)
+AND (sqlc.narg('since')::timestamp IS NULL OR chirps.created_at >= sqlc.narg('since')::timestamp)
+AND (sqlc.narg('until')::timestamp IS NULL OR chirps.created_at < sqlc.narg('until')::timestamp)
+AND (SELECT COUNT(DISTINCT tag) FROM chirp_entities WHERE chirp_entities.chirp_id = chirps.id AND tag = ANY(sqlc.arg('tags')::text[])) = cardinality(sqlc.arg('tags')::text[])
+AND (sqlc.narg('cursor_rank')::real IS NULL OR (COALESCE(ts_rank(chirps.search_vector, websearch_to_tsquery('english', sqlc.narg('query')::text)), 0)::real, chirps.created_at, chirps.id) < (sqlc.narg('cursor_rank')::real, sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid))
+AND chirps.deleted_at IS NULL
+AND chirps.rechirp_of IS NULL
+ORDER BY rank DESC, chirps.created_at DESC, chirps.id DESC
+LIMIT sqlc.arg('page_limit');
\ No newline at end of file
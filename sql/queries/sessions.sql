@@ -0,0 +1,42 @@
+-- name: GetSessions :many
+SELECT
+    head.family_id,
+    (SELECT MIN(first.created_at) FROM refresh_tokens first WHERE first.family_id = head.family_id)::TIMESTAMP AS started_at,
+    head.last_used_at,
+    head.expires_at,
+    head.user_agent,
+    head.ip_address,
+    head.client_id,
+    oauth_clients.name AS client_name
+FROM refresh_tokens head
+LEFT JOIN oauth_clients ON oauth_clients.id = head.client_id
+WHERE head.user_id = $1
+AND head.used_at IS NULL
+AND head.revoked_at IS NULL
+AND head.expires_at > NOW()
+ORDER BY head.last_used_at DESC;
+
+-- name: RevokeSession :execrows
+UPDATE refresh_tokens
+SET updated_at = NOW(), revoked_at = NOW()
+WHERE family_id = $1 AND user_id = $2 AND revoked_at IS NULL;
+
+-- name: RevokeAllSessions :exec
+UPDATE refresh_tokens
+SET updated_at = NOW(), revoked_at = NOW()
+WHERE user_id = $1 AND revoked_at IS NULL;
+
+-- name: RevokeOtherSessions :exec
+UPDATE refresh_tokens
+SET updated_at = NOW(), revoked_at = NOW()
+WHERE user_id = $1 AND family_id <> $2 AND revoked_at IS NULL;
+
+-- name: IsSessionActive :one
+SELECT EXISTS (
+    SELECT 1 FROM refresh_tokens
+    WHERE family_id = $1
+    AND user_id = $2
+    AND revoked_at IS NULL
+    AND expires_at > NOW()
+    AND user_id IN (SELECT id FROM users WHERE suspended_at IS NULL)
+);
\ No newline at end of file
@@ -0,0 +1,15 @@
+-- name: GetTimelinePageAsc :many
+SELECT * FROM chirps
+WHERE user_id IN (SELECT followee_id FROM follows WHERE follower_id = sqlc.arg('follower_id'))
+AND (sqlc.narg('cursor_created_at')::timestamp IS NULL OR (created_at, id) > (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid))
+AND deleted_at IS NULL
+ORDER BY created_at ASC, id ASC
+LIMIT sqlc.arg('page_limit');
+
+-- name: GetTimelinePageDesc :many
+SELECT * FROM chirps
+WHERE user_id IN (SELECT followee_id FROM follows WHERE follower_id = sqlc.arg('follower_id'))
+AND (sqlc.narg('cursor_created_at')::timestamp IS NULL OR (created_at, id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid))
+AND deleted_at IS NULL
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('page_limit');
\ No newline at end of file
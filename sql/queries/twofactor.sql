@@ -0,0 +1,61 @@
+-- name: CreateTOTPCredential :execrows
+INSERT INTO totp_credentials (user_id, secret_encrypted)
+VALUES ($1, $2)
+ON CONFLICT (user_id) DO UPDATE
+SET secret_encrypted = EXCLUDED.secret_encrypted, updated_at = NOW(), last_step = 0
+WHERE totp_credentials.confirmed_at IS NULL;
+
+-- name: GetTOTPCredential :one
+SELECT * FROM totp_credentials
+WHERE user_id = $1;
+
+-- name: GetTOTPCredentialForUpdate :one
+SELECT * FROM totp_credentials
+WHERE user_id = $1
+FOR UPDATE;
+
+-- name: ConfirmTOTPCredential :exec
+UPDATE totp_credentials
+SET confirmed_at = NOW(), updated_at = NOW()
+WHERE user_id = $1;
+
+-- name: UpdateTOTPLastStep :exec
+UPDATE totp_credentials
+SET last_step = $2, updated_at = NOW()
+WHERE user_id = $1;
+
+-- name: DeleteTOTPCredential :exec
+DELETE FROM totp_credentials
+WHERE user_id = $1;
+
+-- name: CreateRecoveryCode :exec
+INSERT INTO recovery_codes (id, user_id, code_hash)
+VALUES (gen_random_uuid(), $1, $2);
+
+-- name: UseRecoveryCode :execrows
+UPDATE recovery_codes
+SET used_at = NOW()
+WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL;
+
+-- name: DeleteRecoveryCodes :exec
+DELETE FROM recovery_codes
+WHERE user_id = $1;
+
+-- name: CreateLoginChallenge :exec
+INSERT INTO login_challenges (token_hash, expires_at, user_id)
+VALUES ($1, $2, $3);
+
+-- name: GetLoginChallengeForUpdate :one
+SELECT * FROM login_challenges
+WHERE token_hash = $1
+FOR UPDATE;
+
+-- name: IncrementLoginChallengeAttempts :exec
+UPDATE login_challenges
+SET attempts = attempts + 1
+WHERE token_hash = $1;
+
+-- name: UseLoginChallenge :exec
+UPDATE login_challenges
+SET used_at = NOW()
+WHERE token_hash = $1;
\ No newline at end of file
@@ -0,0 +1,10 @@
+-- name: GetChirpByIDForUpdate :one
+SELECT * FROM chirps
+WHERE id = $1
+FOR UPDATE;
+
+-- name: UpdateChirp :one
+UPDATE chirps
+SET updated_at = NOW(), body = $1
+WHERE id = $2
+RETURNING *;
\ No newline at end of file
@@ -1,4 +1,9 @@
 -- name: CreateUser :one
-INSERT INTO users (id, created_at, updated_at, hashed_password, email)
-VALUES (gen_random_uuid (), NOW(), NOW(), $1, $2)
-RETURNING id, created_at, updated_at, email, is_chirpy_red;
\ No newline at end of file
+INSERT INTO users (id, created_at, updated_at, hashed_password, email, handle)
+VALUES (gen_random_uuid (), NOW(), NOW(), $1, $2, $3)
+RETURNING id, created_at, updated_at, email, is_chirpy_red, handle, display_name, bio;
+
+-- name: RehashUserPassword :exec
+UPDATE users
+SET hashed_password = sqlc.arg('new_hashed_password')
+WHERE id = sqlc.arg('id') AND hashed_password = sqlc.arg('old_hashed_password');
\ No newline at end of file
@@ -0,0 +1,19 @@
+-- +goose Up
+ALTER TABLE chirps
+    ADD rechirp_of UUID NULL REFERENCES chirps(id) ON DELETE CASCADE,
+    ADD quote_of UUID NULL REFERENCES chirps(id) ON DELETE SET NULL,
+    ADD rechirp_count INTEGER NOT NULL DEFAULT 0,
+    ADD quote_count INTEGER NOT NULL DEFAULT 0;
+CREATE UNIQUE INDEX chirps_user_id_rechirp_of_idx ON chirps (user_id, rechirp_of) WHERE rechirp_of IS NOT NULL;
+CREATE INDEX chirps_rechirp_of_idx ON chirps (rechirp_of) WHERE rechirp_of IS NOT NULL;
+CREATE INDEX chirps_quote_of_idx ON chirps (quote_of) WHERE quote_of IS NOT NULL;
+
+-- +goose Down
+DROP INDEX chirps_quote_of_idx;
+DROP INDEX chirps_rechirp_of_idx;
+DROP INDEX chirps_user_id_rechirp_of_idx;
+ALTER TABLE chirps
+    DROP COLUMN quote_count,
+    DROP COLUMN rechirp_count,
+    DROP COLUMN quote_of,
+    DROP COLUMN rechirp_of;
\ No newline at end of file
@@ -0,0 +1,20 @@
+-- +goose Up
+CREATE TABLE chirp_entities (
+    chirp_id UUID NOT NULL,
+    kind TEXT NOT NULL CHECK (kind IN ('hashtag', 'mention', 'url')),
+    start_byte INTEGER NOT NULL,
+    end_byte INTEGER NOT NULL,
+    start_rune INTEGER NOT NULL,
+    end_rune INTEGER NOT NULL,
+    text TEXT NOT NULL,
+    tag TEXT,
+    user_id UUID,
+    PRIMARY KEY (chirp_id, start_byte),
+    FOREIGN KEY (chirp_id) REFERENCES chirps(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX chirp_entities_tag_idx ON chirp_entities (tag, chirp_id) WHERE tag IS NOT NULL;
+CREATE INDEX chirp_entities_user_id_idx ON chirp_entities (user_id) WHERE user_id IS NOT NULL;
+
+-- +goose Down
+DROP TABLE chirp_entities;
\ No newline at end of file
@@ -0,0 +1,20 @@
+-- +goose Up
+CREATE TABLE media (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    user_id UUID NOT NULL,
+    chirp_id UUID,
+    position INTEGER,
+    storage_key TEXT NOT NULL,
+    content_type TEXT NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    alt_text TEXT NOT NULL DEFAULT '',
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (chirp_id) REFERENCES chirps(id) ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX media_chirp_id_position_idx ON media (chirp_id, position) WHERE chirp_id IS NOT NULL;
+
+-- +goose Down
+DROP TABLE media;
\ No newline at end of file
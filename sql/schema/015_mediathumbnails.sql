@@ -0,0 +1,15 @@
+-- +goose Up
+ALTER TABLE media ADD blurhash TEXT NOT NULL DEFAULT '';
+CREATE TABLE media_thumbnails (
+    media_id UUID NOT NULL,
+    name TEXT NOT NULL,
+    storage_key TEXT NOT NULL,
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    PRIMARY KEY (media_id, name),
+    FOREIGN KEY (media_id) REFERENCES media(id) ON DELETE CASCADE
+);
+
+-- +goose Down
+DROP TABLE media_thumbnails;
+ALTER TABLE media DROP COLUMN blurhash;
\ No newline at end of file
@@ -0,0 +1,15 @@
+-- +goose Up
+ALTER TABLE users ADD handle TEXT;
+UPDATE users SET handle = 'user_' || substr(md5(id::text), 1, 10);
+ALTER TABLE users ALTER COLUMN handle SET NOT NULL;
+CREATE UNIQUE INDEX users_handle_lower_idx ON users (lower(handle));
+ALTER TABLE users ADD display_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD bio TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD avatar_media_id UUID REFERENCES media(id) ON DELETE SET NULL;
+
+-- +goose Down
+ALTER TABLE users DROP COLUMN avatar_media_id;
+ALTER TABLE users DROP COLUMN bio;
+ALTER TABLE users DROP COLUMN display_name;
+DROP INDEX users_handle_lower_idx;
+ALTER TABLE users DROP COLUMN handle;
\ No newline at end of file
@@ -0,0 +1,13 @@
+-- +goose Up
+ALTER TABLE refresh_tokens ADD family_id UUID;
+UPDATE refresh_tokens SET family_id = gen_random_uuid();
+ALTER TABLE refresh_tokens ALTER COLUMN family_id SET NOT NULL;
+ALTER TABLE refresh_tokens ADD used_at TIMESTAMP NULL;
+ALTER TABLE refresh_tokens ADD replaced_by TEXT NULL;
+CREATE INDEX refresh_tokens_family_id_idx ON refresh_tokens (family_id);
+
+-- +goose Down
+DROP INDEX refresh_tokens_family_id_idx;
+ALTER TABLE refresh_tokens DROP COLUMN replaced_by;
+ALTER TABLE refresh_tokens DROP COLUMN used_at;
+ALTER TABLE refresh_tokens DROP COLUMN family_id;
\ No newline at end of file
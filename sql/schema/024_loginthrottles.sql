@@ -0,0 +1,26 @@
+-- +goose Up
+CREATE TABLE login_throttles (
+    kind TEXT NOT NULL,
+    key TEXT NOT NULL,
+    failures INTEGER NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    locked_until TIMESTAMP,
+    PRIMARY KEY (kind, key)
+);
+
+CREATE TABLE failed_logins (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    email TEXT NOT NULL,
+    user_id UUID,
+    ip_address TEXT NOT NULL,
+    user_agent TEXT NOT NULL,
+    reason TEXT NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL
+);
+CREATE INDEX failed_logins_created_at_idx ON failed_logins (created_at);
+CREATE INDEX failed_logins_user_id_idx ON failed_logins (user_id);
+
+-- +goose Down
+DROP TABLE failed_logins;
+DROP TABLE login_throttles;
\ No newline at end of file
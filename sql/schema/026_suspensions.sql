@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE users ADD suspended_at TIMESTAMP NULL;
+ALTER TABLE users ADD suspension_reason TEXT NULL;
+
+-- +goose Down
+ALTER TABLE users DROP COLUMN suspension_reason;
+ALTER TABLE users DROP COLUMN suspended_at;
\ No newline at end of file
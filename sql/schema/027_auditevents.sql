@@ -0,0 +1,29 @@
+-- +goose Up
+CREATE TABLE audit_events (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    event TEXT NOT NULL,
+    actor_id UUID,
+    target_id UUID,
+    ip_address TEXT NOT NULL,
+    user_agent TEXT NOT NULL,
+    payload JSONB NOT NULL DEFAULT '{}'
+);
+CREATE INDEX audit_events_created_at_idx ON audit_events (created_at, id);
+CREATE INDEX audit_events_actor_id_idx ON audit_events (actor_id, created_at, id);
+CREATE INDEX audit_events_target_id_idx ON audit_events (target_id, created_at, id);
+CREATE INDEX audit_events_event_idx ON audit_events (event, created_at, id);
+
+-- +goose StatementBegin
+CREATE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+CREATE TRIGGER audit_events_append_only BEFORE UPDATE OR DELETE ON audit_events
+FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
+
+-- +goose Down
+DROP TABLE audit_events;
+DROP FUNCTION audit_events_append_only();
\ No newline at end of file
@@ -0,0 +1,6 @@
+-- +goose Up
+ALTER TABLE chirps ALTER COLUMN user_id DROP NOT NULL;
+
+-- +goose Down
+DELETE FROM chirps WHERE user_id IS NULL;
+ALTER TABLE chirps ALTER COLUMN user_id SET NOT NULL;
\ No newline at end of file
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"slices"
+
+	"github/JohnDirewolf/chirpy/internal/database"
+
+	"github.com/google/uuid"
+)
+
+// A chirp in a thread along with all the replies under it, oldest first.
+type chirpThreadNode struct {
+	chirpsResponse
+	Replies []chirpThreadNode `json:"replies"`
+}
+
+type chirpThreadResponse struct {
+	Ancestors []chirpsResponse `json:"ancestors"`
+	Chirp     chirpThreadNode  `json:"chirp"`
+}
+
+func utilityThreadNode(chirp database.Chirp, repliesByParent map[uuid.UUID][]database.Chirp, responsesByID map[uuid.UUID]chirpsResponse) chirpThreadNode {
+	node := chirpThreadNode{
+		chirpsResponse: responsesByID[chirp.ID],
+		Replies:        make([]chirpThreadNode, 0, len(repliesByParent[chirp.ID])),
+	}
+	for _, reply := range repliesByParent[chirp.ID] {
+		node.Replies = append(node.Replies, utilityThreadNode(reply, repliesByParent, responsesByID))
+	}
+	return node
+}
+
+func (cfg *apiConfig) handlerGetChirpThread(response http.ResponseWriter, request *http.Request) {
+	//Extract the Chirp ID
+	chirpID, err := uuid.Parse(request.PathValue("chirpID"))
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Error! Invalid Chirp ID."))
+		return
+	}
+
+	//Tombstones are fine here, they are still part of the conversation.
+	chirp, err := cfg.dbQueries.GetChirpByID(context.Background(), chirpID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			response.WriteHeader(http.StatusNotFound)
+			response.Write([]byte("Error! Chirp not found."))
+			return
+		}
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Error! Server error fetching Chirp."))
+		return
+	}
+
+	//Load the whole conversation in one go, every reply in it shares the root.
+	rootID := chirp.ID
+	if chirp.RootID.Valid {
+		rootID = chirp.RootID.UUID
+	}
+	threadList, err := cfg.dbQueries.GetThreadChirps(context.Background(), uuid.NullUUID{UUID: rootID, Valid: true})
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Error! Server error fetching thread."))
+		return
+	}
+
+	chirpsByID := make(map[uuid.UUID]database.Chirp, len(threadList)+1)
+	repliesByParent := make(map[uuid.UUID][]database.Chirp)
+	for _, threadChirp := range threadList {
+		chirpsByID[threadChirp.ID] = threadChirp
+		if threadChirp.ParentID.Valid {
+			repliesByParent[threadChirp.ParentID.UUID] = append(repliesByParent[threadChirp.ParentID.UUID], threadChirp)
+		}
+	}
+
+	//The root does not point at itself so it is not in the thread list.
+	if rootID != chirp.ID {
+		rootChirp, err := cfg.dbQueries.GetChirpByID(context.Background(), rootID)
+		if err != nil && err != sql.ErrNoRows {
+			response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			response.WriteHeader(http.StatusInternalServerError)
+			response.Write([]byte("Error! Server error fetching thread."))
+			return
+		}
+		if err == nil {
+			chirpsByID[rootChirp.ID] = rootChirp
+		}
+	}
+
+	//Convert the whole conversation at once, rather than a chirp at a time.
+	chirpsByID[chirp.ID] = chirp
+	allChirps := make([]database.Chirp, 0, len(chirpsByID))
+	for _, threadChirp := range chirpsByID {
+		allChirps = append(allChirps, threadChirp)
+	}
+	responseList, err := cfg.utilityChirpListResponse(request, allChirps)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Error! Server error fetching thread."))
+		return
+	}
+	responsesByID := make(map[uuid.UUID]chirpsResponse, len(responseList))
+	for _, chirpResponse := range responseList {
+		responsesByID[chirpResponse.Id] = chirpResponse
+	}
+
+	//Walk up the parents to the root, then flip so the root comes first.
+	ancestors := make([]chirpsResponse, 0)
+	parentID := chirp.ParentID
+	for parentID.Valid {
+		parentChirp, found := chirpsByID[parentID.UUID]
+		if !found {
+			break
+		}
+		ancestors = append(ancestors, responsesByID[parentChirp.ID])
+		parentID = parentChirp.ParentID
+	}
+	slices.Reverse(ancestors)
+
+	threadMarshalled, err := json.Marshal(chirpThreadResponse{
+		Ancestors: ancestors,
+		Chirp:     utilityThreadNode(chirp, repliesByParent, responsesByID),
+	})
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not process thread to JSON."))
+		return
+	}
+
+	//Success
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusOK)
+	response.Write(threadMarshalled)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github/JohnDirewolf/chirpy/internal/auth"
+	"github/JohnDirewolf/chirpy/internal/database"
+)
+
+func (cfg *apiConfig) handlerGetTimeline(response http.ResponseWriter, request *http.Request) {
+	var chirpList []database.Chirp
+
+	//Validate credentials sent.
+	//Get the user token from the request.
+	userToken, err := auth.GetBearerToken(request.Header)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusUnauthorized)
+		response.Write([]byte("Unathorized: Please login first."))
+		return
+	}
+
+	userID, err := cfg.utilityAuthenticate(userToken, scopeChirpsRead)
+	if err != nil {
+		utilityWriteAuthError(response, err, scopeChirpsRead)
+		return
+	}
+
+	//Work out the page size and where the page starts.
+	page, err := utilityParsePageRequest(request.URL.Query())
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: " + err.Error() + "."))
+		return
+	}
+
+	//The timeline is always newest first, so only paging back walks up the index.
+	if page.pagingBack {
+		chirpList, err = cfg.dbQueries.GetTimelinePageAsc(context.Background(), database.GetTimelinePageAscParams{
+			FollowerID:      userID,
+			CursorCreatedAt: page.cursorCreatedAt,
+			CursorID:        page.cursorID,
+			PageLimit:       page.limit + 1,
+		})
+	} else {
+		chirpList, err = cfg.dbQueries.GetTimelinePageDesc(context.Background(), database.GetTimelinePageDescParams{
+			FollowerID:      userID,
+			CursorCreatedAt: page.cursorCreatedAt,
+			CursorID:        page.cursorID,
+			PageLimit:       page.limit + 1,
+		})
+	}
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not retrieve timeline."))
+		return
+	}
+
+	chirpList, nextCursor, prevCursor := utilityPageCursors(chirpList, page, utilityChirpKey)
+
+	//An empty timeline is not an error, the user may just not follow anyone yet.
+	chripListResponse, err := cfg.utilityChirpListResponse(request, chirpList)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not retrieve timeline."))
+		return
+	}
+
+	chripListMarshalled, err := json.Marshal(chirpsPageResponse{
+		Chirps:     chripListResponse,
+		NextCursor: nextCursor,
+		PrevCursor: prevCursor,
+	})
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Could not process Chirps to JSON."))
+		return
+	}
+
+	//Success
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusOK)
+	response.Write(chripListMarshalled)
+}
@@ -0,0 +1,669 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github/JohnDirewolf/chirpy/internal/auth"
+	"github/JohnDirewolf/chirpy/internal/database"
+	"github/JohnDirewolf/chirpy/internal/totp"
+
+	"github.com/google/uuid"
+)
+
+// How long a user has to enter their code after their password, and how many tries they get.
+const loginChallengeDuration = time.Minute * 5
+const maxLoginChallengeAttempts = 5
+
+// How many recovery codes a user is given, each works once.
+const recoveryCodeCount = 10
+
+// The name authenticator apps show next to the code.
+const totpIssuer = "Chirpy"
+
+// A second factor is either a code from the authenticator app or one of the recovery codes.
+type secondFactorRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type recoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// Codes checked for a user who is already logged in, to confirm, turn off or make new recovery codes, are counted
+// against their account like logins are. An access token is not a second factor, so someone holding a stolen one
+// only gets a few guesses before being locked out.
+const loginThrottleSecondFactor = "second_factor"
+const secondFactorFreeFailures = 5
+const maxSecondFactorLockout = time.Minute * 15
+
+func utilitySecondFactorThrottle(userID uuid.UUID) loginThrottle {
+	return loginThrottle{kind: loginThrottleSecondFactor, key: userID.String(), freeFailures: secondFactorFreeFailures, maxLockout: maxSecondFactorLockout}
+}
+
+func utilityWriteTooManyCodes(response http.ResponseWriter, retryAfter time.Duration) {
+	response.Header().Set("Retry-After", utilityRetryAfterSeconds(retryAfter))
+	response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+	response.WriteHeader(http.StatusTooManyRequests)
+	response.Write([]byte("Too Many Requests: Too many incorrect codes, please try again in " + utilityRetryAfterSeconds(retryAfter) + " seconds."))
+}
+
+// Checks a second factor for the user, it must be called in a transaction as it locks their TOTP secret
+// so the same code cannot be used twice at once. A code that works is used up.
+func (cfg *apiConfig) utilityCheckSecondFactor(qtx *database.Queries, userID uuid.UUID, secondFactor secondFactorRequest) (bool, error) {
+	if secondFactor.RecoveryCode != "" {
+		rowsUsed, err := qtx.UseRecoveryCode(context.Background(), database.UseRecoveryCodeParams{
+			UserID:   userID,
+			CodeHash: auth.HashToken(auth.NormalizeRecoveryCode(secondFactor.RecoveryCode)),
+		})
+		return rowsUsed == 1, err
+	}
+
+	if cfg.secretBox == nil {
+		return false, errors.New("no encryption key for TOTP secrets")
+	}
+	totpCredential, err := qtx.GetTOTPCredentialForUpdate(context.Background(), userID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	secret, err := cfg.secretBox.Open(totpCredential.SecretEncrypted)
+	if err != nil {
+		return false, err
+	}
+
+	step, valid := totp.Validate(secret, secondFactor.Code, time.Now(), totpCredential.LastStep)
+	if !valid {
+		return false, nil
+	}
+	err = qtx.UpdateTOTPLastStep(context.Background(), database.UpdateTOTPLastStepParams{
+		UserID:   userID,
+		LastStep: step,
+	})
+	return err == nil, err
+}
+
+// Replaces any recovery codes the user has with a new set, returning them as the user has to see them.
+// Only their hashes are kept.
+func utilityMakeRecoveryCodes(qtx *database.Queries, userID uuid.UUID) ([]string, error) {
+	err := qtx.DeleteRecoveryCodes(context.Background(), userID)
+	if err != nil {
+		return nil, err
+	}
+
+	recoveryCodes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		recoveryCode, err := auth.MakeRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		err = qtx.CreateRecoveryCode(context.Background(), database.CreateRecoveryCodeParams{
+			UserID:   userID,
+			CodeHash: auth.HashToken(recoveryCode),
+		})
+		if err != nil {
+			return nil, err
+		}
+		recoveryCodes = append(recoveryCodes, recoveryCode)
+	}
+	return recoveryCodes, nil
+}
+
+// The password was right but a second factor is needed, so instead of tokens the user gets a challenge
+// to send back to /api/login/2fa with their code.
+func (cfg *apiConfig) utilityStartLoginChallenge(response http.ResponseWriter, userData database.User) {
+	challengeToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Unable to login."))
+		return
+	}
+
+	expiresAt := time.Now().Add(loginChallengeDuration).UTC()
+	err = cfg.dbQueries.CreateLoginChallenge(context.Background(), database.CreateLoginChallengeParams{
+		TokenHash: auth.HashToken(challengeToken),
+		ExpiresAt: expiresAt,
+		UserID:    userData.ID,
+	})
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Unable to login."))
+		return
+	}
+
+	type challengeResponse struct {
+		TwoFactorRequired bool      `json:"two_factor_required"`
+		ChallengeToken    string    `json:"challenge_token"`
+		ExpiresAt         time.Time `json:"expires_at"`
+	}
+	dataMarshalled, err := json.Marshal(challengeResponse{
+		TwoFactorRequired: true,
+		ChallengeToken:    challengeToken,
+		ExpiresAt:         expiresAt,
+	})
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Failed create response."))
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusOK)
+	response.Write(dataMarshalled)
+}
+
+func (cfg *apiConfig) handlerLogin2FA(response http.ResponseWriter, request *http.Request) {
+	type requestParameters struct {
+		ChallengeToken string `json:"challenge_token"`
+		secondFactorRequest
+	}
+
+	decoder := json.NewDecoder(request.Body)
+	requestParams := requestParameters{}
+	err := decoder.Decode(&requestParams)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: Did not understand request."))
+		return
+	}
+
+	//The challenge row is locked, so tries at the same challenge are counted one at a time.
+	tx, err := cfg.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Unable to login."))
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	challenge, err := qtx.GetLoginChallengeForUpdate(context.Background(), auth.HashToken(requestParams.ChallengeToken))
+	if err != nil && err != sql.ErrNoRows {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Unable to login."))
+		return
+	}
+	if err == sql.ErrNoRows || challenge.UsedAt.Valid || !challenge.ExpiresAt.After(time.Now().UTC()) || challenge.Attempts >= maxLoginChallengeAttempts {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusUnauthorized)
+		response.Write([]byte("Unauthorized: Please login again."))
+		return
+	}
+
+	userData, err := qtx.GetUserByID(context.Background(), challenge.UserID)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Unable to login."))
+		return
+	}
+	//Suspended since the password was checked.
+	if userData.SuspendedAt.Valid {
+		utilityWriteSuspended(response)
+		return
+	}
+
+	//Wrong codes count towards the same lockouts as wrong passwords, otherwise someone with the password
+	//could keep starting new challenges to guess at codes.
+	reserved, retryAfter, err := cfg.utilityReserveLoginAttempt(request, userData.Email)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Unable to login."))
+		return
+	}
+	if retryAfter > 0 {
+		utilityWriteTooManyLogins(response, retryAfter)
+		return
+	}
+
+	valid, err := cfg.utilityCheckSecondFactor(qtx, challenge.UserID, requestParams.secondFactorRequest)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Unable to login."))
+		return
+	}
+	if !valid {
+		//Count the miss even though the login fails.
+		err = qtx.IncrementLoginChallengeAttempts(context.Background(), challenge.TokenHash)
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			fmt.Printf("Error counting login challenge attempt: %v\n", err)
+		}
+		cfg.utilityRecordLoginFailure(request, userData.Email, uuid.NullUUID{UUID: userData.ID, Valid: true}, failedLoginSecondFactor)
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusUnauthorized)
+		response.Write([]byte("Unauthorized: Incorrect code."))
+		return
+	}
+
+	cfg.utilityReleaseThrottles(reserved)
+
+	err = qtx.UseLoginChallenge(context.Background(), challenge.TokenHash)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Unable to login."))
+		return
+	}
+
+	cfg.utilityCompleteLogin(response, request, userData)
+}
+
+func (cfg *apiConfig) handlerEnrollTOTP(response http.ResponseWriter, request *http.Request) {
+	//Validate credentials sent.
+	//Get the user token from the request.
+	userToken, err := auth.GetBearerToken(request.Header)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusUnauthorized)
+		response.Write([]byte("Unathorized: Please login first."))
+		return
+	}
+
+	userID, err := cfg.utilityValidateJWT(userToken)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusUnauthorized)
+		response.Write([]byte("Unathorized: credentials invalid. Please login again."))
+		return
+	}
+
+	if cfg.secretBox == nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusServiceUnavailable)
+		response.Write([]byte("Service Unavailable: Two-factor authentication is not set up on this server."))
+		return
+	}
+
+	userData, err := cfg.dbQueries.GetUserByID(context.Background(), userID)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusUnauthorized)
+		response.Write([]byte("Unathorized: credentials invalid. Please login again."))
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Unable to set up two-factor authentication."))
+		return
+	}
+	secretEncrypted, err := cfg.secretBox.Seal(secret)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Unable to set up two-factor authentication."))
+		return
+	}
+
+	//Starting again before confirming replaces the secret, once confirmed it has to be turned off first.
+	rowsSaved, err := cfg.dbQueries.CreateTOTPCredential(context.Background(), database.CreateTOTPCredentialParams{
+		UserID:          userID,
+		SecretEncrypted: secretEncrypted,
+	})
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Unable to set up two-factor authentication."))
+		return
+	}
+	if rowsSaved == 0 {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusConflict)
+		response.Write([]byte("Conflict: Two-factor authentication is already on."))
+		return
+	}
+
+	type enrollResponse struct {
+		Secret     string `json:"secret"`
+		OtpauthUri string `json:"otpauth_uri"`
+	}
+	dataMarshalled, err := json.Marshal(enrollResponse{
+		Secret:     secret,
+		OtpauthUri: totp.URI(totpIssuer, userData.Email, secret),
+	})
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Failed create response."))
+		return
+	}
+
+	//Success
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusCreated)
+	response.Write(dataMarshalled)
+}
+
+func (cfg *apiConfig) handlerConfirmTOTP(response http.ResponseWriter, request *http.Request) {
+	//Validate credentials sent.
+	//Get the user token from the request.
+	userToken, err := auth.GetBearerToken(request.Header)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusUnauthorized)
+		response.Write([]byte("Unathorized: Please login first."))
+		return
+	}
+
+	userID, err := cfg.utilityValidateJWT(userToken)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusUnauthorized)
+		response.Write([]byte("Unathorized: credentials invalid. Please login again."))
+		return
+	}
+
+	decoder := json.NewDecoder(request.Body)
+	requestParams := secondFactorRequest{}
+	err = decoder.Decode(&requestParams)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: Did not understand request."))
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Unable to confirm two-factor authentication."))
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	totpCredential, err := qtx.GetTOTPCredential(context.Background(), userID)
+	if err == sql.ErrNoRows || (err == nil && totpCredential.ConfirmedAt.Valid) {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusConflict)
+		response.Write([]byte("Conflict: There is no two-factor set up waiting to be confirmed."))
+		return
+	}
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Unable to confirm two-factor authentication."))
+		return
+	}
+
+	throttle := utilitySecondFactorThrottle(userID)
+	_, retryAfter, err := cfg.utilityReserveThrottles([]loginThrottle{throttle})
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Unable to confirm two-factor authentication."))
+		return
+	}
+	if retryAfter > 0 {
+		utilityWriteTooManyCodes(response, retryAfter)
+		return
+	}
+
+	//Confirming proves the app was set up right, only a code from it will do.
+	valid, err := cfg.utilityCheckSecondFactor(qtx, userID, secondFactorRequest{Code: requestParams.Code})
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Unable to confirm two-factor authentication."))
+		return
+	}
+	if !valid {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: Incorrect code."))
+		return
+	}
+
+	//A right code wipes out the misses before it.
+	cfg.utilityClearThrottle(throttle)
+
+	err = qtx.ConfirmTOTPCredential(context.Background(), userID)
+	var recoveryCodes []string
+	if err == nil {
+		recoveryCodes, err = utilityMakeRecoveryCodes(qtx, userID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Unable to confirm two-factor authentication."))
+		return
+	}
+
+	dataMarshalled, err := json.Marshal(recoveryCodesResponse{RecoveryCodes: recoveryCodes})
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Failed create response."))
+		return
+	}
+
+	//Success
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusOK)
+	response.Write(dataMarshalled)
+}
+
+func (cfg *apiConfig) handlerDisableTOTP(response http.ResponseWriter, request *http.Request) {
+	//Validate credentials sent.
+	//Get the user token from the request.
+	userToken, err := auth.GetBearerToken(request.Header)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusUnauthorized)
+		response.Write([]byte("Unathorized: Please login first."))
+		return
+	}
+
+	userID, err := cfg.utilityValidateJWT(userToken)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusUnauthorized)
+		response.Write([]byte("Unathorized: credentials invalid. Please login again."))
+		return
+	}
+
+	decoder := json.NewDecoder(request.Body)
+	requestParams := secondFactorRequest{}
+	err = decoder.Decode(&requestParams)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: Did not understand request."))
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Unable to turn off two-factor authentication."))
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	throttle := utilitySecondFactorThrottle(userID)
+	_, retryAfter, err := cfg.utilityReserveThrottles([]loginThrottle{throttle})
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Unable to turn off two-factor authentication."))
+		return
+	}
+	if retryAfter > 0 {
+		utilityWriteTooManyCodes(response, retryAfter)
+		return
+	}
+
+	//A stolen access token alone is not enough to turn it off.
+	valid, err := cfg.utilityCheckSecondFactor(qtx, userID, requestParams)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Unable to turn off two-factor authentication."))
+		return
+	}
+	if !valid {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusForbidden)
+		response.Write([]byte("Forbidden: Incorrect code."))
+		return
+	}
+
+	//A right code wipes out the misses before it.
+	cfg.utilityClearThrottle(throttle)
+
+	err = qtx.DeleteTOTPCredential(context.Background(), userID)
+	if err == nil {
+		err = qtx.DeleteRecoveryCodes(context.Background(), userID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Unable to turn off two-factor authentication."))
+		return
+	}
+
+	//Success
+	response.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerRegenerateRecoveryCodes(response http.ResponseWriter, request *http.Request) {
+	//Validate credentials sent.
+	//Get the user token from the request.
+	userToken, err := auth.GetBearerToken(request.Header)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusUnauthorized)
+		response.Write([]byte("Unathorized: Please login first."))
+		return
+	}
+
+	userID, err := cfg.utilityValidateJWT(userToken)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusUnauthorized)
+		response.Write([]byte("Unathorized: credentials invalid. Please login again."))
+		return
+	}
+
+	decoder := json.NewDecoder(request.Body)
+	requestParams := secondFactorRequest{}
+	err = decoder.Decode(&requestParams)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Bad Request: Did not understand request."))
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Unable to make recovery codes."))
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	totpCredential, err := qtx.GetTOTPCredential(context.Background(), userID)
+	if err == sql.ErrNoRows || (err == nil && !totpCredential.ConfirmedAt.Valid) {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusConflict)
+		response.Write([]byte("Conflict: Two-factor authentication is not on."))
+		return
+	}
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Unable to make recovery codes."))
+		return
+	}
+
+	throttle := utilitySecondFactorThrottle(userID)
+	_, retryAfter, err := cfg.utilityReserveThrottles([]loginThrottle{throttle})
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Unable to make recovery codes."))
+		return
+	}
+	if retryAfter > 0 {
+		utilityWriteTooManyCodes(response, retryAfter)
+		return
+	}
+
+	valid, err := cfg.utilityCheckSecondFactor(qtx, userID, secondFactorRequest{Code: requestParams.Code})
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Unable to make recovery codes."))
+		return
+	}
+	if !valid {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusForbidden)
+		response.Write([]byte("Forbidden: Incorrect code."))
+		return
+	}
+
+	//A right code wipes out the misses before it.
+	cfg.utilityClearThrottle(throttle)
+
+	//The old codes stop working as soon as the new ones exist.
+	recoveryCodes, err := utilityMakeRecoveryCodes(qtx, userID)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Unable to make recovery codes."))
+		return
+	}
+
+	dataMarshalled, err := json.Marshal(recoveryCodesResponse{RecoveryCodes: recoveryCodes})
+	if err != nil {
+		response.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		response.WriteHeader(http.StatusInternalServerError)
+		response.Write([]byte("Internal Server Error: Failed create response."))
+		return
+	}
+
+	//Success
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusOK)
+	response.Write(dataMarshalled)
+}